@@ -2,13 +2,31 @@ import type {
   ConfigMetadata,
   BackupInfo,
   BackupDiffResponse,
+  DiffOptions,
+  ConfigVersion,
+  SnapshotDiffResponse,
+  StructuralDiffResponse,
   AppSettings,
+  NewDefaultConfig,
   UpdateSettingsResponse,
+  SettingsValidation,
   RestoreBackupResponse,
+  BackupJob,
+  HABackup,
 } from "./types";
 
 const API_BASE = window.location.href.replace(/\/+$/, "") || "";
 
+function diffQuery(options: DiffOptions): URLSearchParams {
+  const params = new URLSearchParams();
+  for (const [key, value] of Object.entries(options)) {
+    if (value !== undefined) {
+      params.set(key, String(value));
+    }
+  }
+  return params;
+}
+
 export class ApiClient {
   async getConfigs(): Promise<ConfigMetadata[]> {
     const response = await fetch(`${API_BASE}/configs`);
@@ -19,7 +37,7 @@ export class ApiClient {
   }
 
   async getConfigBackups(group: string, id: string): Promise<BackupInfo[]> {
-    const response = await fetch(`${API_BASE}/configs/${group}/${id}/backups`);
+    const response = await fetch(`${API_BASE}/configs/${encodeURIComponent(group)}/${encodeURIComponent(id)}/backups`);
     if (!response.ok) {
       throw new Error(`Failed to fetch backups: ${response.statusText}`);
     }
@@ -29,10 +47,13 @@ export class ApiClient {
   async getBackupContent(
     group: string,
     id: string,
-    filename: string
+    filename: string,
+    raw = false
   ): Promise<string> {
     const response = await fetch(
-      `${API_BASE}/configs/${group}/${id}/backups/${filename}`
+      `${API_BASE}/configs/${encodeURIComponent(group)}/${encodeURIComponent(id)}/backups/${filename}${
+        raw ? "?raw=true" : ""
+      }`
     );
     if (!response.ok) {
       throw new Error(`Failed to fetch backup content: ${response.statusText}`);
@@ -44,12 +65,13 @@ export class ApiClient {
     group: string,
     id: string,
     leftFilename: string,
-    rightFilename: string
+    rightFilename: string,
+    options: DiffOptions = {}
   ): Promise<BackupDiffResponse> {
     const response = await fetch(
-      `${API_BASE}/configs/${group}/${id}/compare/${encodeURIComponent(
+      `${API_BASE}/configs/${encodeURIComponent(group)}/${encodeURIComponent(id)}/compare/${encodeURIComponent(
         leftFilename
-      )}/diff/${encodeURIComponent(rightFilename)}`
+      )}/diff/${encodeURIComponent(rightFilename)}?${diffQuery(options)}`
     );
     if (!response.ok) {
       throw new Error(`Failed to fetch backup diff: ${response.statusText}`);
@@ -57,6 +79,86 @@ export class ApiClient {
     return response.json();
   }
 
+  async compareWithLive(
+    group: string,
+    id: string,
+    filename: string,
+    options: DiffOptions = {}
+  ): Promise<BackupDiffResponse> {
+    const response = await fetch(
+      `${API_BASE}/configs/${encodeURIComponent(group)}/${encodeURIComponent(id)}/compare/${encodeURIComponent(
+        filename
+      )}/live?${diffQuery(options)}`
+    );
+    if (!response.ok) {
+      throw new Error(
+        `Failed to compare with the live config: ${response.statusText}`
+      );
+    }
+    return response.json();
+  }
+
+  async compareVersions(
+    left: ConfigVersion,
+    right: ConfigVersion,
+    options: DiffOptions = {}
+  ): Promise<BackupDiffResponse> {
+    const params = diffQuery(options);
+    params.set("leftGroup", left.group);
+    params.set("leftId", left.id);
+    params.set("left", left.filename ?? "live");
+    params.set("rightGroup", right.group);
+    params.set("rightId", right.id);
+    params.set("right", right.filename ?? "live");
+    const response = await fetch(`${API_BASE}/compare?${params}`);
+    if (!response.ok) {
+      throw new Error(`Failed to compare versions: ${response.statusText}`);
+    }
+    return response.json();
+  }
+
+  // Changes across every config between two ISO timestamps, or since from when to is omitted
+  async getChanges(
+    from: string,
+    to?: string,
+    includeDiffs = false,
+    options: DiffOptions = {}
+  ): Promise<SnapshotDiffResponse> {
+    const params = diffQuery(options);
+    params.set("from", from);
+    if (to) {
+      params.set("to", to);
+    }
+    if (includeDiffs) {
+      params.set("diffs", "true");
+    }
+    const response = await fetch(`${API_BASE}/changes?${params}`);
+    if (!response.ok) {
+      throw new Error(`Failed to fetch changes: ${response.statusText}`);
+    }
+    return response.json();
+  }
+
+  async compareBackupsStructurally(
+    group: string,
+    id: string,
+    leftFilename: string,
+    rightFilename: string,
+    options: DiffOptions = {}
+  ): Promise<StructuralDiffResponse> {
+    const response = await fetch(
+      `${API_BASE}/configs/${encodeURIComponent(group)}/${encodeURIComponent(id)}/compare/${encodeURIComponent(
+        leftFilename
+      )}/structural/${encodeURIComponent(rightFilename)}?${diffQuery(options)}`
+    );
+    if (!response.ok) {
+      throw new Error(
+        `Failed to fetch structural diff: ${response.statusText}`
+      );
+    }
+    return response.json();
+  }
+
   async getSettings(): Promise<AppSettings> {
     const response = await fetch(`${API_BASE}/settings`);
     if (!response.ok) {
@@ -65,6 +167,16 @@ export class ApiClient {
     return response.json();
   }
 
+  async getNewDefaultConfigs(): Promise<NewDefaultConfig[]> {
+    const response = await fetch(`${API_BASE}/settings/defaults`);
+    if (!response.ok) {
+      throw new Error(
+        `Failed to fetch new default configs: ${response.statusText}`
+      );
+    }
+    return response.json();
+  }
+
   async updateSettings(settings: AppSettings): Promise<UpdateSettingsResponse> {
     const response = await fetch(`${API_BASE}/settings`, {
       method: "PUT",
@@ -79,13 +191,67 @@ export class ApiClient {
     return response.json();
   }
 
+  async validateSettings(settings: AppSettings): Promise<SettingsValidation> {
+    const response = await fetch(`${API_BASE}/settings/validate`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify(settings),
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to validate settings: ${response.statusText}`);
+    }
+    return response.json();
+  }
+
+  async getSettingsHistory(): Promise<BackupInfo[]> {
+    const response = await fetch(`${API_BASE}/settings/history`);
+    if (!response.ok) {
+      throw new Error(
+        `Failed to fetch settings history: ${response.statusText}`
+      );
+    }
+    return response.json();
+  }
+
+  async getSettingsVersionDiff(
+    leftFilename: string,
+    rightFilename: string
+  ): Promise<BackupDiffResponse> {
+    const response = await fetch(
+      `${API_BASE}/settings/history/${encodeURIComponent(
+        leftFilename
+      )}/diff/${encodeURIComponent(rightFilename)}`
+    );
+    if (!response.ok) {
+      throw new Error(
+        `Failed to compare settings versions: ${response.statusText}`
+      );
+    }
+    return response.json();
+  }
+
+  async rollbackSettings(filename: string): Promise<UpdateSettingsResponse> {
+    const response = await fetch(
+      `${API_BASE}/settings/history/${encodeURIComponent(filename)}/rollback`,
+      {
+        method: "POST",
+      }
+    );
+    if (!response.ok) {
+      throw new Error(`Failed to roll back settings: ${response.statusText}`);
+    }
+    return response.json();
+  }
+
   async restoreBackup(
     group: string,
     id: string,
     filename: string
   ): Promise<RestoreBackupResponse> {
     const response = await fetch(
-      `${API_BASE}/configs/${group}/${id}/backups/${encodeURIComponent(
+      `${API_BASE}/configs/${encodeURIComponent(group)}/${encodeURIComponent(id)}/backups/${encodeURIComponent(
         filename
       )}/restore`,
       {
@@ -98,8 +264,8 @@ export class ApiClient {
     return response.json();
   }
 
-  async triggerBackup(): Promise<{ status: string }> {
-    const response = await fetch(`${API_BASE}/backup`, {
+  async triggerBackup(fullRehash = false): Promise<BackupJob> {
+    const response = await fetch(`${API_BASE}/backup${fullRehash ? "?full=true" : ""}`, {
       method: "POST",
     });
     if (!response.ok) {
@@ -108,13 +274,45 @@ export class ApiClient {
     return response.json();
   }
 
+  async getJob(id: string): Promise<BackupJob> {
+    const response = await fetch(`${API_BASE}/jobs/${encodeURIComponent(id)}`);
+    if (!response.ok) {
+      throw new Error(`Failed to fetch job: ${response.statusText}`);
+    }
+    return response.json();
+  }
+
+  async getHABackups(): Promise<HABackup[]> {
+    const response = await fetch(`${API_BASE}/import`);
+    if (!response.ok) {
+      throw new Error(
+        `Failed to fetch Home Assistant backups: ${response.statusText}`
+      );
+    }
+    return response.json();
+  }
+
+  async importHABackups(archives: string[] = []): Promise<BackupJob> {
+    const response = await fetch(`${API_BASE}/import`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+      },
+      body: JSON.stringify({ archives }),
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to import backups: ${response.statusText}`);
+    }
+    return response.json();
+  }
+
   async deleteBackup(
     group: string,
     id: string,
     filename: string
   ): Promise<{ status: string }> {
     const response = await fetch(
-      `${API_BASE}/configs/${group}/${id}/backups/${encodeURIComponent(
+      `${API_BASE}/configs/${encodeURIComponent(group)}/${encodeURIComponent(id)}/backups/${encodeURIComponent(
         filename
       )}`,
       {
@@ -128,7 +326,7 @@ export class ApiClient {
   }
 
   async deleteAllBackups(group: string, id: string): Promise<{ status: string }> {
-    const response = await fetch(`${API_BASE}/configs/${group}/${id}`, {
+    const response = await fetch(`${API_BASE}/configs/${encodeURIComponent(group)}/${encodeURIComponent(id)}`, {
       method: "DELETE",
     });
     if (!response.ok) {
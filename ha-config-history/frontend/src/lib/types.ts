@@ -5,6 +5,9 @@ export interface ConfigMetadata {
   lastHash?: string;
   backupCount: number;
   backupsSize: number;
+  formatOnlyChanges?: number;
+  lastFormatOnlyChange?: string;
+  binary?: boolean;
 }
 
 export interface BackupInfo {
@@ -14,7 +17,7 @@ export interface BackupInfo {
 }
 
 export interface BackupDiffResponse {
-  type: "diff" | "content";
+  type: "diff" | "content" | "binary";
   unifiedDiff?: string;
   content?: string;
   oldContent?: string;
@@ -22,36 +25,171 @@ export interface BackupDiffResponse {
   oldFilename?: string;
   newFilename?: string;
   isFirstBackup: boolean;
+  formatOnly?: boolean;
+  summary?: string;
+  hunks?: DiffHunk[];
+  liveMissing?: boolean;
+  tooLarge?: boolean;
+  approximate?: boolean;
+  oldConfig?: ConfigVersion;
+  newConfig?: ConfigVersion;
 }
 
-export type ComparisonMode = "previous" | "current" | "two-backups";
+// A version of a config: a backup filename, or "live" for the current content
+export interface ConfigVersion {
+  group: string;
+  id: string;
+  filename?: string;
+}
+
+export type SnapshotChangeType = "added" | "removed" | "modified";
+
+// A config item changed between two points in time, with the versions in effect at each
+export interface SnapshotChange {
+  group: string;
+  id: string;
+  friendlyName: string;
+  type: SnapshotChangeType;
+  oldFilename?: string;
+  newFilename?: string;
+  diff?: BackupDiffResponse;
+}
+
+export interface SnapshotDiffResponse {
+  from: string;
+  // Missing when compared with the live config
+  to?: string;
+  changes: SnapshotChange[];
+}
+
+export type DiffGranularity = "word" | "char";
+
+export type DiffAlgorithm = "myers" | "patience" | "histogram";
+
+export interface DiffOptions {
+  granularity?: DiffGranularity;
+  algorithm?: DiffAlgorithm;
+  // Unchanged lines around each hunk, 3 by default
+  context?: number;
+  ignoreWhitespace?: boolean;
+  ignoreComments?: boolean;
+  ignoreKeyOrder?: boolean;
+  // Compare the stored bytes, without indenting JSON first
+  raw?: boolean;
+  // Largest file compared line by line, in bytes
+  maxSize?: number;
+  // Time budget as a Go duration, eg. "2s"
+  timeout?: string;
+}
+
+export interface DiffSpan {
+  start: number;
+  end: number;
+}
+
+export interface DiffLine {
+  kind: "context" | "added" | "removed";
+  oldLine?: number;
+  newLine?: number;
+  content: string;
+  spans?: DiffSpan[];
+}
+
+export interface DiffHunk {
+  oldStart: number;
+  oldLines: number;
+  newStart: number;
+  newLines: number;
+  lines: DiffLine[];
+}
+
+export interface StructuralChange {
+  type: "added" | "removed" | "changed";
+  path: string;
+  oldValue?: unknown;
+  newValue?: unknown;
+}
+
+export interface StructuralDiffResponse {
+  oldFilename: string;
+  newFilename: string;
+  changes: StructuralChange[];
+  approximate?: boolean;
+}
+
+export type ComparisonMode = "previous" | "current" | "two-backups" | "live";
 
 export interface ConfigBackupOptions {
   name: string;
   path: string;
-  backupType: "multiple" | "single" | "directory";
+  backupType:
+    | "multiple"
+    | "single"
+    | "directory"
+    | "mapping"
+    | "includes"
+    | "json";
   maxBackups?: number;
   maxBackupAgeDays?: number;
   idNode?: string;
   friendlyNameNode?: string;
+  itemsPath?: string;
   includeFilePatterns?: string[];
   excludeFilePatterns?: string[];
+  ignoreKeys?: string[];
+  ignoreLinePatterns?: string[];
+  canonicalHash?: boolean;
+  watcher?: "auto" | "fsnotify" | "poll";
+  maxFileSizeBytes?: number;
+}
+
+export interface NewDefaultConfig {
+  // key is added to knownDefaultConfigs once the default is added or dismissed
+  key: string;
+  // update is set when the settings track the path with other options
+  update?: boolean;
+  config: ConfigBackupOptions;
 }
 
 export interface AppSettings {
+  schemaVersion?: number;
   homeAssistantConfigDir: string;
   backupDir: string;
+  homeAssistantBackupDir?: string;
   port: string;
   cronSchedule?: string;
   defaultMaxBackups?: number;
   defaultMaxBackupAgeDays?: number;
+  pollIntervalSeconds?: number;
+  backupWorkers?: number;
+  queueDepth?: number;
   configs: ConfigBackupOptions[];
+  knownDefaultConfigs?: string[];
+}
+
+export interface SettingsChanges {
+  added: string[];
+  removed: string[];
+  changed: string[];
+  job?: BackupJob;
+}
+
+export interface SettingsIssue {
+  field: string;
+  message: string;
+}
+
+export interface SettingsValidation {
+  errors: SettingsIssue[];
+  warnings: SettingsIssue[];
 }
 
 export interface UpdateSettingsResponse {
   success: boolean;
   warnings?: string[];
   error?: string;
+  changes?: SettingsChanges;
+  validation?: SettingsValidation;
 }
 
 export interface RestoreBackupResponse {
@@ -59,3 +197,38 @@ export interface RestoreBackupResponse {
   message?: string;
   error?: string;
 }
+
+export interface BackupJob {
+  id: string;
+  kind: "manual" | "scheduled" | "startup" | "resume" | "settings" | "import";
+  status: "queued" | "running" | "completed" | "failed" | "cancelled";
+  createdAt: string;
+  startedAt?: string;
+  finishedAt?: string;
+  itemsQueued: number;
+  itemsProcessed: number;
+  filesSkipped: number;
+  fullRehash: boolean;
+  versionsCreated: number;
+  errors: string[];
+}
+
+export interface HABackup {
+  path: string;
+  slug: string;
+  name: string;
+  date: string;
+  type: string;
+  protected: boolean;
+}
+
+export interface Pause {
+  since: string;
+  until?: string;
+  reason?: string;
+}
+
+export interface PauseState {
+  global?: Pause;
+  configs: Record<string, Pause>;
+}
@@ -6,6 +6,7 @@ import (
 	"ha-config-history/internal/types"
 	"maps"
 	"net/http"
+	"os"
 	"slices"
 	"sort"
 
@@ -32,10 +33,8 @@ func GetConfigsHandler(s *core.Server) func(c *gin.Context) {
 
 func ProcessConfigsHandler(s *core.Server) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		s.ProcessAllConfigOptions()
-		c.JSON(http.StatusOK, gin.H{
-			"status": "backup process completed",
-		})
+		job := s.StartBackupJob(core.JobKindManual, c.Query("full") == "true")
+		c.JSON(http.StatusAccepted, job.Info())
 	}
 }
 
@@ -44,7 +43,7 @@ func ListConfigBackupsHandler(s *core.Server) func(c *gin.Context) {
 		group := c.Param("group")
 		id := c.Param("id")
 
-		backups, err := io.ListConfigBackups(s.AppSettings.BackupDir, group, id)
+		backups, err := io.ListConfigBackups(s.Settings().BackupDir, group, id)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error": err.Error(),
@@ -62,7 +61,7 @@ func GetConfigBackupHandler(s *core.Server) func(c *gin.Context) {
 		id := c.Param("id")
 		filename := c.Param("filename")
 
-		content, err := io.GetConfigBackup(s.AppSettings.BackupDir, group, id, filename)
+		backupPath, err := io.GetConfigBackupPath(s.Settings().BackupDir, group, id, filename)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error": err.Error(),
@@ -70,8 +69,41 @@ func GetConfigBackupHandler(s *core.Server) func(c *gin.Context) {
 			return
 		}
 
-		c.Header("Content-Type", "application/x-yaml")
-		c.String(http.StatusOK, string(content))
+		head, err := io.ReadFileHead(backupPath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		backupType := ""
+		s.State.Mu.RLock()
+		if metadata, exists := s.State.CachedConfigMetadata[types.ConfigIdentifier{Group: group, ID: id}]; exists {
+			backupType = metadata.BackupType
+		}
+		s.State.Mu.RUnlock()
+
+		contentType := io.BackupContentType(id, backupType, head)
+		if io.IsBinary(head) || c.Query("raw") == "true" {
+			c.Header("Content-Type", contentType)
+			c.File(backupPath)
+			return
+		}
+
+		// JSON is indented for viewing, whatever the file is called, as .storage files have no extension. The backup
+		// itself is left as it is.
+		content, err := os.ReadFile(backupPath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if formatted, ok := types.FormatJSON(content); ok {
+			content, contentType = formatted, "application/json"
+		}
+		c.Data(http.StatusOK, contentType, content)
 	}
 }
 
@@ -100,7 +132,8 @@ func DeleteConfigBackupHandler(s *core.Server) func(c *gin.Context) {
 			return
 		}
 
-		err := io.DeleteBackup(s.AppSettings.BackupDir, group, id, filename)
+		backupDir := s.Settings().BackupDir
+		err := io.DeleteBackup(backupDir, group, id, filename)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": err.Error(),
@@ -108,7 +141,7 @@ func DeleteConfigBackupHandler(s *core.Server) func(c *gin.Context) {
 			return
 		}
 
-		metadata, err := io.UpdateMetadataAfterDeletion(s.AppSettings.BackupDir, group, id)
+		metadata, err := io.UpdateMetadataAfterDeletion(backupDir, group, id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": err.Error(),
@@ -125,6 +158,7 @@ func DeleteConfigBackupHandler(s *core.Server) func(c *gin.Context) {
 			delete(s.State.CachedConfigMetadata, types.ConfigIdentifier{Group: group, ID: id})
 			s.State.Mu.Unlock()
 		}
+		s.InvalidateStatCache(group)
 
 		c.JSON(http.StatusOK, gin.H{
 			"status": "backup deleted successfully",
@@ -150,7 +184,7 @@ func DeleteAllConfigBackupsHandler(s *core.Server) func(c *gin.Context) {
 			return
 		}
 
-		err := io.DeleteAllBackups(s.AppSettings.BackupDir, group, id)
+		err := io.DeleteAllBackups(s.Settings().BackupDir, group, id)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": err.Error(),
@@ -161,6 +195,7 @@ func DeleteAllConfigBackupsHandler(s *core.Server) func(c *gin.Context) {
 		s.State.Mu.Lock()
 		delete(s.State.CachedConfigMetadata, types.ConfigIdentifier{Group: group, ID: id})
 		s.State.Mu.Unlock()
+		s.InvalidateStatCache(group)
 
 		c.JSON(http.StatusOK, gin.H{
 			"status": "all backups deleted successfully",
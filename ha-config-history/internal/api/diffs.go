@@ -1,27 +1,19 @@
 package api
 
 import (
-	"fmt"
+	"errors"
 	"ha-config-history/internal/core"
-	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/hexops/gotextdiff"
-	"github.com/hexops/gotextdiff/myers"
-	"github.com/hexops/gotextdiff/span"
 )
 
-type BackupDiffResponse struct {
-	Type          string `json:"type"`
-	UnifiedDiff   string `json:"unifiedDiff"`
-	Content       string `json:"content"`
-	OldContent    string `json:"oldContent"`
-	NewContent    string `json:"newContent"`
-	OldFilename   string `json:"oldFilename"`
-	NewFilename   string `json:"newFilename"`
-	IsFirstBackup bool   `json:"isFirstBackup"`
-}
+type BackupDiffResponse = core.BackupDiff
+
+type StructuralDiffResponse = core.StructuralDiff
 
 func GetBackupDiffHandler(s *core.Server) func(c *gin.Context) {
 	return func(c *gin.Context) {
@@ -30,29 +22,183 @@ func GetBackupDiffHandler(s *core.Server) func(c *gin.Context) {
 		leftFilename := c.Param("left")
 		rightFilename := c.Param("right")
 
-		leftContent, err := io.GetConfigBackup(s.AppSettings.BackupDir, group, id, leftFilename)
+		options, ok := diffOptions(c)
+		if !ok {
+			return
+		}
+
+		diff, err := core.DiffBackups(s.Settings().BackupDir, group, id, leftFilename, rightFilename, options)
+		var notFound *core.BackupNotFoundError
+		if errors.As(err, &notFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error loading " + notFound.Side + " backup file"})
+			return
+		}
+		if errors.Is(err, core.ErrIgnoreKeyOrderUnsupported) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, diff)
+	}
+}
+
+// GetLiveDiffHandler compares a backup with the current content of its config in the Home Assistant config directory.
+// Single file configs have one item, whose id is the same as the group, and other ids return 404 like unknown groups.
+func GetLiveDiffHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		options, ok := diffOptions(c)
+		if !ok {
+			return
+		}
+
+		diff, err := s.DiffBackupAgainstLive(c.Param("group"), c.Param("id"), c.Param("left"), options)
+		var notFound *core.BackupNotFoundError
+		if errors.As(err, &notFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error loading " + notFound.Side + " backup file"})
+			return
+		}
+		if errors.Is(err, core.ErrConfigNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Config not found"})
+			return
+		}
+		if errors.Is(err, core.ErrIgnoreKeyOrderUnsupported) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, diff)
+	}
+}
+
+// CompareVersionsHandler compares versions of any two configs, given as leftGroup, leftId and left, and rightGroup,
+// rightId and right query parameters. Either version can be "live" for the config's current content.
+func CompareVersionsHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		left := core.ConfigVersion{
+			ConfigIdentifier: types.ConfigIdentifier{Group: c.Query("leftGroup"), ID: c.Query("leftId")},
+			Filename:         c.Query("left"),
+		}
+		right := core.ConfigVersion{
+			ConfigIdentifier: types.ConfigIdentifier{Group: c.Query("rightGroup"), ID: c.Query("rightId")},
+			Filename:         c.Query("right"),
+		}
+		for _, version := range []core.ConfigVersion{left, right} {
+			if version.Group == "" || version.ID == "" || version.Filename == "" {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "leftGroup, leftId, left, rightGroup, rightId and right are required",
+				})
+				return
+			}
+		}
+
+		options, ok := diffOptions(c)
+		if !ok {
+			return
+		}
+
+		diff, err := s.DiffVersions(left, right, options)
+		var notFound *core.BackupNotFoundError
+		if errors.As(err, &notFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error loading " + notFound.Side + " backup file"})
+			return
+		}
+		if errors.Is(err, core.ErrConfigNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Config not found"})
+			return
+		}
+		if errors.Is(err, core.ErrIgnoreKeyOrderUnsupported) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Error loading left backup file"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, diff)
+	}
+}
+
+// GetStructuralDiffHandler compares two backups of a config value by value, with the key path of each change. The
+// maxSize and timeout diff options limit the work done, other options don't apply.
+func GetStructuralDiffHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		group := c.Param("group")
+		id := c.Param("id")
+		leftFilename := c.Param("left")
+		rightFilename := c.Param("right")
+
+		options, ok := diffOptions(c)
+		if !ok {
 			return
 		}
 
-		rightContent, err := io.GetConfigBackup(s.AppSettings.BackupDir, group, id, rightFilename)
+		diff, err := core.DiffBackupsStructurally(s.Settings().BackupDir, group, id, leftFilename, rightFilename,
+			options)
+		var notFound *core.BackupNotFoundError
+		if errors.As(err, &notFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error loading " + notFound.Side + " backup file"})
+			return
+		}
+		if errors.Is(err, core.ErrStructuralDiffUnsupported) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Error loading right backup file"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		edits := myers.ComputeEdits(span.URIFromPath(leftFilename), string(leftContent), string(rightContent))
-		diff := fmt.Sprint(gotextdiff.ToUnified(leftFilename, rightFilename, string(leftContent), edits))
+		c.JSON(http.StatusOK, diff)
+	}
+}
+
+// diffOptions reads diff options from the query string, responding with 400 when they're invalid
+func diffOptions(c *gin.Context) (core.DiffOptions, bool) {
+	options := core.DiffOptions{
+		Granularity:      c.Query("granularity"),
+		Algorithm:        c.Query("algorithm"),
+		IgnoreWhitespace: c.Query("ignoreWhitespace") == "true",
+		IgnoreComments:   c.Query("ignoreComments") == "true",
+		IgnoreKeyOrder:   c.Query("ignoreKeyOrder") == "true",
+		Raw:              c.Query("raw") == "true",
+	}
+	if value := c.Query("context"); value != "" {
+		context, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid context: " + value})
+			return options, false
+		}
+		options.Context = &context
+	}
+	if value := c.Query("maxSize"); value != "" {
+		maxSize, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max size: " + value})
+			return options, false
+		}
+		options.MaxSize = maxSize
+	}
+	if value := c.Query("timeout"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timeout: " + value})
+			return options, false
+		}
+		options.Timeout = timeout
+	}
 
-		c.JSON(http.StatusOK, BackupDiffResponse{
-			Type:          "diff",
-			UnifiedDiff:   diff,
-			OldContent:    string(leftContent),
-			NewContent:    string(rightContent),
-			OldFilename:   leftFilename,
-			NewFilename:   rightFilename,
-			IsFirstBackup: false,
-		})
+	if err := options.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return options, false
 	}
+	return options, true
 }
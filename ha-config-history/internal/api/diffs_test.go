@@ -0,0 +1,542 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"ha-config-history/internal/api"
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBinaryBackups(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	setup := func(t *testing.T, id string, left, right []byte) *gin.Engine {
+		backupDir := t.TempDir()
+		configDir := filepath.Join(backupDir, "www", id)
+		if err := os.MkdirAll(configDir, 0755); err != nil {
+			t.Fatalf("Failed to create backup dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(configDir, "20240101T000000.backup"), left, 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(configDir, "20240102T000000.backup"), right, 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+
+		server := core.NewServer(&types.AppSettings{
+			HomeAssistantConfigDir: t.TempDir(),
+			BackupDir:              backupDir,
+		})
+
+		router := gin.New()
+		router.GET("/configs/:group/:id/backups/:filename", api.GetConfigBackupHandler(server))
+		router.GET("/configs/:group/:id/compare/:left/diff/:right", api.GetBackupDiffHandler(server))
+		return router
+	}
+
+	png := append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), bytes.Repeat([]byte{0}, 2048)...)
+	largerPng := append(png, bytes.Repeat([]byte{1}, 2048)...)
+
+	t.Run("Diff summarises binary changes", func(t *testing.T) {
+		router := setup(t, "logo.png", png, largerPng)
+
+		w := httptest.NewRecorder()
+		url := "/configs/www/logo.png/compare/20240101T000000.backup/diff/20240102T000000.backup"
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d", w.Code)
+		}
+
+		var response api.BackupDiffResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if response.Type != "binary" {
+			t.Errorf("Expected binary diff, got: %s", response.Type)
+		}
+		if response.Summary != "binary changed (2.0 KB → 4.0 KB)" {
+			t.Errorf("Unexpected summary: %s", response.Summary)
+		}
+		if response.OldContent != "" || response.NewContent != "" || response.UnifiedDiff != "" {
+			t.Error("Expected no raw content for binary diff")
+		}
+	})
+
+	t.Run("Serves binary backups with their content type", func(t *testing.T) {
+		router := setup(t, "logo.png", png, largerPng)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/configs/www/logo.png/backups/20240102T000000.backup", nil)
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d", w.Code)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "image/png" {
+			t.Errorf("Expected image/png, got: %s", contentType)
+		}
+		if !bytes.Equal(w.Body.Bytes(), largerPng) {
+			t.Error("Expected backup content to be served unchanged")
+		}
+	})
+
+	t.Run("Text backups are still served as YAML", func(t *testing.T) {
+		router := setup(t, "automations.yaml", []byte("a: 1\n"), []byte("a: 2\n"))
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/configs/www/automations.yaml/backups/20240102T000000.backup", nil)
+		router.ServeHTTP(w, req)
+
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/x-yaml" {
+			t.Errorf("Expected application/x-yaml, got: %s", contentType)
+		}
+	})
+}
+
+func TestJSONBackups(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	left := []byte(`{"version":1,"data":{"name":"Kitchen","area":"downstairs"}}`)
+	right := []byte(`{"version":1,"data":{"name":"Kitchen","area":"upstairs"}}`)
+
+	backupDir := t.TempDir()
+	configDir := filepath.Join(backupDir, ".storage", "core.area_registry")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create backup dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "20240101T000000.backup"), left, 0644); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "20240102T000000.backup"), right, 0644); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+
+	server := core.NewServer(&types.AppSettings{
+		HomeAssistantConfigDir: t.TempDir(),
+		BackupDir:              backupDir,
+	})
+	router := gin.New()
+	router.GET("/configs/:group/:id/backups/:filename", api.GetConfigBackupHandler(server))
+	router.GET("/configs/:group/:id/compare/:left/diff/:right", api.GetBackupDiffHandler(server))
+
+	request := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	t.Run("Backups are indented for viewing", func(t *testing.T) {
+		w := request("/configs/.storage/core.area_registry/backups/20240102T000000.backup")
+
+		expected := "{\n  \"version\": 1,\n  \"data\": {\n    \"name\": \"Kitchen\",\n    \"area\": \"upstairs\"\n  }\n}\n"
+		if w.Body.String() != expected {
+			t.Errorf("Expected indented JSON, got:\n%s", w.Body.String())
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Expected application/json, got: %s", contentType)
+		}
+	})
+
+	t.Run("Raw backups are served unchanged", func(t *testing.T) {
+		w := request("/configs/.storage/core.area_registry/backups/20240102T000000.backup?raw=true")
+		if !bytes.Equal(w.Body.Bytes(), right) {
+			t.Errorf("Expected the stored bytes, got:\n%s", w.Body.String())
+		}
+	})
+
+	t.Run("Diffs are of the indented JSON", func(t *testing.T) {
+		url := "/configs/.storage/core.area_registry/compare/20240101T000000.backup/diff/20240102T000000.backup"
+
+		var response api.BackupDiffResponse
+		if err := json.Unmarshal(request(url).Body.Bytes(), &response); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !strings.Contains(response.UnifiedDiff, "-    \"area\": \"downstairs\"\n+    \"area\": \"upstairs\"\n") ||
+			len(response.Hunks) != 1 || response.Hunks[0].OldLines != 6 {
+			t.Errorf("Expected only the changed line, got:\n%s", response.UnifiedDiff)
+		}
+
+		if err := json.Unmarshal(request(url+"?raw=true").Body.Bytes(), &response); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if response.OldContent != string(left) || !strings.Contains(response.UnifiedDiff, "+"+string(right)) {
+			t.Errorf("Expected a diff of the stored bytes, got:\n%s", response.UnifiedDiff)
+		}
+	})
+
+	t.Run("Stored backups are untouched", func(t *testing.T) {
+		content, err := os.ReadFile(filepath.Join(configDir, "20240101T000000.backup"))
+		if err != nil {
+			t.Fatalf("Failed to read backup: %v", err)
+		}
+		if !bytes.Equal(content, left) {
+			t.Errorf("Expected the backup to be unchanged, got:\n%s", content)
+		}
+	})
+}
+
+func TestStructuralDiff(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	setup := func(t *testing.T, group, id string, left, right []byte) *gin.Engine {
+		backupDir := t.TempDir()
+		configDir := filepath.Join(backupDir, group, id)
+		if err := os.MkdirAll(configDir, 0755); err != nil {
+			t.Fatalf("Failed to create backup dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(configDir, "20240101T000000.backup"), left, 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(configDir, "20240102T000000.backup"), right, 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+
+		server := core.NewServer(&types.AppSettings{
+			HomeAssistantConfigDir: t.TempDir(),
+			BackupDir:              backupDir,
+		})
+
+		router := gin.New()
+		router.GET("/configs/:group/:id/compare/:left/structural/:right", api.GetStructuralDiffHandler(server))
+		return router
+	}
+
+	request := func(router *gin.Engine, group, id, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		url := "/configs/" + group + "/" + id + "/compare/20240101T000000.backup/structural/20240102T000000.backup" +
+			query
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	t.Run("Lists changed values by key path", func(t *testing.T) {
+		router := setup(t, "automations.yaml", "1234",
+			[]byte("id: '1234'\nalias: Lights\ncondition:\n  - condition: state\n    entity_id: sun.sun\n"),
+			[]byte("id: '1234'\nalias: Lights on\n"))
+
+		w := request(router, "automations.yaml", "1234", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+
+		var response api.StructuralDiffResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(response.Changes) != 2 ||
+			response.Changes[0].Path != "alias" || response.Changes[0].Type != core.StructuralChangeChanged ||
+			response.Changes[1].Path != "condition" || response.Changes[1].Type != core.StructuralChangeRemoved {
+			t.Errorf("Unexpected changes: %+v", response.Changes)
+		}
+	})
+
+	t.Run("Binary backups can't be compared", func(t *testing.T) {
+		png := append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), bytes.Repeat([]byte{0}, 64)...)
+		router := setup(t, "www", "logo.png", png, png)
+
+		if w := request(router, "www", "logo.png", ""); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected status 422, got: %d", w.Code)
+		}
+	})
+
+	t.Run("Backups larger than the max size can't be compared", func(t *testing.T) {
+		router := setup(t, "automations.yaml", "1234", []byte("id: '1234'\nalias: Lights\n"),
+			[]byte("id: '1234'\nalias: Lights on\n"))
+
+		if w := request(router, "automations.yaml", "1234", "?maxSize=16"); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected status 422, got: %d", w.Code)
+		}
+	})
+
+	t.Run("Invalid options", func(t *testing.T) {
+		router := setup(t, "automations.yaml", "1234", []byte("id: '1234'\n"), []byte("id: '1234'\n"))
+
+		if w := request(router, "automations.yaml", "1234", "?timeout=soon"); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got: %d", w.Code)
+		}
+	})
+
+	t.Run("Missing backups", func(t *testing.T) {
+		router := setup(t, "automations.yaml", "1234", []byte("id: '1234'\n"), []byte("id: '1234'\n"))
+
+		if w := request(router, "automations.yaml", "5678", ""); w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got: %d", w.Code)
+		}
+	})
+}
+
+func TestBackupDiffOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	backupDir := t.TempDir()
+	configDir := filepath.Join(backupDir, "automations.yaml", "1234")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create backup dir: %v", err)
+	}
+	for filename, content := range map[string]string{
+		"20240101T000000.backup": "alias: Lights on at sunset\n",
+		"20240102T000000.backup": "alias: Lights on at sunrise\n",
+	} {
+		if err := os.WriteFile(filepath.Join(configDir, filename), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+	}
+
+	server := core.NewServer(&types.AppSettings{
+		HomeAssistantConfigDir: t.TempDir(),
+		BackupDir:              backupDir,
+	})
+	router := gin.New()
+	router.GET("/configs/:group/:id/compare/:left/diff/:right", api.GetBackupDiffHandler(server))
+
+	request := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		url := "/configs/automations.yaml/1234/compare/20240101T000000.backup/diff/20240102T000000.backup" + query
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	t.Run("Hunks mark the changed word", func(t *testing.T) {
+		w := request("")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+
+		var response api.BackupDiffResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(response.Hunks) != 1 || len(response.Hunks[0].Lines) != 2 {
+			t.Fatalf("Expected one hunk with two lines, got: %+v", response.Hunks)
+		}
+		added := response.Hunks[0].Lines[1]
+		if len(added.Spans) != 1 || added.Content[added.Spans[0].Start:added.Spans[0].End] != "sunrise" {
+			t.Errorf("Expected the changed word to be marked, got: %+v", added)
+		}
+	})
+
+	t.Run("Context and algorithm", func(t *testing.T) {
+		w := request("?context=0&algorithm=patience&ignoreWhitespace=true&timeout=500ms&maxSize=1024")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+
+		var response api.BackupDiffResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(response.Hunks) != 1 || response.Hunks[0].OldStart != 1 || response.Hunks[0].OldLines != 1 {
+			t.Errorf("Expected one hunk without context, got: %+v", response.Hunks)
+		}
+	})
+
+	t.Run("Files that can't be sorted by key", func(t *testing.T) {
+		invalid := filepath.Join(configDir, "20240103T000000.backup")
+		if err := os.WriteFile(invalid, []byte("alias: [unclosed\n"), 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+		defer os.Remove(invalid)
+
+		w := httptest.NewRecorder()
+		url := "/configs/automations.yaml/1234/compare/20240101T000000.backup/diff/20240103T000000.backup"
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url+"?ignoreKeyOrder=true", nil))
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected status 422, got: %d", w.Code)
+		}
+	})
+
+	t.Run("Invalid options", func(t *testing.T) {
+		for _, query := range []string{
+			"?granularity=sentence",
+			"?algorithm=minimal",
+			"?context=-1",
+			"?context=all",
+			"?maxSize=large",
+			"?timeout=5",
+			"?timeout=1h",
+		} {
+			if w := request(query); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400 for %s, got: %d", query, w.Code)
+			}
+		}
+	})
+}
+
+func TestLiveDiff(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	writeFile := func(t *testing.T, path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	setup := func(t *testing.T) (*types.AppSettings, *gin.Engine) {
+		settings := &types.AppSettings{
+			HomeAssistantConfigDir: t.TempDir(),
+			BackupDir:              t.TempDir(),
+			Configs: []*types.ConfigBackupOptions{
+				types.NewSingleConfigBackupOptions("Configuration", "configuration.yaml"),
+				types.NewDirectoryConfigBackupOptions("ESP Home", "esphome", []string{"*.yaml"}, nil),
+			},
+		}
+		server := core.NewServer(settings)
+
+		router := gin.New()
+		router.GET("/configs/:group/:id/compare/:left/live", api.GetLiveDiffHandler(server))
+		return settings, router
+	}
+
+	request := func(router *gin.Engine, url string) (*httptest.ResponseRecorder, api.BackupDiffResponse) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		var response api.BackupDiffResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	t.Run("Compares a backup with the live file", func(t *testing.T) {
+		settings, router := setup(t)
+		writeFile(t, filepath.Join(settings.BackupDir, "configuration.yaml", "configuration.yaml",
+			"20240101T000000.backup"), "homeassistant:\n  name: Home\n")
+		writeFile(t, filepath.Join(settings.HomeAssistantConfigDir, "configuration.yaml"),
+			"homeassistant:\n  name: Cottage\n")
+
+		url := "/configs/configuration.yaml/configuration.yaml/compare/20240101T000000.backup/live"
+		w, response := request(router, url)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+		if response.NewFilename != core.LiveFilename || response.LiveMissing ||
+			response.NewContent != "homeassistant:\n  name: Cottage\n" {
+			t.Errorf("Expected diff against the live file, got: %+v", response)
+		}
+	})
+
+	t.Run("Reports a live item that no longer exists", func(t *testing.T) {
+		settings, router := setup(t)
+		writeFile(t, filepath.Join(settings.BackupDir, "esphome", "kitchen.yaml", "20240101T000000.backup"),
+			"esphome:\n  name: kitchen\n")
+		writeFile(t, filepath.Join(settings.HomeAssistantConfigDir, "esphome", "office.yaml"), "esphome:\n")
+
+		w, response := request(router, "/configs/esphome/kitchen.yaml/compare/20240101T000000.backup/live")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+		if !response.LiveMissing || response.NewContent != "" {
+			t.Errorf("Expected the live item to be reported missing, got: %+v", response)
+		}
+	})
+
+	t.Run("Unknown config", func(t *testing.T) {
+		settings, router := setup(t)
+		writeFile(t, filepath.Join(settings.BackupDir, "scripts.yaml", "hello", "20240101T000000.backup"), "alias: Hi\n")
+
+		w, _ := request(router, "/configs/scripts.yaml/hello/compare/20240101T000000.backup/live")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got: %d", w.Code)
+		}
+	})
+
+	t.Run("Single file configs only have the item with their path", func(t *testing.T) {
+		settings, router := setup(t)
+		writeFile(t, filepath.Join(settings.BackupDir, "configuration.yaml", "secrets.yaml", "20240101T000000.backup"),
+			"api_key: old\n")
+		writeFile(t, filepath.Join(settings.HomeAssistantConfigDir, "configuration.yaml"), "homeassistant:\n")
+
+		w, _ := request(router, "/configs/configuration.yaml/secrets.yaml/compare/20240101T000000.backup/live")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got: %d\n%s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestCompareVersions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	settings := &types.AppSettings{
+		HomeAssistantConfigDir: t.TempDir(),
+		BackupDir:              t.TempDir(),
+		Configs: []*types.ConfigBackupOptions{
+			types.NewMultipleConfigBackupOptions("Automations", "automations.yaml", "id", "alias"),
+		},
+	}
+	backups := map[string]string{
+		"automations.yaml/kitchen/20240101T000000.backup": "id: kitchen\nalias: Kitchen lights\nmode: single\n",
+		"automations.yaml/hallway/20240102T000000.backup": "id: hallway\nalias: Hallway lights\nmode: single\n",
+	}
+	for path, content := range backups {
+		path = filepath.Join(settings.BackupDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create backup dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+	}
+	live := "- id: hallway\n  alias: Hallway lights\n  mode: restart\n"
+	livePath := filepath.Join(settings.HomeAssistantConfigDir, "automations.yaml")
+	if err := os.WriteFile(livePath, []byte(live), 0644); err != nil {
+		t.Fatalf("Failed to write automations: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/compare", api.CompareVersionsHandler(core.NewServer(settings)))
+
+	request := func(query string) (*httptest.ResponseRecorder, api.BackupDiffResponse) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/compare?"+query, nil))
+		var response api.BackupDiffResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	t.Run("Compares versions of different configs", func(t *testing.T) {
+		w, response := request("leftGroup=automations.yaml&leftId=kitchen&left=20240101T000000.backup" +
+			"&rightGroup=automations.yaml&rightId=hallway&right=20240102T000000.backup")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+
+		if !strings.Contains(response.UnifiedDiff, "--- automations.yaml/kitchen/20240101T000000.backup") ||
+			!strings.Contains(response.UnifiedDiff, "+alias: Hallway lights") {
+			t.Errorf("Expected diff labelled with each config, got:\n%s", response.UnifiedDiff)
+		}
+		if response.OldConfig == nil || response.OldConfig.ID != "kitchen" ||
+			response.NewConfig == nil || response.NewConfig.ID != "hallway" {
+			t.Errorf("Expected the compared configs, got: %+v %+v", response.OldConfig, response.NewConfig)
+		}
+	})
+
+	t.Run("Compares with the live version of another config", func(t *testing.T) {
+		_, response := request("leftGroup=automations.yaml&leftId=kitchen&left=20240101T000000.backup" +
+			"&rightGroup=automations.yaml&rightId=hallway&right=live")
+		if !strings.Contains(response.UnifiedDiff, "+mode: restart") || response.LiveMissing {
+			t.Errorf("Expected diff against the live automation, got:\n%s", response.UnifiedDiff)
+		}
+	})
+
+	t.Run("Missing parameters", func(t *testing.T) {
+		w, _ := request("leftGroup=automations.yaml&leftId=kitchen&left=20240101T000000.backup")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got: %d", w.Code)
+		}
+	})
+}
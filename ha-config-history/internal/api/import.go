@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/io"
+	goio "io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ImportRequest struct {
+	// Archives are filenames in the Home Assistant backup directory. Every archive is imported if none are given.
+	Archives []string `json:"archives"`
+}
+
+// ListHABackupsHandler lists the archives in the Home Assistant backup directory
+func ListHABackupsHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		backups, err := s.HABackups()
+		if errors.Is(err, core.ErrNoHABackupDir) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, backups)
+	}
+}
+
+// ImportHABackupsHandler starts a job importing versions from Home Assistant backup archives
+func ImportHABackupsHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var request ImportRequest
+		if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, goio.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid import request: %v", err),
+			})
+			return
+		}
+
+		backupDir := s.Settings().HomeAssistantBackupDir
+		if backupDir == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": core.ErrNoHABackupDir.Error()})
+			return
+		}
+
+		paths := []string{backupDir}
+		if len(request.Archives) > 0 {
+			paths = []string{}
+			for _, archive := range request.Archives {
+				if err := io.SanitizePath(archive); err != nil || filepath.Base(archive) != archive {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid archive: " + archive})
+					return
+				}
+				paths = append(paths, filepath.Join(backupDir, archive))
+			}
+		}
+
+		job := s.StartImportJob(paths)
+		c.JSON(http.StatusAccepted, job.Info())
+	}
+}
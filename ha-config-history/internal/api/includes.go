@@ -0,0 +1,30 @@
+package api
+
+import (
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/types"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetIncludesHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		s.State.Mu.RLock()
+		graphs := slices.Collect(maps.Values(s.State.IncludeGraphs))
+		s.State.Mu.RUnlock()
+
+		sort.Slice(graphs, func(i, j int) bool {
+			return graphs[i].Root < graphs[j].Root
+		})
+
+		if graphs == nil {
+			graphs = []*types.IncludeGraph{}
+		}
+
+		c.IndentedJSON(http.StatusOK, graphs)
+	}
+}
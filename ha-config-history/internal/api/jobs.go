@@ -0,0 +1,50 @@
+package api
+
+import (
+	"ha-config-history/internal/core"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ListJobsHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, s.Jobs())
+	}
+}
+
+func GetJobHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		job, exists := s.GetJob(c.Param("id"))
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "job not found",
+			})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, job)
+	}
+}
+
+func CancelJobHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if _, exists := s.GetJob(id); !exists {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "job not found",
+			})
+			return
+		}
+
+		job, err := s.CancelJob(id)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, job)
+	}
+}
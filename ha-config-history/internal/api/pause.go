@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"ha-config-history/internal/core"
+	goio "io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PauseRequest pauses a single config by path, or every config (maintenance mode) when Config is empty
+type PauseRequest struct {
+	Config          string `json:"config"`
+	DurationMinutes int    `json:"durationMinutes"`
+	Reason          string `json:"reason"`
+}
+
+type ResumeRequest struct {
+	Config string `json:"config"`
+}
+
+func GetPauseHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, s.PauseStatus())
+	}
+}
+
+func PauseHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var request PauseRequest
+		if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, goio.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid pause request: %v", err),
+			})
+			return
+		}
+		if request.DurationMinutes < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "durationMinutes must not be negative",
+			})
+			return
+		}
+
+		status, err := s.Pause(request.Config, time.Duration(request.DurationMinutes)*time.Minute, request.Reason)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, status)
+	}
+}
+
+func ResumeHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var request ResumeRequest
+		if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, goio.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Invalid resume request: %v", err),
+			})
+			return
+		}
+
+		job, err := s.Resume(request.Config)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, job.Info())
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"ha-config-history/internal/core"
 	"ha-config-history/internal/io"
-	"ha-config-history/internal/types"
 	"log/slog"
 	"net/http"
 	"path/filepath"
@@ -48,13 +47,7 @@ func RestoreBackupHandler(s *core.Server) func(c *gin.Context) {
 			return
 		}
 
-		var configOptions *types.ConfigBackupOptions
-		for i := range s.AppSettings.Configs {
-			if s.AppSettings.Configs[i].Path == group {
-				configOptions = s.AppSettings.Configs[i]
-				break
-			}
-		}
+		configOptions := s.FindConfigOptions(group)
 
 		if configOptions == nil {
 			c.JSON(http.StatusNotFound, RestoreBackupResponse{
@@ -64,7 +57,8 @@ func RestoreBackupHandler(s *core.Server) func(c *gin.Context) {
 			return
 		}
 
-		backupContent, err := io.GetConfigBackup(s.AppSettings.BackupDir, group, id, filename)
+		settings := s.Settings()
+		backupContent, err := io.GetConfigBackup(settings.BackupDir, group, id, filename)
 		if err != nil {
 			c.JSON(http.StatusNotFound, RestoreBackupResponse{
 				Success: false,
@@ -73,37 +67,13 @@ func RestoreBackupHandler(s *core.Server) func(c *gin.Context) {
 			return
 		}
 
-		fullPath := filepath.Join(s.AppSettings.HomeAssistantConfigDir, configOptions.Path)
-
-		if configOptions.BackupType == "single" {
-			if err := io.RestoreEntireFile(fullPath, backupContent); err != nil {
-				c.JSON(http.StatusInternalServerError, RestoreBackupResponse{
-					Success: false,
-					Error:   fmt.Sprintf("Failed to restore backup: %v", err),
-				})
-				return
-			}
-		}
-
-		if configOptions.BackupType == "multiple" {
-			if err := io.RestorePartialFile(fullPath, backupContent, *configOptions); err != nil {
-				c.JSON(http.StatusInternalServerError, RestoreBackupResponse{
-					Success: false,
-					Error:   fmt.Sprintf("Failed to restore backup: %v", err),
-				})
-				return
-			}
-		}
-
-		if configOptions.BackupType == "directory" {
-			fullPath := filepath.Join(s.AppSettings.HomeAssistantConfigDir, configOptions.Path, id)
-			if err := io.RestoreEntireFile(fullPath, backupContent); err != nil {
-				c.JSON(http.StatusInternalServerError, RestoreBackupResponse{
-					Success: false,
-					Error:   fmt.Sprintf("Failed to restore backup: %v", err),
-				})
-				return
-			}
+		fullPath, err := io.RestoreBackup(settings.HomeAssistantConfigDir, configOptions, id, backupContent)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, RestoreBackupResponse{
+				Success: false,
+				Error:   fmt.Sprintf("Failed to restore backup: %v", err),
+			})
+			return
 		}
 
 		slog.Info("Backup restored successfully", "group", group, "id", id, "filename", filename, "path", fullPath)
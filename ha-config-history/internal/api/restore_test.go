@@ -576,6 +576,46 @@ func TestRestoreBackupHandler(t *testing.T) {
 			}
 		})
 
+		t.Run("Restore matches items using a fallback id expression", func(t *testing.T) {
+			_, backupDir, _, targetFile, server := setupPartialRestoreTest(t)
+			idNode := "unique_id|id"
+			server.Settings().Configs[0].IdNode = &idNode
+
+			group := "automations.yaml"
+			id := "automation_2"
+			filename := "20240101T120000.backup"
+
+			backupContent, err := os.ReadFile("test-data/partial-automation-2-modified.yaml")
+			if err != nil {
+				t.Fatalf("Failed to read test data file: %v", err)
+			}
+
+			createBackup(t, backupDir, group, id, filename, backupContent)
+
+			router := setupRestoreRouter(server)
+			req := createRestoreRequest(t, group, id, filename)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+			}
+
+			restoredContent, err := os.ReadFile(targetFile)
+			if err != nil {
+				t.Fatalf("Failed to read restored file: %v", err)
+			}
+
+			restoredStr := string(restoredContent)
+			if !strings.Contains(restoredStr, "alias: Modified Second Automation") {
+				t.Error("Second automation was not updated correctly")
+			}
+			if !strings.Contains(restoredStr, "alias: First Automation") || !strings.Contains(restoredStr, "alias: Third Automation") {
+				t.Error("Other automations should be unchanged")
+			}
+		})
+
 		t.Run("Restore first section and verify others unchanged", func(t *testing.T) {
 			_, backupDir, _, targetFile, server := setupPartialRestoreTest(t)
 
@@ -839,6 +879,118 @@ func TestRestoreBackupHandler(t *testing.T) {
 		})
 	})
 
+	t.Run("Partial file restore (mapping type)", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+
+		setupMappingRestoreTest := func(t *testing.T) (backupDir, targetFile string, server *core.Server) {
+			tempDir := t.TempDir()
+			backupDir = filepath.Join(tempDir, "backups")
+			haConfigDir := filepath.Join(tempDir, "ha-config")
+
+			err := os.MkdirAll(haConfigDir, 0755)
+			if err != nil {
+				t.Fatalf("Failed to create HA config directory: %v", err)
+			}
+
+			targetFile = filepath.Join(haConfigDir, "scripts.yaml")
+
+			originalContent, err := os.ReadFile("test-data/keyed-scripts-original.yaml")
+			if err != nil {
+				t.Fatalf("Failed to read test data file: %v", err)
+			}
+
+			err = os.WriteFile(targetFile, originalContent, 0644)
+			if err != nil {
+				t.Fatalf("Failed to write original config file: %v", err)
+			}
+
+			config := &types.AppSettings{
+				HomeAssistantConfigDir: haConfigDir,
+				BackupDir:              backupDir,
+				Port:                   ":8080",
+				Configs: []*types.ConfigBackupOptions{
+					types.NewMappingConfigBackupOptions("scripts", "scripts.yaml", "alias"),
+				},
+			}
+
+			server = core.NewServer(config)
+			return backupDir, targetFile, server
+		}
+
+		restoreKey := func(t *testing.T, backupDir string, server *core.Server, id, testDataFile string) {
+			backupContent, err := os.ReadFile(testDataFile)
+			if err != nil {
+				t.Fatalf("Failed to read test data file: %v", err)
+			}
+
+			backupPath := filepath.Join(backupDir, "scripts.yaml", id)
+			if err := os.MkdirAll(backupPath, 0755); err != nil {
+				t.Fatalf("Failed to create backup directory: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(backupPath, "20240101T120000.backup"), backupContent, 0644); err != nil {
+				t.Fatalf("Failed to write backup file: %v", err)
+			}
+
+			router := setupRestoreRouter(server)
+			req := createRestoreRequest(t, "scripts.yaml", id, "20240101T120000.backup")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+			}
+		}
+
+		readScripts := func(t *testing.T, targetFile string) map[string]map[string]any {
+			restoredContent, err := os.ReadFile(targetFile)
+			if err != nil {
+				t.Fatalf("Failed to read restored file: %v", err)
+			}
+
+			var scripts map[string]map[string]any
+			if err := yaml.Unmarshal(restoredContent, &scripts); err != nil {
+				t.Fatalf("Restored file is not a valid YAML mapping: %v", err)
+			}
+			return scripts
+		}
+
+		t.Run("Replaces only the restored key", func(t *testing.T) {
+			backupDir, targetFile, server := setupMappingRestoreTest(t)
+
+			restoreKey(t, backupDir, server, "morning_lights", "test-data/keyed-script-morning-modified.yaml")
+
+			scripts := readScripts(t, targetFile)
+			if len(scripts) != 2 {
+				t.Errorf("Expected 2 scripts after restore, got %d", len(scripts))
+			}
+
+			restoredStr, _ := yaml.Marshal(scripts["morning_lights"])
+			if !strings.Contains(string(restoredStr), "light.living_room") {
+				t.Errorf("morning_lights was not restored, got:\n%s", restoredStr)
+			}
+
+			if scripts["night_mode"]["alias"] != "Night Mode" {
+				t.Errorf("night_mode should be unchanged, got: %v", scripts["night_mode"])
+			}
+		})
+
+		t.Run("Re-adds a key that no longer exists", func(t *testing.T) {
+			backupDir, targetFile, server := setupMappingRestoreTest(t)
+
+			restoreKey(t, backupDir, server, "vacation_mode", "test-data/keyed-script-removed.yaml")
+
+			scripts := readScripts(t, targetFile)
+			if len(scripts) != 3 {
+				t.Errorf("Expected 3 scripts after restore, got %d", len(scripts))
+			}
+
+			if scripts["vacation_mode"]["alias"] != "Vacation Mode" {
+				t.Errorf("vacation_mode was not re-added, got: %v", scripts["vacation_mode"])
+			}
+		})
+	})
+
 	t.Run("Idempotency & State", func(t *testing.T) {
 		gin.SetMode(gin.TestMode)
 
@@ -1,28 +1,30 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"ha-config-history/internal/core"
-	"ha-config-history/internal/io"
 	"ha-config-history/internal/types"
+	goio "io"
 	"log/slog"
 	"net/http"
-	"os"
 
 	"github.com/gin-gonic/gin"
 )
 
 func GetSettingsHandler(s *core.Server) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		c.IndentedJSON(http.StatusOK, s.AppSettings)
+		c.IndentedJSON(http.StatusOK, s.Settings())
 	}
 }
 
 type UpdateSettingsResponse struct {
-	Success  bool     `json:"success"`
-	Warnings []string `json:"warnings,omitempty"`
-	Error    string   `json:"error,omitempty"`
+	Success  bool                  `json:"success"`
+	Warnings []string              `json:"warnings,omitempty"`
+	Error    string                `json:"error,omitempty"`
+	Changes  *core.SettingsChanges `json:"changes,omitempty"`
+	// Validation has the errors and warnings for each field
+	Validation *core.SettingsValidation `json:"validation,omitempty"`
 }
 
 func UpdateSettingsHandler(s *core.Server) func(c *gin.Context) {
@@ -36,67 +38,73 @@ func UpdateSettingsHandler(s *core.Server) func(c *gin.Context) {
 			return
 		}
 
-		var warnings []string
-
-		if !io.DirectoryExists(newSettings.HomeAssistantConfigDir) {
-			warnings = append(warnings, fmt.Sprintf("Home Assistant config directory does not exist: %s", newSettings.HomeAssistantConfigDir))
-		}
-		if !io.DirectoryExists(newSettings.BackupDir) {
-			warnings = append(warnings, fmt.Sprintf("Backup directory does not exist: %s", newSettings.BackupDir))
+		validation := core.ValidateSettings(&newSettings)
+		if !validation.Valid() {
+			c.JSON(http.StatusBadRequest, UpdateSettingsResponse{
+				Success:    false,
+				Error:      fmt.Sprintf("Invalid settings: %v", validation.Errors[0]),
+				Validation: &validation,
+			})
+			return
 		}
 
-		if newSettings.CronSchedule != nil && *newSettings.CronSchedule != "" {
-			if err := core.ValidateCronSchedule(*newSettings.CronSchedule); err != nil {
-				c.JSON(http.StatusBadRequest, UpdateSettingsResponse{
-					Success: false,
-					Error:   fmt.Sprintf("Invalid cron schedule: %v", err),
-				})
-				return
-			}
+		warnings := []string{}
+		for _, warning := range validation.Warnings {
+			warnings = append(warnings, warning.String())
 		}
-
-		configData, err := json.MarshalIndent(newSettings, "", "  ")
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, UpdateSettingsResponse{
-				Success: false,
-				Error:   fmt.Sprintf("Failed to serialize settings: %v", err),
-			})
-			return
+		current := s.Settings()
+		if !equalIntSettings(current.BackupWorkers, newSettings.BackupWorkers) ||
+			!equalIntSettings(current.QueueDepth, newSettings.QueueDepth) {
+			warnings = append(warnings, "Backup worker and queue depth changes take effect after a restart")
 		}
 
-		if err := os.WriteFile("config.json", configData, 0644); err != nil {
+		changes, err := s.SaveSettings(&newSettings)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, UpdateSettingsResponse{
 				Success: false,
-				Error:   fmt.Sprintf("Failed to save settings file: %v", err),
+				Error:   err.Error(),
 			})
 			return
 		}
 
-		cronChanged := false
-		oldSchedule := ""
-		newSchedule := ""
-		if s.AppSettings.CronSchedule != nil {
-			oldSchedule = *s.AppSettings.CronSchedule
-		}
-		if newSettings.CronSchedule != nil {
-			newSchedule = *newSettings.CronSchedule
-		}
-		if oldSchedule != newSchedule {
-			cronChanged = true
-		}
+		slog.Info("Settings updated successfully")
 
-		s.AppSettings = &newSettings
+		c.JSON(http.StatusOK, UpdateSettingsResponse{
+			Success:    true,
+			Warnings:   warnings,
+			Validation: &validation,
+			Changes:    &changes,
+		})
+	}
+}
 
-		if cronChanged {
-			_ = s.RestartCronJob()
-			slog.Info("Cron schedule updated", "schedule", newSchedule)
+// ValidateSettingsHandler checks settings without saving them, or checks the current settings if none are sent
+func ValidateSettingsHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		settings := s.Settings()
+		var newSettings types.AppSettings
+		if err := c.ShouldBindJSON(&newSettings); err == nil {
+			settings = &newSettings
+		} else if !errors.Is(err, goio.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid settings format: %v", err)})
+			return
 		}
 
-		slog.Info("Settings updated successfully")
+		c.JSON(http.StatusOK, core.ValidateSettings(settings))
+	}
+}
 
-		c.JSON(http.StatusOK, UpdateSettingsResponse{
-			Success:  true,
-			Warnings: warnings,
-		})
+// GetNewDefaultConfigsHandler returns the default configs added or changed by releases since the settings were
+// created, which can be added to the settings or dismissed by listing their keys in knownDefaultConfigs
+func GetNewDefaultConfigsHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, types.NewDefaultConfigs(s.Settings()))
+	}
+}
+
+func equalIntSettings(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
 	}
+	return *a == *b
 }
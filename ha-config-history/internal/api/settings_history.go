@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"ha-config-history/internal/core"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func ListSettingsVersionsHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		versions, err := s.SettingsVersions()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, versions)
+	}
+}
+
+func GetSettingsVersionHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		content, err := s.SettingsVersion(c.Param("filename"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Data(http.StatusOK, "application/json; charset=utf-8", content)
+	}
+}
+
+func GetSettingsVersionDiffHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		options, ok := diffOptions(c)
+		if !ok {
+			return
+		}
+
+		diff, err := s.DiffSettingsVersions(c.Param("filename"), c.Param("right"), options)
+		var notFound *core.BackupNotFoundError
+		if errors.As(err, &notFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Error loading " + notFound.Side + " settings version"})
+			return
+		}
+		if errors.Is(err, core.ErrIgnoreKeyOrderUnsupported) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, diff)
+	}
+}
+
+// RollbackSettingsHandler saves and applies a previous settings version
+func RollbackSettingsHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		filename := c.Param("filename")
+
+		changes, validation, err := s.RollbackSettings(filename)
+		if errors.Is(err, core.ErrSettingsVersionNotFound) {
+			c.JSON(http.StatusNotFound, UpdateSettingsResponse{Success: false, Error: err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, UpdateSettingsResponse{Success: false, Error: err.Error()})
+			return
+		}
+		if !validation.Valid() {
+			c.JSON(http.StatusBadRequest, UpdateSettingsResponse{
+				Success:    false,
+				Error:      fmt.Sprintf("Invalid settings: %v", validation.Errors[0]),
+				Validation: &validation,
+			})
+			return
+		}
+
+		slog.Info("Settings rolled back successfully", "filename", filename)
+
+		warnings := []string{}
+		for _, warning := range validation.Warnings {
+			warnings = append(warnings, warning.String())
+		}
+		c.JSON(http.StatusOK, UpdateSettingsResponse{
+			Success:    true,
+			Warnings:   warnings,
+			Validation: &validation,
+			Changes:    &changes,
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"ha-config-history/internal/api"
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSettingsHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	setup := func(t *testing.T) (*core.Server, *gin.Engine) {
+		server := core.NewServer(&types.AppSettings{
+			HomeAssistantConfigDir: t.TempDir(),
+			BackupDir:              t.TempDir(),
+			Port:                   ":40613",
+			Configs: []*types.ConfigBackupOptions{
+				types.NewDirectoryConfigBackupOptions("Blueprints", "blueprints", []string{"*.yaml"}, nil),
+			},
+		})
+		if err := server.WatchSettingsFile(filepath.Join(t.TempDir(), "config.json")); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		server.Start()
+		t.Cleanup(server.Shutdown)
+
+		router := gin.New()
+		router.PUT("/settings", api.UpdateSettingsHandler(server))
+		router.GET("/settings/history", api.ListSettingsVersionsHandler(server))
+		router.GET("/settings/history/:filename", api.GetSettingsVersionHandler(server))
+		router.GET("/settings/history/:filename/diff/:right", api.GetSettingsVersionDiffHandler(server))
+		router.POST("/settings/history/:filename/rollback", api.RollbackSettingsHandler(server))
+		return server, router
+	}
+
+	request := func(router *gin.Engine, method, url string, body any) *httptest.ResponseRecorder {
+		var data []byte
+		if body != nil {
+			data, _ = json.Marshal(body)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, url, bytes.NewReader(data)))
+		return w
+	}
+
+	listVersions := func(t *testing.T, router *gin.Engine) []io.BackupInfo {
+		w := request(router, http.MethodGet, "/settings/history", nil)
+		var versions []io.BackupInfo
+		if err := json.Unmarshal(w.Body.Bytes(), &versions); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return versions
+	}
+
+	t.Run("Settings changes are versioned, compared and rolled back", func(t *testing.T) {
+		server, router := setup(t)
+
+		versions := listVersions(t, router)
+		if len(versions) != 1 {
+			t.Fatalf("Expected the startup settings to be versioned, got: %d versions", len(versions))
+		}
+		original := versions[0].Filename
+
+		updated := *server.Settings()
+		blueprints := *updated.Configs[0]
+		blueprints.IncludeFilePatterns = []string{"automation/*.yaml"}
+		updated.Configs = []*types.ConfigBackupOptions{&blueprints}
+		if w := request(router, http.MethodPut, "/settings", updated); w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+
+		versions = listVersions(t, router)
+		if len(versions) != 2 {
+			t.Fatalf("Expected 2 versions, got: %d", len(versions))
+		}
+
+		w := request(router, http.MethodGet, "/settings/history/"+original+"/diff/"+versions[0].Filename, nil)
+		var diff api.BackupDiffResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &diff); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !strings.Contains(diff.UnifiedDiff, `-        "*.yaml"`) ||
+			!strings.Contains(diff.UnifiedDiff, `+        "automation/*.yaml"`) {
+			t.Fatalf("Expected diff of the include patterns, got:\n%s", diff.UnifiedDiff)
+		}
+
+		w = request(router, http.MethodPost, "/settings/history/"+original+"/rollback", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+		if patterns := server.Settings().Configs[0].IncludeFilePatterns; len(patterns) != 1 || patterns[0] != "*.yaml" {
+			t.Errorf("Expected original include patterns to be restored, got: %v", patterns)
+		}
+		if versions := listVersions(t, router); len(versions) != 3 {
+			t.Errorf("Expected the rollback to be versioned, got: %d versions", len(versions))
+		}
+
+		w = request(router, http.MethodGet, "/settings/history/"+original, nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"blueprints"`) {
+			t.Errorf("Expected settings version content, got: %d\n%s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Unchanged settings are not versioned again", func(t *testing.T) {
+		server, router := setup(t)
+
+		if w := request(router, http.MethodPut, "/settings", server.Settings()); w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+		count := len(listVersions(t, router))
+
+		if w := request(router, http.MethodPut, "/settings", server.Settings()); w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+		if versions := listVersions(t, router); len(versions) != count {
+			t.Fatalf("Expected %d versions, got: %d", count, len(versions))
+		}
+	})
+
+	t.Run("Old versions are pruned with the default retention limits", func(t *testing.T) {
+		server, router := setup(t)
+
+		maxBackups := 2
+		for _, pattern := range []string{"automation/*.yaml", "script/*.yaml", "*.yaml"} {
+			updated := *server.Settings()
+			updated.DefaultMaxBackups = &maxBackups
+			blueprints := *updated.Configs[0]
+			blueprints.IncludeFilePatterns = []string{pattern}
+			updated.Configs = []*types.ConfigBackupOptions{&blueprints}
+			if w := request(router, http.MethodPut, "/settings", updated); w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+			}
+		}
+
+		versions := listVersions(t, router)
+		if len(versions) != maxBackups {
+			t.Fatalf("Expected %d versions, got: %d", maxBackups, len(versions))
+		}
+		w := request(router, http.MethodGet, "/settings/history/"+versions[0].Filename, nil)
+		if !strings.Contains(w.Body.String(), `"*.yaml"`) {
+			t.Errorf("Expected the latest settings to be kept, got:\n%s", w.Body.String())
+		}
+	})
+
+	t.Run("Missing versions", func(t *testing.T) {
+		_, router := setup(t)
+
+		w := request(router, http.MethodPost, "/settings/history/20000101T000000.backup/rollback", nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for rollback, got: %d", w.Code)
+		}
+		w = request(router, http.MethodGet, "/settings/history/missing/diff/missing", nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for diff, got: %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"ha-config-history/internal/core"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SnapshotDiffResponse = core.SnapshotDiff
+
+// GetSnapshotDiffHandler returns the items added, removed and modified across every config between two RFC 3339
+// timestamps, from and to. Without to the configs are compared with the live config, and with diffs=true each
+// change includes its diff.
+func GetSnapshotDiffHandler(s *core.Server) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		from, err := time.Parse(time.RFC3339, c.Query("from"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, expected an RFC 3339 timestamp"})
+			return
+		}
+		var to *time.Time
+		if value := c.Query("to"); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, expected an RFC 3339 timestamp"})
+				return
+			}
+			to = &parsed
+		}
+
+		options, ok := diffOptions(c)
+		if !ok {
+			return
+		}
+
+		diff, err := s.DiffSnapshots(from, to, c.Query("diffs") == "true", options)
+		if errors.Is(err, core.ErrInvalidSnapshotRange) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, core.ErrIgnoreKeyOrderUnsupported) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, diff)
+	}
+}
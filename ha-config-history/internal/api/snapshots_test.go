@@ -0,0 +1,85 @@
+package api_test
+
+import (
+	"encoding/json"
+	"ha-config-history/internal/api"
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSnapshotDiff(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	writeFile := func(t *testing.T, path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	settings := &types.AppSettings{
+		HomeAssistantConfigDir: t.TempDir(),
+		BackupDir:              t.TempDir(),
+		Configs: []*types.ConfigBackupOptions{
+			types.NewSingleConfigBackupOptions("Configuration", "configuration.yaml"),
+		},
+	}
+	configDir := filepath.Join(settings.BackupDir, "configuration.yaml", "configuration.yaml")
+	writeFile(t, filepath.Join(configDir, "20240105T180000.backup"), "homeassistant:\n  name: Home\n")
+	writeFile(t, filepath.Join(configDir, "20240106T090000.backup"), "homeassistant:\n  name: Cottage\n")
+	metadata, _ := json.Marshal(types.ConfigMetadata{
+		ConfigIdentifier: types.ConfigIdentifier{Group: "configuration.yaml", ID: "configuration.yaml"},
+		FriendlyName:     "Configuration",
+		BackupCount:      2,
+		BackupType:       "single",
+	})
+	writeFile(t, filepath.Join(configDir, "metadata.json"), string(metadata))
+
+	server := core.NewServer(settings)
+	defer server.Shutdown()
+	router := gin.New()
+	router.GET("/changes", api.GetSnapshotDiffHandler(server))
+
+	request := func(url string) (*httptest.ResponseRecorder, api.SnapshotDiffResponse) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		var response api.SnapshotDiffResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	t.Run("Returns changes with diffs", func(t *testing.T) {
+		w, response := request("/changes?from=2024-01-05T20:00:00Z&to=2024-01-06T10:00:00Z&diffs=true")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got: %d\n%s", w.Code, w.Body.String())
+		}
+		if len(response.Changes) != 1 || response.Changes[0].Type != core.SnapshotChangeModified {
+			t.Fatalf("Expected the modified configuration, got: %+v", response.Changes)
+		}
+		if diff := response.Changes[0].Diff; diff == nil || diff.NewContent != "homeassistant:\n  name: Cottage\n" {
+			t.Errorf("Expected the diff to be included, got: %+v", diff)
+		}
+	})
+
+	t.Run("Invalid parameters", func(t *testing.T) {
+		for _, url := range []string{
+			"/changes",
+			"/changes?from=friday",
+			"/changes?from=2024-01-06T10:00:00Z&to=2024-01-05T20:00:00Z",
+			"/changes?from=2024-01-05T20:00:00Z&algorithm=guess",
+		} {
+			if w, _ := request(url); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400 for %s, got: %d", url, w.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,190 @@
+// Package cli implements the command-line subcommands, which work on the settings file and backup directory directly
+// so history can be inspected and restored while the server isn't running
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/types"
+	goio "io"
+	"log/slog"
+	"os"
+)
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type command struct {
+	name        string
+	args        string
+	description string
+	run         func(cmd *invocation) error
+}
+
+var commands = []command{
+	{"serve", "", "Run the web server (the default when no command is given)", nil},
+	{"scan", "[-full]", "Back up every config that has changed", runScan},
+	{"import", "[archive|directory...]", "Import versions from Home Assistant backups, /backup by default", runImport},
+	{"list", "[group [id]]", "List backed up configs, or the backups of a config", runList},
+	{"show", "group id [filename]", "Print a backup, the latest by default", runShow},
+	{"diff", "[-structural|-live] group id [left [right]]", "Compare backups, or a backup with the live file", runDiff},
+	{"compare", "group id version group id version", "Compare versions of two configs, or live", runCompare},
+	{"changes", "[-diffs] from [to]", "List config changes between two RFC 3339 times, or since one", runChanges},
+	{"restore", "group id filename", "Restore a backup to the Home Assistant config directory", runRestore},
+	{"verify", "", "Check the settings and backups for problems", runVerify},
+	{"prune", "[-dry-run]", "Remove backups beyond each config's retention limits", runPrune},
+}
+
+// Run runs a command, where args[0] is the command name, and returns the process exit code
+func Run(args []string, stdout, stderr goio.Writer) int {
+	slog.SetDefault(slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	if len(args) == 0 || args[0] == "help" {
+		printUsage(stdout)
+		return 0
+	}
+
+	var selected *command
+	for i := range commands {
+		if commands[i].name == args[0] && commands[i].run != nil {
+			selected = &commands[i]
+		}
+	}
+	if selected == nil {
+		fmt.Fprintf(stderr, "Unknown command: %s\n\n", args[0])
+		printUsage(stderr)
+		return 2
+	}
+
+	cmd := newInvocation(*selected, args[1:], stdout, stderr)
+	err := selected.run(cmd)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	var usage *usageError
+	if errors.As(err, &usage) {
+		fmt.Fprintf(stderr, "%s\n\n", usage.message)
+		cmd.flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage(w goio.Writer) {
+	fmt.Fprintln(w, "Usage: ha-config-history [command] [flags] [arguments]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, command := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", command.name, command.description)
+	}
+	fmt.Fprintln(w, "\nRun 'ha-config-history <command> -h' for a command's flags.")
+}
+
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+// invocation holds the flags and arguments of a command being run
+type invocation struct {
+	command    command
+	flags      *flag.FlagSet
+	rawArgs    []string
+	args       []string
+	configPath *string
+	backupDir  *string
+	format     *string
+	stdout     goio.Writer
+}
+
+func newInvocation(command command, args []string, stdout, stderr goio.Writer) *invocation {
+	flags := flag.NewFlagSet(command.name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: ha-config-history %s [flags] %s\n\n%s\n\nFlags:\n",
+			command.name, command.args, command.description)
+		flags.PrintDefaults()
+	}
+
+	return &invocation{
+		command:    command,
+		flags:      flags,
+		rawArgs:    args,
+		configPath: flags.String("config", "config.json", "settings file"),
+		backupDir:  flags.String("backup-dir", "", "backup directory, overriding the settings file"),
+		format:     flags.String("format", FormatText, "output format: text or json"),
+		stdout:     stdout,
+	}
+}
+
+// parse parses flags, checking the number of positional arguments
+func (cmd *invocation) parse(minArgs, maxArgs int) error {
+	if err := cmd.flags.Parse(cmd.rawArgs); err != nil {
+		return err
+	}
+	if *cmd.format != FormatText && *cmd.format != FormatJSON {
+		return &usageError{fmt.Sprintf("Unknown format: %s", *cmd.format)}
+	}
+
+	cmd.args = cmd.flags.Args()
+	if len(cmd.args) < minArgs || len(cmd.args) > maxArgs {
+		return &usageError{fmt.Sprintf("Expected %s", cmd.command.args)}
+	}
+	return nil
+}
+
+// settings loads the settings file, using the defaults if it doesn't exist
+func (cmd *invocation) settings() (*types.AppSettings, error) {
+	data, err := os.ReadFile(*cmd.configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		data = []byte("{}")
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read settings file %s: %w", *cmd.configPath, err)
+	}
+
+	settings, err := types.ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse settings file %s: %w", *cmd.configPath, err)
+	}
+	if *cmd.backupDir != "" {
+		settings.BackupDir = *cmd.backupDir
+	}
+	return settings, nil
+}
+
+// server creates a server for the settings without starting it. The caller must shut it down.
+func (cmd *invocation) server() (*core.Server, error) {
+	settings, err := cmd.settings()
+	if err != nil {
+		return nil, err
+	}
+
+	server := core.NewServer(settings)
+	server.LoadIncludeGraphs()
+	return server, nil
+}
+
+// output writes value as JSON, or calls text to write it for people to read
+func (cmd *invocation) output(value any, text func(w goio.Writer) error) error {
+	if *cmd.format == FormatJSON {
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to serialize output: %w", err)
+		}
+		_, err = fmt.Fprintln(cmd.stdout, string(data))
+		return err
+	}
+	return text(cmd.stdout)
+}
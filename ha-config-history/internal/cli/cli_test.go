@@ -0,0 +1,269 @@
+package cli_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"ha-config-history/internal/cli"
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	writeFile := func(t *testing.T, path string, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	// setup writes a settings file and an automation, returning the settings file path
+	setup := func(t *testing.T, settings *types.AppSettings) string {
+		settings.HomeAssistantConfigDir = t.TempDir()
+		settings.BackupDir = t.TempDir()
+		settings.Port = ":40613"
+		settings.Configs = []*types.ConfigBackupOptions{
+			types.NewMultipleConfigBackupOptions("Automations", "automations.yaml", "id", "alias"),
+		}
+		writeFile(t, filepath.Join(settings.HomeAssistantConfigDir, "automations.yaml"), "- id: one\n  alias: Two\n")
+
+		data, err := json.Marshal(settings)
+		if err != nil {
+			t.Fatalf("Failed to serialize settings: %v", err)
+		}
+		configPath := filepath.Join(t.TempDir(), "config.json")
+		writeFile(t, configPath, string(data))
+		return configPath
+	}
+
+	// seedBackups writes two backups of an automation and its metadata
+	seedBackups := func(t *testing.T, backupDir, group, id string) {
+		configDir := filepath.Join(backupDir, group, id)
+		writeFile(t, filepath.Join(configDir, "20240101T000000.backup"), "id: one\nalias: One\n")
+		writeFile(t, filepath.Join(configDir, "20240102T000000.backup"), "id: one\nalias: Two\n")
+
+		metadata := types.ConfigMetadata{
+			ConfigIdentifier: types.ConfigIdentifier{Group: group, ID: id},
+			FriendlyName:     "Two",
+			BackupCount:      2,
+			BackupType:       "multiple",
+		}
+		data, _ := json.Marshal(metadata)
+		writeFile(t, filepath.Join(configDir, "metadata.json"), string(data))
+	}
+
+	run := func(configPath string, args ...string) (string, string, int) {
+		var stdout, stderr bytes.Buffer
+		args = append([]string{args[0], "-config", configPath}, args[1:]...)
+		code := cli.Run(args, &stdout, &stderr)
+		return stdout.String(), stderr.String(), code
+	}
+
+	t.Run("Scan backs up configs", func(t *testing.T) {
+		configPath := setup(t, &types.AppSettings{})
+
+		stdout, stderr, code := run(configPath, "scan", "-format", "json")
+		if code != 0 {
+			t.Fatalf("Expected exit code 0, got: %d\n%s", code, stderr)
+		}
+		var job core.JobInfo
+		if err := json.Unmarshal([]byte(stdout), &job); err != nil {
+			t.Fatalf("Expected JSON output, got: %v\n%s", err, stdout)
+		}
+		if job.Status != core.JobStatusCompleted || job.VersionsCreated != 1 {
+			t.Fatalf("Expected completed scan with 1 version, got: %s %d", job.Status, job.VersionsCreated)
+		}
+
+		stdout, _, _ = run(configPath, "list")
+		if !strings.Contains(stdout, "automations.yaml") || !strings.Contains(stdout, "Two") {
+			t.Fatalf("Expected backed up config to be listed, got:\n%s", stdout)
+		}
+	})
+
+	t.Run("Scan refuses invalid settings", func(t *testing.T) {
+		settings := &types.AppSettings{}
+		setup(t, settings)
+		storage := types.NewJsonConfigBackupOptions("Input booleans", ".storage/input_boolean", "", "id", "name")
+		storage.ItemsPath = nil
+		settings.Configs = append(settings.Configs, storage)
+		writeFile(t, filepath.Join(settings.HomeAssistantConfigDir, ".storage/input_boolean"), `{"data": {}}`)
+		data, _ := json.Marshal(settings)
+		configPath := filepath.Join(t.TempDir(), "config.json")
+		writeFile(t, configPath, string(data))
+
+		_, stderr, code := run(configPath, "scan")
+		if code == 0 || !strings.Contains(stderr, "itemsPath") {
+			t.Fatalf("Expected the scan to refuse the json config without itemsPath, got: %d\n%s", code, stderr)
+		}
+	})
+
+	t.Run("Show, diff and restore backups", func(t *testing.T) {
+		settings := &types.AppSettings{}
+		configPath := setup(t, settings)
+		seedBackups(t, settings.BackupDir, "automations.yaml", "one")
+
+		stdout, _, code := run(configPath, "show", "automations.yaml", "one")
+		if code != 0 || stdout != "id: one\nalias: Two\n" {
+			t.Fatalf("Expected latest backup, got: %d\n%s", code, stdout)
+		}
+
+		stdout, _, code = run(configPath, "diff", "automations.yaml", "one")
+		if code != 0 || !strings.Contains(stdout, "-alias: One") || !strings.Contains(stdout, "+alias: Two") {
+			t.Fatalf("Expected diff of the latest two backups, got: %d\n%s", code, stdout)
+		}
+
+		stdout, stderr, code := run(configPath, "restore", "-format", "json", "automations.yaml", "one",
+			"20240101T000000.backup")
+		if code != 0 {
+			t.Fatalf("Expected exit code 0, got: %d\n%s", code, stderr)
+		}
+		var restored cli.RestoreOutput
+		if err := json.Unmarshal([]byte(stdout), &restored); err != nil {
+			t.Fatalf("Expected JSON output, got: %v\n%s", err, stdout)
+		}
+		content, err := os.ReadFile(restored.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !strings.Contains(string(content), "alias: One") {
+			t.Fatalf("Expected backup to be restored, got:\n%s", content)
+		}
+	})
+
+	t.Run("Diff a backup against the live file", func(t *testing.T) {
+		settings := &types.AppSettings{}
+		configPath := setup(t, settings)
+		seedBackups(t, settings.BackupDir, "automations.yaml", "one")
+
+		stdout, _, code := run(configPath, "diff", "-live", "automations.yaml", "one")
+		if code != 0 || !strings.Contains(stdout, "No changes between 20240102T000000.backup and live") {
+			t.Fatalf("Expected the latest backup to match the live file, got: %d\n%s", code, stdout)
+		}
+
+		stdout, _, code = run(configPath, "diff", "-live", "automations.yaml", "one", "20240101T000000.backup")
+		if code != 0 || !strings.Contains(stdout, "-alias: One") || !strings.Contains(stdout, "+alias: Two") {
+			t.Fatalf("Expected diff against the live file, got: %d\n%s", code, stdout)
+		}
+
+		writeFile(t, filepath.Join(settings.HomeAssistantConfigDir, "automations.yaml"), "[]\n")
+		stdout, _, code = run(configPath, "diff", "-live", "automations.yaml", "one")
+		if code != 0 || !strings.Contains(stdout, "no longer exists") || !strings.Contains(stdout, "-alias: Two") {
+			t.Fatalf("Expected the removed automation to be reported, got: %d\n%s", code, stdout)
+		}
+	})
+
+	t.Run("Compare versions of different configs", func(t *testing.T) {
+		settings := &types.AppSettings{}
+		configPath := setup(t, settings)
+		seedBackups(t, settings.BackupDir, "automations.yaml", "one")
+		seedBackups(t, settings.BackupDir, "automations.yaml", "two")
+
+		stdout, _, code := run(configPath, "compare",
+			"automations.yaml", "two", "20240101T000000.backup", "automations.yaml", "one", "live")
+		if code != 0 || !strings.Contains(stdout, "--- automations.yaml/two/20240101T000000.backup") ||
+			!strings.Contains(stdout, "+++ automations.yaml/one/live") || !strings.Contains(stdout, "+alias: Two") {
+			t.Fatalf("Expected diff between the configs, got: %d\n%s", code, stdout)
+		}
+
+		if _, stderr, code := run(configPath, "compare", "automations.yaml", "one", "live"); code != 2 {
+			t.Fatalf("Expected exit code 2 for missing arguments, got: %d\n%s", code, stderr)
+		}
+	})
+
+	t.Run("Changes since a time", func(t *testing.T) {
+		settings := &types.AppSettings{}
+		configPath := setup(t, settings)
+		seedBackups(t, settings.BackupDir, "automations.yaml", "one")
+
+		stdout, _, code := run(configPath, "changes", "2024-01-01T12:00:00Z", "2024-01-02T12:00:00Z")
+		if code != 0 || stdout != "modified automations.yaml/one Two\n" {
+			t.Fatalf("Expected the modified automation, got: %d\n%s", code, stdout)
+		}
+
+		stdout, _, code = run(configPath, "changes", "-diffs", "2024-01-02T12:00:00Z")
+		if code != 0 || stdout != "No changes\n" {
+			t.Fatalf("Expected the live file to match the latest backup, got: %d\n%s", code, stdout)
+		}
+
+		if _, stderr, code := run(configPath, "changes", "yesterday"); code != 2 {
+			t.Fatalf("Expected exit code 2 for an invalid time, got: %d\n%s", code, stderr)
+		}
+	})
+
+	t.Run("Verify reports problems", func(t *testing.T) {
+		settings := &types.AppSettings{}
+		configPath := setup(t, settings)
+		seedBackups(t, settings.BackupDir, "automations.yaml", "one")
+
+		if stdout, _, code := run(configPath, "verify"); code != 0 {
+			t.Fatalf("Expected exit code 0, got: %d\n%s", code, stdout)
+		}
+
+		seedBackups(t, settings.BackupDir, "scripts.yaml", "hello")
+		writeFile(t, filepath.Join(settings.BackupDir, "automations.yaml", "two", "metadata.json"), "{}")
+
+		stdout, _, code := run(configPath, "verify", "-format", "json")
+		if code != 1 {
+			t.Fatalf("Expected exit code 1, got: %d", code)
+		}
+		var issues []core.VerifyIssue
+		if err := json.Unmarshal([]byte(stdout), &issues); err != nil {
+			t.Fatalf("Expected JSON output, got: %v\n%s", err, stdout)
+		}
+		if len(issues) != 2 {
+			t.Fatalf("Expected 2 issues, got: %+v", issues)
+		}
+		if issues[0].ID != "two" || issues[0].Severity != core.VerifySeverityError {
+			t.Errorf("Expected error for config without backups, got: %+v", issues[0])
+		}
+		if issues[1].Group != "scripts.yaml" || issues[1].Severity != core.VerifySeverityWarning {
+			t.Errorf("Expected warning for config missing from settings, got: %+v", issues[1])
+		}
+	})
+
+	t.Run("Prune removes backups beyond retention limits", func(t *testing.T) {
+		maxBackups := 1
+		settings := &types.AppSettings{DefaultMaxBackups: &maxBackups}
+		configPath := setup(t, settings)
+		seedBackups(t, settings.BackupDir, "automations.yaml", "one")
+		oldest := filepath.Join(settings.BackupDir, "automations.yaml", "one", "20240101T000000.backup")
+
+		stdout, _, code := run(configPath, "prune", "-dry-run")
+		if code != 0 || !strings.Contains(stdout, "Would remove automations.yaml/one 20240101T000000.backup") {
+			t.Fatalf("Expected oldest backup to be listed, got: %d\n%s", code, stdout)
+		}
+		if _, err := os.Stat(oldest); err != nil {
+			t.Fatalf("Expected dry run to keep backup, got: %v", err)
+		}
+
+		if stdout, _, code := run(configPath, "prune"); code != 0 {
+			t.Fatalf("Expected exit code 0, got: %d\n%s", code, stdout)
+		}
+		if _, err := os.Stat(oldest); !os.IsNotExist(err) {
+			t.Fatalf("Expected backup to be removed, got: %v", err)
+		}
+	})
+
+	t.Run("Invalid commands and arguments", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		if code := cli.Run([]string{"unknown"}, &stdout, &stderr); code != 2 {
+			t.Errorf("Expected exit code 2 for unknown command, got: %d", code)
+		}
+
+		configPath := setup(t, &types.AppSettings{})
+		if _, _, code := run(configPath, "show"); code != 2 {
+			t.Errorf("Expected exit code 2 for missing arguments, got: %d", code)
+		}
+		if _, _, code := run(configPath, "list", "-format", "xml"); code != 2 {
+			t.Errorf("Expected exit code 2 for unknown format, got: %d", code)
+		}
+		if _, _, code := run(configPath, "show", "automations.yaml", "missing"); code != 1 {
+			t.Errorf("Expected exit code 1 for missing backups, got: %d", code)
+		}
+	})
+}
@@ -0,0 +1,547 @@
+package cli
+
+import (
+	"encoding/base64"
+	"fmt"
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	goio "io"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+func runScan(cmd *invocation) error {
+	full := cmd.flags.Bool("full", false, "read and hash every file, ignoring the stat cache")
+	if err := cmd.parse(0, 0); err != nil {
+		return err
+	}
+
+	server, err := cmd.server()
+	if err != nil {
+		return err
+	}
+	defer server.Shutdown()
+
+	// Scanning with invalid settings would back up configs incorrectly, or not at all
+	if validation := core.ValidateSettings(server.Settings()); !validation.Valid() {
+		return fmt.Errorf("invalid settings: %w", validation.Err())
+	}
+
+	job := server.Scan(*full)
+	err = cmd.output(job, func(w goio.Writer) error {
+		fmt.Fprintf(w, "Scan %s: %d items processed, %d versions created, %d unchanged files skipped\n",
+			job.Status, job.ItemsProcessed, job.VersionsCreated, job.FilesSkipped)
+		for _, jobError := range job.Errors {
+			fmt.Fprintf(w, "  %s\n", jobError)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if job.Status != core.JobStatusCompleted {
+		return fmt.Errorf("scan %s with %d errors", job.Status, len(job.Errors))
+	}
+	return nil
+}
+
+func runImport(cmd *invocation) error {
+	if err := cmd.parse(0, math.MaxInt); err != nil {
+		return err
+	}
+
+	server, err := cmd.server()
+	if err != nil {
+		return err
+	}
+	defer server.Shutdown()
+
+	paths := cmd.args
+	if len(paths) == 0 {
+		backupDir := server.Settings().HomeAssistantBackupDir
+		if backupDir == "" {
+			return core.ErrNoHABackupDir
+		}
+		paths = []string{backupDir}
+	}
+
+	job := server.ImportHABackups(paths)
+	err = cmd.output(job, func(w goio.Writer) error {
+		fmt.Fprintf(w, "Import %s: %d items read, %d versions created\n",
+			job.Status, job.ItemsProcessed, job.VersionsCreated)
+		for _, jobError := range job.Errors {
+			fmt.Fprintf(w, "  %s\n", jobError)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if job.Status != core.JobStatusCompleted {
+		return fmt.Errorf("import %s with %d errors", job.Status, len(job.Errors))
+	}
+	return nil
+}
+
+func runList(cmd *invocation) error {
+	if err := cmd.parse(0, 2); err != nil {
+		return err
+	}
+
+	server, err := cmd.server()
+	if err != nil {
+		return err
+	}
+	defer server.Shutdown()
+
+	if len(cmd.args) == 2 {
+		backups, err := io.ListConfigBackups(server.Settings().BackupDir, cmd.args[0], cmd.args[1])
+		if err != nil {
+			return err
+		}
+
+		return cmd.output(backups, func(w goio.Writer) error {
+			table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "FILENAME\tDATE\tSIZE")
+			for _, backup := range backups {
+				fmt.Fprintf(table, "%s\t%s\t%s\n",
+					backup.Filename, backup.Date.Format(time.RFC3339), core.FormatSize(backup.Size))
+			}
+			return table.Flush()
+		})
+	}
+
+	server.State.Mu.RLock()
+	metadata := slices.Collect(maps.Values(server.State.CachedConfigMetadata))
+	server.State.Mu.RUnlock()
+
+	configs := []*types.ConfigMetadata{}
+	for _, config := range metadata {
+		if len(cmd.args) == 0 || config.Group == cmd.args[0] {
+			configs = append(configs, config)
+		}
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		if configs[i].Group != configs[j].Group {
+			return configs[i].Group < configs[j].Group
+		}
+		return configs[i].ID < configs[j].ID
+	})
+
+	return cmd.output(configs, func(w goio.Writer) error {
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "GROUP\tID\tNAME\tBACKUPS\tSIZE")
+		for _, config := range configs {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\n",
+				config.Group, config.ID, config.FriendlyName, config.BackupCount, core.FormatSize(config.BackupsSize))
+		}
+		return table.Flush()
+	})
+}
+
+// ShowOutput is the JSON output of the show command. Binary content is base64 encoded.
+type ShowOutput struct {
+	types.ConfigIdentifier
+	Filename string `json:"filename"`
+	Binary   bool   `json:"binary"`
+	Content  string `json:"content"`
+}
+
+func runShow(cmd *invocation) error {
+	raw := cmd.flags.Bool("raw", false, "print the stored bytes, without indenting JSON")
+	if err := cmd.parse(2, 3); err != nil {
+		return err
+	}
+	group, id := cmd.args[0], cmd.args[1]
+
+	settings, err := cmd.settings()
+	if err != nil {
+		return err
+	}
+
+	filename := ""
+	if len(cmd.args) == 3 {
+		filename = cmd.args[2]
+	} else {
+		backups, err := listBackups(settings.BackupDir, group, id, 1)
+		if err != nil {
+			return err
+		}
+		filename = backups[0].Filename
+	}
+
+	content, err := io.GetConfigBackup(settings.BackupDir, group, id, filename)
+	if err != nil {
+		return err
+	}
+
+	output := ShowOutput{
+		ConfigIdentifier: types.ConfigIdentifier{Group: group, ID: id},
+		Filename:         filename,
+		Binary:           io.IsBinary(content),
+		Content:          string(content),
+	}
+	if output.Binary {
+		output.Content = base64.StdEncoding.EncodeToString(content)
+	} else if !*raw {
+		content, _ = types.FormatJSON(content)
+		output.Content = string(content)
+	}
+	return cmd.output(output, func(w goio.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+}
+
+func runDiff(cmd *invocation) error {
+	structural := cmd.flags.Bool("structural", false, "list changed values by key path instead of changed lines")
+	live := cmd.flags.Bool("live", false, "compare a backup, the latest by default, with the current file")
+	options := diffOptionFlags(cmd)
+	if err := cmd.parse(2, 4); err != nil {
+		return err
+	}
+	group, id := cmd.args[0], cmd.args[1]
+
+	if *live {
+		return runLiveDiff(cmd, group, id, *structural, *options)
+	}
+
+	settings, err := cmd.settings()
+	if err != nil {
+		return err
+	}
+
+	var left, right string
+	switch len(cmd.args) {
+	case 4:
+		left, right = cmd.args[2], cmd.args[3]
+	case 3:
+		backups, err := listBackups(settings.BackupDir, group, id, 1)
+		if err != nil {
+			return err
+		}
+		left, right = cmd.args[2], backups[0].Filename
+	default:
+		backups, err := listBackups(settings.BackupDir, group, id, 2)
+		if err != nil {
+			return err
+		}
+		left, right = backups[1].Filename, backups[0].Filename
+	}
+
+	if *structural {
+		return printStructuralDiff(cmd, settings.BackupDir, group, id, left, right, *options)
+	}
+
+	diff, err := core.DiffBackups(settings.BackupDir, group, id, left, right, *options)
+	if err != nil {
+		return err
+	}
+	return printDiff(cmd, diff)
+}
+
+func runLiveDiff(cmd *invocation, group, id string, structural bool, options core.DiffOptions) error {
+	if len(cmd.args) > 3 {
+		return &usageError{"Expected group id [left] when comparing with the current file"}
+	}
+	if structural {
+		return &usageError{"-structural can't be combined with -live"}
+	}
+
+	server, err := cmd.server()
+	if err != nil {
+		return err
+	}
+	defer server.Shutdown()
+
+	left := ""
+	if len(cmd.args) == 3 {
+		left = cmd.args[2]
+	} else {
+		backups, err := listBackups(server.Settings().BackupDir, group, id, 1)
+		if err != nil {
+			return err
+		}
+		left = backups[0].Filename
+	}
+
+	diff, err := server.DiffBackupAgainstLive(group, id, left, options)
+	if err != nil {
+		return err
+	}
+	return printDiff(cmd, diff)
+}
+
+func runCompare(cmd *invocation) error {
+	options := diffOptionFlags(cmd)
+	if err := cmd.parse(6, 6); err != nil {
+		return err
+	}
+
+	server, err := cmd.server()
+	if err != nil {
+		return err
+	}
+	defer server.Shutdown()
+
+	left := core.ConfigVersion{
+		ConfigIdentifier: types.ConfigIdentifier{Group: cmd.args[0], ID: cmd.args[1]},
+		Filename:         cmd.args[2],
+	}
+	right := core.ConfigVersion{
+		ConfigIdentifier: types.ConfigIdentifier{Group: cmd.args[3], ID: cmd.args[4]},
+		Filename:         cmd.args[5],
+	}
+	diff, err := server.DiffVersions(left, right, *options)
+	if err != nil {
+		return err
+	}
+	return printDiff(cmd, diff)
+}
+
+func runChanges(cmd *invocation) error {
+	includeDiffs := cmd.flags.Bool("diffs", false, "print the diff of each change")
+	options := diffOptionFlags(cmd)
+	if err := cmd.parse(1, 2); err != nil {
+		return err
+	}
+
+	from, err := time.Parse(time.RFC3339, cmd.args[0])
+	if err != nil {
+		return &usageError{fmt.Sprintf("Invalid from %q, expected an RFC 3339 time", cmd.args[0])}
+	}
+	var to *time.Time
+	if len(cmd.args) == 2 {
+		parsed, err := time.Parse(time.RFC3339, cmd.args[1])
+		if err != nil {
+			return &usageError{fmt.Sprintf("Invalid to %q, expected an RFC 3339 time", cmd.args[1])}
+		}
+		to = &parsed
+	}
+
+	server, err := cmd.server()
+	if err != nil {
+		return err
+	}
+	defer server.Shutdown()
+
+	diff, err := server.DiffSnapshots(from, to, *includeDiffs, *options)
+	if err != nil {
+		return err
+	}
+	return cmd.output(diff, func(w goio.Writer) error {
+		for _, change := range diff.Changes {
+			fmt.Fprintf(w, "%-8s %s/%s %s\n", change.Type, change.Group, change.ID, change.FriendlyName)
+			if change.Diff == nil {
+				continue
+			}
+			if change.Diff.Type == "binary" || change.Diff.TooLarge {
+				fmt.Fprintln(w, change.Diff.Summary)
+			} else {
+				fmt.Fprint(w, change.Diff.UnifiedDiff)
+			}
+		}
+		if len(diff.Changes) == 0 {
+			fmt.Fprintln(w, "No changes")
+		}
+		return nil
+	})
+}
+
+// diffOptionFlags adds flags for the options that control how lines are compared, returning the options they set
+func diffOptionFlags(cmd *invocation) *core.DiffOptions {
+	options := &core.DiffOptions{}
+	cmd.flags.StringVar(&options.Algorithm, "algorithm", core.DiffAlgorithmMyers,
+		"line matching algorithm: myers, patience or histogram")
+	cmd.flags.BoolVar(&options.IgnoreWhitespace, "ignore-whitespace", false, "ignore changes in the amount of whitespace")
+	cmd.flags.BoolVar(&options.IgnoreComments, "ignore-comments", false, "ignore YAML comments")
+	cmd.flags.BoolVar(&options.IgnoreKeyOrder, "ignore-key-order", false, "compare YAML and JSON with their keys sorted")
+	cmd.flags.BoolVar(&options.Raw, "raw", false, "compare the stored bytes, without indenting JSON first")
+	cmd.flags.Func("context", fmt.Sprintf("unchanged lines around each change (default %d)", core.DefaultDiffContext),
+		func(value string) error {
+			context, err := strconv.Atoi(value)
+			options.Context = &context
+			return err
+		})
+	return options
+}
+
+func printDiff(cmd *invocation, diff *core.BackupDiff) error {
+	return cmd.output(diff, func(w goio.Writer) error {
+		if diff.LiveMissing {
+			fmt.Fprintf(w, "The config no longer exists, showing %s removed\n", diff.OldFilename)
+		}
+		if diff.Approximate {
+			fmt.Fprintln(w, "The files took too long to compare, so some unchanged lines may be shown as replaced")
+		}
+		switch {
+		case diff.Type == "binary" || diff.TooLarge:
+			fmt.Fprintln(w, diff.Summary)
+		case diff.UnifiedDiff == "":
+			fmt.Fprintf(w, "No changes between %s and %s\n", diff.OldFilename, diff.NewFilename)
+		default:
+			fmt.Fprint(w, diff.UnifiedDiff)
+		}
+		return nil
+	})
+}
+
+func printStructuralDiff(cmd *invocation, backupDir, group, id, left, right string, options core.DiffOptions) error {
+	diff, err := core.DiffBackupsStructurally(backupDir, group, id, left, right, options)
+	if err != nil {
+		return err
+	}
+
+	return cmd.output(diff, func(w goio.Writer) error {
+		if diff.Approximate {
+			fmt.Fprintln(w, "The lists took too long to compare, so some items are compared by position")
+		}
+		if len(diff.Changes) == 0 {
+			fmt.Fprintf(w, "No changes between %s and %s\n", left, right)
+		}
+		for _, change := range diff.Changes {
+			fmt.Fprintln(w, change.String())
+		}
+		return nil
+	})
+}
+
+// RestoreOutput is the JSON output of the restore command
+type RestoreOutput struct {
+	types.ConfigIdentifier
+	Filename string `json:"filename"`
+	Path     string `json:"path"`
+}
+
+func runRestore(cmd *invocation) error {
+	if err := cmd.parse(3, 3); err != nil {
+		return err
+	}
+	group, id, filename := cmd.args[0], cmd.args[1], cmd.args[2]
+
+	server, err := cmd.server()
+	if err != nil {
+		return err
+	}
+	defer server.Shutdown()
+
+	options := server.FindConfigOptions(group)
+	if options == nil {
+		return fmt.Errorf("config not found in settings: %s", group)
+	}
+
+	content, err := io.GetConfigBackup(server.Settings().BackupDir, group, id, filename)
+	if err != nil {
+		return err
+	}
+
+	path, err := io.RestoreBackup(server.Settings().HomeAssistantConfigDir, options, id, content)
+	if err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	output := RestoreOutput{
+		ConfigIdentifier: types.ConfigIdentifier{Group: group, ID: id},
+		Filename:         filename,
+		Path:             path,
+	}
+	return cmd.output(output, func(w goio.Writer) error {
+		fmt.Fprintf(w, "Restored %s/%s %s to %s\n", group, id, filename, path)
+		return nil
+	})
+}
+
+func runVerify(cmd *invocation) error {
+	if err := cmd.parse(0, 0); err != nil {
+		return err
+	}
+
+	server, err := cmd.server()
+	if err != nil {
+		return err
+	}
+	defer server.Shutdown()
+
+	issues := server.Verify()
+	errorCount := 0
+	for _, issue := range issues {
+		if issue.Severity == core.VerifySeverityError {
+			errorCount++
+		}
+	}
+
+	err = cmd.output(issues, func(w goio.Writer) error {
+		for _, issue := range issues {
+			location := ""
+			if issue.Group != "" {
+				location = issue.Group + "/" + issue.ID + " "
+			}
+			if issue.Filename != "" {
+				location += issue.Filename + " "
+			}
+			fmt.Fprintf(w, "%-7s %s%s\n", issue.Severity, location, issue.Message)
+		}
+		fmt.Fprintf(w, "%d errors, %d warnings\n", errorCount, len(issues)-errorCount)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if errorCount > 0 {
+		return fmt.Errorf("verify found %d errors", errorCount)
+	}
+	return nil
+}
+
+func runPrune(cmd *invocation) error {
+	dryRun := cmd.flags.Bool("dry-run", false, "list the backups that would be removed without removing them")
+	if err := cmd.parse(0, 0); err != nil {
+		return err
+	}
+
+	server, err := cmd.server()
+	if err != nil {
+		return err
+	}
+	defer server.Shutdown()
+
+	pruned, pruneErr := server.Prune(*dryRun)
+	err = cmd.output(pruned, func(w goio.Writer) error {
+		action := "Removed"
+		if *dryRun {
+			action = "Would remove"
+		}
+		for _, backup := range pruned {
+			fmt.Fprintf(w, "%s %s/%s %s (%s)\n", action, backup.Group, backup.ID, backup.Filename, backup.Reason)
+		}
+		if len(pruned) == 0 {
+			fmt.Fprintln(w, "Nothing to prune")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return pruneErr
+}
+
+// listBackups returns a config's backups, newest first, checking there are at least minBackups
+func listBackups(backupDir, group, id string, minBackups int) ([]io.BackupInfo, error) {
+	backups, err := io.ListConfigBackups(backupDir, group, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) < minBackups {
+		return nil, fmt.Errorf("expected at least %d backups of %s/%s, found %d", minBackups, group, id, len(backups))
+	}
+	return backups, nil
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"errors"
+	"ha-config-history/internal/types"
+	"path"
+)
+
+// ConfigVersion identifies a version of a config item: a backup filename, or LiveFilename for its current content
+type ConfigVersion struct {
+	types.ConfigIdentifier
+	Filename string `json:"filename"`
+}
+
+// label names the version in a unified diff, including the config when comparing across configs
+func (v ConfigVersion) label(other ConfigVersion) string {
+	if v.ConfigIdentifier == other.ConfigIdentifier {
+		return v.Filename
+	}
+	return path.Join(v.Group, v.ID, v.Filename)
+}
+
+// DiffVersions compares two versions, which can belong to different configs, eg. an automation and the copy it was
+// made from. When a live side no longer exists the diff is against empty content and LiveMissing is set.
+func (s *Server) DiffVersions(left, right ConfigVersion, options DiffOptions) (*BackupDiff, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	leftInput, leftMissing, err := s.readVersionInput(left, "left", nil)
+	if err != nil {
+		return nil, err
+	}
+	rightInput, rightMissing, err := s.readVersionInput(right, "right", nil)
+	if err != nil {
+		return nil, err
+	}
+	leftInput.label, rightInput.label = left.label(right), right.label(left)
+
+	diff, err := diffInputs(leftInput, rightInput, options)
+	if err != nil {
+		return nil, err
+	}
+	diff.LiveMissing = leftMissing || rightMissing
+	if left.ConfigIdentifier != right.ConfigIdentifier {
+		diff.OldConfig, diff.NewConfig = &left.ConfigIdentifier, &right.ConfigIdentifier
+	}
+	return diff, nil
+}
+
+// readVersionInput reads a version to be compared, reporting whether it's a live config that no longer exists. Live
+// items are looked up in live, when given, so comparing many items of a config reads its file once.
+func (s *Server) readVersionInput(version ConfigVersion, side string, live liveItems) (diffInput, bool, error) {
+	if version.Filename != LiveFilename {
+		input, err := readBackupInput(s.Settings().BackupDir, version.Group, version.ID, version.Filename, side)
+		return input, false, err
+	}
+
+	configBackup, err := s.readLiveConfig(version.Group, version.ID, live)
+	if errors.Is(err, ErrLiveConfigNotFound) {
+		return diffInput{filename: LiveFilename}, true, nil
+	}
+	if err != nil {
+		return diffInput{}, false, err
+	}
+	input, err := liveInput(configBackup)
+	return input, false, err
+}
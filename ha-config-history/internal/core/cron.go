@@ -12,17 +12,17 @@ func (s *Server) RestartCronJob() error {
 		s.State.CronJob.Stop()
 	}
 
-	if s.AppSettings.CronSchedule == nil || *s.AppSettings.CronSchedule == "" {
+	schedule := cronSchedule(s.Settings())
+	if schedule == "" {
 		slog.Info("No cron schedule configured, cron job disabled")
 		s.State.CronJob = nil
 		return nil
 	}
 
-	schedule := *s.AppSettings.CronSchedule
 	slog.Info("Setting up cron job", "schedule", schedule)
 
 	s.State.CronJob = cron.New()
-	_, err := s.State.CronJob.AddFunc(*s.AppSettings.CronSchedule, s.runCronJobOnce)
+	_, err := s.State.CronJob.AddFunc(schedule, s.runCronJobOnce)
 	if err != nil {
 		slog.Error("Failed to add cron job", "error", err)
 		return fmt.Errorf("failed to add cron job: %w", err)
@@ -32,8 +32,13 @@ func (s *Server) RestartCronJob() error {
 }
 
 func (s *Server) runCronJobOnce() {
+	if s.PauseStatus().Global != nil {
+		slog.Info("Maintenance mode enabled, skipping scheduled backup")
+		return
+	}
+
 	slog.Info("Running scheduled backup")
-	s.ProcessAllConfigOptions()
+	s.StartBackupJob(JobKindScheduled, false)
 }
 
 func ValidateCronSchedule(schedule string) error {
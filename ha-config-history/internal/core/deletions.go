@@ -0,0 +1,92 @@
+package core
+
+import (
+	"fmt"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// queueDeletions queues the deletion of every item of a config that has backups but is no longer among the items
+// present in it. Deletions go through the backup queue so they're recorded in order with the item's backups.
+func (s *Server) queueDeletions(
+	settings *types.AppSettings,
+	options *types.ConfigBackupOptions,
+	present map[string]struct{},
+	job *Job,
+) {
+	s.State.Mu.RLock()
+	deleted := []types.ConfigIdentifier{}
+	for identifier, metadata := range s.State.CachedConfigMetadata {
+		if identifier.Group != options.Path || metadata.Deleted {
+			continue
+		}
+		if _, exists := present[identifier.ID]; !exists {
+			deleted = append(deleted, identifier)
+		}
+	}
+	s.State.Mu.RUnlock()
+
+	for _, identifier := range deleted {
+		s.queueDeletion(settings, options, identifier, job)
+	}
+}
+
+// queueDeletion queues the deletion of a single item, eg. a file removed from a directory config
+func (s *Server) queueDeletion(
+	settings *types.AppSettings,
+	options *types.ConfigBackupOptions,
+	identifier types.ConfigIdentifier,
+	job *Job,
+) {
+	s.enqueue(BackupJob{
+		Options:  options,
+		Backup:   &types.ConfigBackup{ConfigIdentifier: identifier, BackupType: options.BackupType},
+		Job:      job,
+		Settings: settings,
+		Deleted:  true,
+	})
+}
+
+// handleDeletion records that an item was found missing from its config, so it isn't reported as existing at later
+// times. Items without backups, or already recorded as deleted, are left alone.
+func (s *Server) handleDeletion(settings *types.AppSettings, configBackup *types.ConfigBackup) error {
+	s.State.Mu.RLock()
+	metadata, exists := s.State.CachedConfigMetadata[configBackup.ConfigIdentifier]
+	s.State.Mu.RUnlock()
+
+	if !exists || metadata.Deleted {
+		return nil
+	}
+
+	slog.Info("Config deleted, recording deletion",
+		"friendlyName", metadata.FriendlyName,
+		"id", metadata.ID,
+	)
+
+	updatedMetadata := *metadata
+	updatedMetadata.Deleted = true
+	updatedMetadata.Deletions = append(slices.Clone(metadata.Deletions), time.Now().UTC())
+
+	backupDir, err := io.GetBackupDirectory(settings.BackupDir, configBackup)
+	if err != nil {
+		return fmt.Errorf("failed to get backup directory for %s: %w", configBackup.ID, err)
+	}
+	if err := io.SaveMetadata(backupDir, &updatedMetadata); err != nil {
+		return fmt.Errorf("failed to record deletion of %s: %w", configBackup.ID, err)
+	}
+
+	s.cacheMetadata(settings, &updatedMetadata)
+	return nil
+}
+
+// itemIDs returns the ids of a config's current items
+func itemIDs(current []*types.ConfigBackup) map[string]struct{} {
+	ids := make(map[string]struct{}, len(current))
+	for _, configBackup := range current {
+		ids[configBackup.ID] = struct{}{}
+	}
+	return ids
+}
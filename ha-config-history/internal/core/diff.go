@@ -0,0 +1,186 @@
+package core
+
+import (
+	"cmp"
+	"fmt"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+)
+
+// BackupNotFoundError is returned when a backup being compared doesn't exist or can't be read
+type BackupNotFoundError struct {
+	// Side is "left" or "right"
+	Side string
+	Err  error
+}
+
+func (e *BackupNotFoundError) Error() string {
+	return fmt.Sprintf("error loading %s backup file: %v", e.Side, e.Err)
+}
+
+func (e *BackupNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+type BackupDiff struct {
+	Type          string `json:"type"`
+	UnifiedDiff   string `json:"unifiedDiff"`
+	Content       string `json:"content"`
+	OldContent    string `json:"oldContent"`
+	NewContent    string `json:"newContent"`
+	OldFilename   string `json:"oldFilename"`
+	NewFilename   string `json:"newFilename"`
+	IsFirstBackup bool   `json:"isFirstBackup"`
+	FormatOnly    bool   `json:"formatOnly"`
+	// Summary describes changes to binary files and files too large to compare, which have no text diff
+	Summary string `json:"summary,omitempty"`
+	// TooLarge is set when either file is larger than DiffOptions.MaxSize, so only a summary is returned
+	TooLarge bool `json:"tooLarge,omitempty"`
+	// Approximate is set when the time budget ran out, so some lines that are unchanged may be shown as replaced
+	Approximate bool `json:"approximate,omitempty"`
+	// Hunks are the changed lines of the unified diff with their line numbers and the changes within modified lines
+	Hunks []DiffHunk `json:"hunks,omitempty"`
+	// LiveMissing is set when comparing against the live config and it no longer exists, so the diff shows the
+	// whole backup removed
+	LiveMissing bool `json:"liveMissing,omitempty"`
+	// OldConfig and NewConfig are set when comparing versions of different configs
+	OldConfig *types.ConfigIdentifier `json:"oldConfig,omitempty"`
+	NewConfig *types.ConfigIdentifier `json:"newConfig,omitempty"`
+}
+
+// DiffBackups compares two backups of a config, returning a unified diff, or a summary when either is binary
+func DiffBackups(
+	backupFolder, group, id, leftFilename, rightFilename string, options DiffOptions,
+) (*BackupDiff, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	left, err := readBackupInput(backupFolder, group, id, leftFilename, "left")
+	if err != nil {
+		return nil, err
+	}
+	right, err := readBackupInput(backupFolder, group, id, rightFilename, "right")
+	if err != nil {
+		return nil, err
+	}
+
+	return diffInputs(left, right, options)
+}
+
+// diffInput is one side of a comparison. Binary inputs are compared by hash and size, so their content isn't read.
+type diffInput struct {
+	filename string
+	// label names the input in the unified diff, the filename by default
+	label   string
+	content []byte
+	binary  bool
+	size    int64
+	hash    string
+}
+
+// readBackupInput reads a backup to be compared, returning a BackupNotFoundError for the side when it can't be read
+func readBackupInput(backupFolder, group, id, filename, side string) (diffInput, error) {
+	path, err := io.GetConfigBackupPath(backupFolder, group, id, filename)
+	if err != nil {
+		return diffInput{}, &BackupNotFoundError{Side: side, Err: err}
+	}
+
+	binary, size, err := io.SniffFile(path)
+	if err != nil {
+		return diffInput{}, &BackupNotFoundError{Side: side, Err: err}
+	}
+	if binary {
+		hash, err := io.HashFile(path)
+		if err != nil {
+			return diffInput{}, &BackupNotFoundError{Side: side, Err: err}
+		}
+		return diffInput{filename: filename, binary: true, size: size, hash: hash}, nil
+	}
+
+	content, err := io.GetConfigBackup(backupFolder, group, id, filename)
+	if err != nil {
+		return diffInput{}, &BackupNotFoundError{Side: side, Err: err}
+	}
+	return diffInput{filename: filename, content: content, size: int64(len(content))}, nil
+}
+
+// diffInputs compares two inputs, summarising the change when either is binary or too large to compare
+func diffInputs(left, right diffInput, options DiffOptions) (*BackupDiff, error) {
+	if left.binary || right.binary {
+		return &BackupDiff{
+			Type:        "binary",
+			OldFilename: left.filename,
+			NewFilename: right.filename,
+			Summary:     binaryDiffSummary(left, right),
+		}, nil
+	}
+	if left.size > options.MaxSize || right.size > options.MaxSize {
+		return &BackupDiff{
+			Type:        "diff",
+			OldFilename: left.filename,
+			NewFilename: right.filename,
+			Summary: fmt.Sprintf("too large to compare (%s → %s)",
+				FormatSize(left.size), FormatSize(right.size)),
+			TooLarge: true,
+		}, nil
+	}
+	return diffText(left, right, options)
+}
+
+// diffText compares two versions of a text file. Unless comparing raw bytes, JSON is indented first, and when
+// ignoring key order the documents have their keys sorted. The versions returned are the ones compared.
+func diffText(left, right diffInput, options DiffOptions) (*BackupDiff, error) {
+	oldContent, newContent := left.content, right.content
+	if !options.Raw {
+		oldContent, _ = types.FormatJSON(oldContent)
+		newContent, _ = types.FormatJSON(newContent)
+	}
+	if options.IgnoreKeyOrder {
+		var err error
+		if oldContent, err = sortKeys(oldContent); err != nil {
+			return nil, fmt.Errorf("%w: left backup: %v", ErrIgnoreKeyOrderUnsupported, err)
+		}
+		if newContent, err = sortKeys(newContent); err != nil {
+			return nil, fmt.Errorf("%w: right backup: %v", ErrIgnoreKeyOrderUnsupported, err)
+		}
+	}
+
+	leftLabel, rightLabel := cmp.Or(left.label, left.filename), cmp.Or(right.label, right.filename)
+	unified, approximate := diffLines(leftLabel, rightLabel, string(oldContent), string(newContent), options)
+
+	return &BackupDiff{
+		Type:          "diff",
+		UnifiedDiff:   fmt.Sprint(unified),
+		OldContent:    string(oldContent),
+		NewContent:    string(newContent),
+		OldFilename:   left.filename,
+		NewFilename:   right.filename,
+		IsFirstBackup: false,
+		FormatOnly:    types.IsFormatOnlyChange(left.content, right.content),
+		Hunks:         buildHunks(unified, options.Granularity),
+		Approximate:   approximate,
+	}, nil
+}
+
+// binaryDiffSummary describes a change to a binary file, eg. "binary changed (1.2 KB → 3.4 KB)"
+func binaryDiffSummary(left, right diffInput) string {
+	if left.hash != "" && left.hash == right.hash {
+		return fmt.Sprintf("binary unchanged (%s)", FormatSize(left.size))
+	}
+	return fmt.Sprintf("binary changed (%s → %s)", FormatSize(left.size), FormatSize(right.size))
+}
+
+// FormatSize formats a size in bytes for display, eg. "1.2 KB"
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	divisor, exponent := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		divisor *= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(divisor), "KMGT"[exponent])
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DiffGranularityWord = "word"
+	DiffGranularityChar = "char"
+)
+
+const (
+	DiffAlgorithmMyers     = "myers"
+	DiffAlgorithmPatience  = "patience"
+	DiffAlgorithmHistogram = "histogram"
+)
+
+const (
+	// DefaultDiffContext is the number of unchanged lines shown around each hunk, as in a unified diff
+	DefaultDiffContext = 3
+	// DefaultDiffMaxSize is the largest file compared line by line, larger files are summarised
+	DefaultDiffMaxSize = 8 << 20
+	// MaxDiffMaxSize is the largest MaxSize that can be requested
+	MaxDiffMaxSize = 64 << 20
+	// DefaultDiffTimeout limits the time spent comparing two files
+	DefaultDiffTimeout = 2 * time.Second
+	// MaxDiffTimeout is the longest Timeout that can be requested
+	MaxDiffTimeout = 30 * time.Second
+)
+
+// DiffOptions control how backups are compared
+type DiffOptions struct {
+	// Granularity is the size of the changes marked within modified lines: DiffGranularityWord (the default) or
+	// DiffGranularityChar
+	Granularity string
+	// Algorithm matches unchanged lines: DiffAlgorithmMyers (the default), DiffAlgorithmPatience or
+	// DiffAlgorithmHistogram. Patience and histogram line up unique lines first, which keeps moved blocks and
+	// repeated lines like "- service: light.turn_on" from being matched out of place.
+	Algorithm string
+	// Context is the number of unchanged lines around each hunk, DefaultDiffContext by default
+	Context *int
+	// IgnoreWhitespace treats lines that differ only in the amount of whitespace, or in trailing whitespace, as
+	// unchanged
+	IgnoreWhitespace bool
+	// IgnoreComments compares lines without their YAML comments, and leaves out hunks that only add or remove
+	// comment lines
+	IgnoreComments bool
+	// IgnoreKeyOrder compares YAML and JSON documents with their mapping keys sorted. The diff is of the sorted
+	// documents, and documents that can't be parsed return ErrIgnoreKeyOrderUnsupported.
+	IgnoreKeyOrder bool
+	// Raw compares the stored bytes as they are. By default JSON objects and arrays are indented first, so files
+	// written on one line, like some .storage files, don't show up as a single replaced line.
+	Raw bool
+	// MaxSize is the largest file, in bytes, compared line by line, DefaultDiffMaxSize by default. Larger files are
+	// summarised like binary files.
+	MaxSize int64
+	// Timeout limits the time spent matching lines, DefaultDiffTimeout by default. When it runs out the rest of the
+	// diff is finished coarsely and the diff is marked approximate.
+	Timeout time.Duration
+}
+
+// Validate checks the options, filling in defaults
+func (o *DiffOptions) Validate() error {
+	switch o.Granularity {
+	case "":
+		o.Granularity = DiffGranularityWord
+	case DiffGranularityWord, DiffGranularityChar:
+	default:
+		return fmt.Errorf("invalid granularity %q, expected %s or %s", o.Granularity,
+			DiffGranularityWord, DiffGranularityChar)
+	}
+
+	switch o.Algorithm {
+	case "":
+		o.Algorithm = DiffAlgorithmMyers
+	case DiffAlgorithmMyers, DiffAlgorithmPatience, DiffAlgorithmHistogram:
+	default:
+		return fmt.Errorf("invalid algorithm %q, expected %s, %s or %s", o.Algorithm,
+			DiffAlgorithmMyers, DiffAlgorithmPatience, DiffAlgorithmHistogram)
+	}
+
+	if o.Context == nil {
+		context := DefaultDiffContext
+		o.Context = &context
+	} else if *o.Context < 0 {
+		return fmt.Errorf("invalid context %d, expected 0 or more lines", *o.Context)
+	}
+
+	if o.MaxSize == 0 {
+		o.MaxSize = DefaultDiffMaxSize
+	} else if o.MaxSize < 0 || o.MaxSize > MaxDiffMaxSize {
+		return fmt.Errorf("invalid max size %d, expected up to %d bytes", o.MaxSize, MaxDiffMaxSize)
+	}
+
+	if o.Timeout == 0 {
+		o.Timeout = DefaultDiffTimeout
+	} else if o.Timeout < 0 || o.Timeout > MaxDiffTimeout {
+		return fmt.Errorf("invalid timeout %s, expected up to %s", o.Timeout, MaxDiffTimeout)
+	}
+	return nil
+}
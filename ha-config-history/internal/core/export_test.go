@@ -0,0 +1,6 @@
+package core
+
+// FsnotifyWatchList returns the directories watched with fsnotify, which WatcherStatus reports only one backend of
+func (s *Server) FsnotifyWatchList() []string {
+	return s.fileWatcher.WatchList()
+}
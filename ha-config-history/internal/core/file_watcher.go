@@ -1,13 +1,21 @@
 package core
 
 import (
+	"errors"
 	"ha-config-history/internal/io"
 	"ha-config-history/internal/types"
 	"log/slog"
+	"maps"
+	"os"
 	"path/filepath"
+	"slices"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 func (s *Server) startFileWatcher() {
+	s.poller.Start()
+
 	go func() {
 		for {
 			select {
@@ -15,91 +23,219 @@ func (s *Server) startFileWatcher() {
 				if !ok {
 					return
 				}
-				slog.Debug("File watcher event", "file", event.Name, "event", event.Op)
-
-				s.State.Mu.RLock()
-				options, exists := s.State.FileLookup[event.Name]
-				s.State.Mu.RUnlock()
-
-				if !exists {
-					slog.Debug("No backup options found for changed file", "file", event.Name)
-					continue
-				}
-
-				if options.BackupType == "single" {
-					backup, err := io.ReadSingleConfigFromSingleFilename(s.AppSettings.HomeAssistantConfigDir, options.Path, options)
-					if err != nil {
-						slog.Error("Error reading updated config from file", "file", event.Name, "error", err)
-						continue
-					}
-
-					s.queue <- BackupJob{
-						Options: options,
-						Backup:  backup,
-					}
-				}
-
-				if options.BackupType == "directory" {
-					filename := filepath.Base(event.Name)
-					fullDirectory := filepath.Dir(event.Name)
-					backup, err := io.ReadSingleConfigFromSingleFilename(fullDirectory, filename, options)
-					if err != nil {
-						slog.Error("Error reading updated config from file", "file", event.Name, "error", err)
-						continue
-					}
-
-					s.queue <- BackupJob{
-						Options: options,
-						Backup:  backup,
-					}
-				}
+				s.handleFileEvent(event)
 
-				if options.BackupType == "multiple" {
-					current, err := io.ReadMultipleConfigsFromSingleFile(s.AppSettings.HomeAssistantConfigDir, options)
-					if err != nil {
-						slog.Error("Error reading updated multiple configs from file", "file", event.Name, "error", err)
-						continue
-					}
-
-					for _, configBackup := range current {
-						s.queue <- BackupJob{
-							Options: options,
-							Backup:  configBackup,
-						}
-					}
+			case event, ok := <-s.poller.Events:
+				if !ok {
+					return
 				}
+				s.handleFileEvent(event)
 
 			case err, ok := <-s.fileWatcher.Errors:
 				if !ok {
 					return
 				}
 				slog.Error("File watcher error", "error", err)
+
+			case err, ok := <-s.poller.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("Polling watcher error", "error", err)
 			}
 		}
 	}()
 }
 
+// handleFileEvent queues backups for a changed file, regardless of which watcher backend noticed the change
+func (s *Server) handleFileEvent(event fsnotify.Event) {
+	slog.Debug("File watcher event", "file", event.Name, "event", event.Op)
+
+	if s.isSettingsFile(event.Name) {
+		if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) {
+			s.reloadSettingsFile(event.Name)
+		}
+		return
+	}
+
+	settings := s.Settings()
+	s.State.Mu.RLock()
+	options, exists := s.State.FileLookup[event.Name]
+	includeRoot, included := s.State.IncludeRoots[event.Name]
+	s.State.Mu.RUnlock()
+
+	if included && s.isPaused(includeRoot) {
+		slog.Debug("Backups paused, ignoring change", "file", event.Name, "root", includeRoot.Path)
+		return
+	}
+
+	if included {
+		slog.Debug("Included file changed, resolving includes", "file", event.Name, "root", includeRoot.Path)
+		s.processIncludes(settings, includeRoot, nil)
+
+		// Include graphs leave out files tracked by a config of their own, so those are still processed below
+		ownConfig := exists && slices.ContainsFunc(settings.Configs, func(config *types.ConfigBackupOptions) bool {
+			return config.Path == options.Path && config.BackupType != types.BackupTypeIncludesName
+		})
+		if !ownConfig {
+			return
+		}
+	}
+
+	if !exists {
+		slog.Debug("No backup options found for changed file", "file", event.Name)
+		return
+	}
+
+	if s.isPaused(options) {
+		slog.Debug("Backups paused, ignoring change", "file", event.Name)
+		return
+	}
+
+	if options.BackupType == "single" {
+		backup, err := io.ReadSingleConfigFromSingleFilename(settings.HomeAssistantConfigDir, options.Path, options)
+		if errors.Is(err, io.ErrFileTooLarge) {
+			slog.Warn("Skipping file larger than max file size", "error", err)
+			return
+		}
+		if err != nil {
+			slog.Error("Error reading updated config from file", "file", event.Name, "error", err)
+			return
+		}
+
+		s.enqueue(BackupJob{
+			Options:  options,
+			Backup:   backup,
+			Settings: settings,
+		})
+	}
+
+	if options.BackupType == "directory" {
+		filename := filepath.Base(event.Name)
+		fullDirectory := filepath.Dir(event.Name)
+		backup, err := io.ReadSingleConfigFromSingleFilename(fullDirectory, filename, options)
+		if errors.Is(err, os.ErrNotExist) {
+			s.queueDeletion(settings, options, types.ConfigIdentifier{Group: options.Path, ID: filename}, nil)
+			return
+		}
+		if errors.Is(err, io.ErrFileTooLarge) {
+			slog.Warn("Skipping file larger than max file size", "error", err)
+			return
+		}
+		if err != nil {
+			slog.Error("Error reading updated config from file", "file", event.Name, "error", err)
+			return
+		}
+
+		s.enqueue(BackupJob{
+			Options:  options,
+			Backup:   backup,
+			Settings: settings,
+		})
+	}
+
+	if options.BackupType == "multiple" {
+		current, err := io.ReadMultipleConfigsFromSingleFile(settings.HomeAssistantConfigDir, options)
+		if err != nil {
+			slog.Error("Error reading updated multiple configs from file", "file", event.Name, "error", err)
+			return
+		}
+
+		for _, configBackup := range current {
+			s.enqueue(BackupJob{
+				Options:  options,
+				Backup:   configBackup,
+				Settings: settings,
+			})
+		}
+		s.queueDeletions(settings, options, itemIDs(current), nil)
+	}
+
+	if options.BackupType == "mapping" {
+		current, err := io.ReadKeyedConfigsFromSingleFile(settings.HomeAssistantConfigDir, options)
+		if err != nil {
+			slog.Error("Error reading updated mapping configs from file", "file", event.Name, "error", err)
+			return
+		}
+
+		for _, configBackup := range current {
+			s.enqueue(BackupJob{
+				Options:  options,
+				Backup:   configBackup,
+				Settings: settings,
+			})
+		}
+		s.queueDeletions(settings, options, itemIDs(current), nil)
+	}
+
+	if options.BackupType == "json" {
+		current, err := io.ReadJsonConfigsFromSingleFile(settings.HomeAssistantConfigDir, options)
+		if err != nil {
+			slog.Error("Error reading updated json configs from file", "file", event.Name, "error", err)
+			return
+		}
+
+		for _, configBackup := range current {
+			s.enqueue(BackupJob{
+				Options:  options,
+				Backup:   configBackup,
+				Settings: settings,
+			})
+		}
+		s.queueDeletions(settings, options, itemIDs(current), nil)
+	}
+}
+
 func (s *Server) watchDirectoryForFile(path string, options *types.ConfigBackupOptions) error {
 	directory := filepath.Dir(path)
 	slog.Info("Adding directory to watcher for file", "directory", directory, "file", options.Path)
 
-	for _, existing := range s.fileWatcher.WatchList() {
-		if existing == directory {
-			slog.Info("Directory already being watched", "directory", directory)
-			s.State.Mu.Lock()
-			s.State.FileLookup[path] = options
-			s.State.Mu.Unlock()
-			return nil
+	s.State.Mu.Lock()
+	s.State.FileLookup[path] = options
+	backend, watched := s.State.WatchBackends[directory]
+	s.State.Mu.Unlock()
+
+	if watched && (backend == WatcherBackendPoll || options.Watcher != WatcherBackendPoll) {
+		slog.Info("Directory already being watched", "directory", directory, "backend", backend)
+		return nil
+	}
+
+	var err error
+	if options.Watcher == WatcherBackendPoll {
+		backend = WatcherBackendPoll
+		err = s.poller.Add(directory)
+	} else {
+		backend = WatcherBackendFsnotify
+		err = s.fileWatcher.Add(directory)
+		if err != nil && options.Watcher != WatcherBackendFsnotify {
+			slog.Warn("Unable to watch directory with fsnotify, falling back to polling", "directory", directory, "error", err)
+			backend = WatcherBackendPoll
+			err = s.poller.Add(directory)
 		}
 	}
 
-	err := s.fileWatcher.Add(directory)
 	if err != nil {
-		slog.Error("Error adding directory watcher", "error", err)
+		slog.Error("Error adding directory watcher", "backend", backend, "error", err)
+		return err
+	}
+
+	// A directory is watched by one backend, so switching to polling stops fsnotify reporting the same changes again
+	if watched {
+		if err := s.fileWatcher.Remove(directory); err != nil {
+			slog.Warn("Error removing fsnotify watcher", "directory", directory, "error", err)
+		}
+		slog.Info("Switched directory watcher to polling", "directory", directory)
 	}
 
 	s.State.Mu.Lock()
-	s.State.FileLookup[path] = options
+	s.State.WatchBackends[directory] = backend
 	s.State.Mu.Unlock()
-	return err
+	return nil
+}
+
+// WatcherStatus returns the backend watching each directory
+func (s *Server) WatcherStatus() map[string]string {
+	s.State.Mu.RLock()
+	defer s.State.Mu.RUnlock()
+	return maps.Clone(s.State.WatchBackends)
 }
@@ -0,0 +1,204 @@
+package core
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/hexops/gotextdiff"
+)
+
+const (
+	DiffLineContext = "context"
+	DiffLineAdded   = "added"
+	DiffLineRemoved = "removed"
+)
+
+// modifiedLineThreshold is how similar a removed and added line must be to be treated as one modified line, with
+// the changes within it marked
+const modifiedLineThreshold = 0.5
+
+// maxSpanTokens limits the size of lines compared for change spans, as the comparison is quadratic
+const maxSpanTokens = 2000
+
+// DiffHunk is a group of changed lines with their surrounding context, like a hunk of a unified diff
+type DiffHunk struct {
+	OldStart int        `json:"oldStart"`
+	OldLines int        `json:"oldLines"`
+	NewStart int        `json:"newStart"`
+	NewLines int        `json:"newLines"`
+	Lines    []DiffLine `json:"lines"`
+}
+
+// DiffLine is a line of a hunk. Context lines have both line numbers, removed lines only the old one and added lines
+// only the new one.
+type DiffLine struct {
+	Kind    string `json:"kind"`
+	OldLine int    `json:"oldLine,omitempty"`
+	NewLine int    `json:"newLine,omitempty"`
+	Content string `json:"content"`
+	// Spans mark the changed parts of a modified line, a removed line paired with the added line that replaced it.
+	// Lines that were replaced outright have no spans.
+	Spans []DiffSpan `json:"spans,omitempty"`
+}
+
+// DiffSpan is a changed part of a line, from Start up to End, counted in characters (Unicode code points)
+type DiffSpan struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// buildHunks converts a unified diff to hunks with line numbers, marking changes within modified lines
+func buildHunks(unified gotextdiff.Unified, granularity string) []DiffHunk {
+	hunks := make([]DiffHunk, 0, len(unified.Hunks))
+	for _, unifiedHunk := range unified.Hunks {
+		hunk := DiffHunk{OldStart: unifiedHunk.FromLine, NewStart: unifiedHunk.ToLine, Lines: []DiffLine{}}
+		oldLine, newLine := unifiedHunk.FromLine, unifiedHunk.ToLine
+
+		for _, line := range unifiedHunk.Lines {
+			content := strings.TrimSuffix(line.Content, "\n")
+			switch line.Kind {
+			case gotextdiff.Delete:
+				hunk.Lines = append(hunk.Lines, DiffLine{Kind: DiffLineRemoved, OldLine: oldLine, Content: content})
+				oldLine++
+				hunk.OldLines++
+			case gotextdiff.Insert:
+				hunk.Lines = append(hunk.Lines, DiffLine{Kind: DiffLineAdded, NewLine: newLine, Content: content})
+				newLine++
+				hunk.NewLines++
+			default:
+				hunk.Lines = append(hunk.Lines, DiffLine{
+					Kind:    DiffLineContext,
+					OldLine: oldLine,
+					NewLine: newLine,
+					Content: content,
+				})
+				oldLine++
+				newLine++
+				hunk.OldLines++
+				hunk.NewLines++
+			}
+		}
+
+		markModifiedLines(hunk.Lines, granularity)
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+// markModifiedLines pairs each run of removed lines with the run of added lines that follows it, in order, and marks
+// the changes within pairs that are similar enough to be the same line modified
+func markModifiedLines(lines []DiffLine, granularity string) {
+	for i := 0; i < len(lines); {
+		if lines[i].Kind != DiffLineRemoved {
+			i++
+			continue
+		}
+
+		removedStart := i
+		for i < len(lines) && lines[i].Kind == DiffLineRemoved {
+			i++
+		}
+		addedStart := i
+		for i < len(lines) && lines[i].Kind == DiffLineAdded {
+			i++
+		}
+
+		for pair := 0; removedStart+pair < addedStart && addedStart+pair < i; pair++ {
+			removed, added := &lines[removedStart+pair], &lines[addedStart+pair]
+			removed.Spans, added.Spans = changeSpans(removed.Content, added.Content, granularity)
+		}
+	}
+}
+
+// changeSpans compares two versions of a line token by token, returning the spans removed from the old line and
+// added to the new one. Lines that are too different to be the same line modified have no spans.
+func changeSpans(oldLine, newLine, granularity string) ([]DiffSpan, []DiffSpan) {
+	oldTokens, newTokens := tokenizeLine(oldLine, granularity), tokenizeLine(newLine, granularity)
+	if len(oldTokens) == 0 || len(newTokens) == 0 || len(oldTokens) > maxSpanTokens || len(newTokens) > maxSpanTokens {
+		return nil, nil
+	}
+
+	// common[i][j] is the length in characters of the longest common subsequence of oldTokens[i:] and newTokens[j:]
+	common := make([][]int, len(oldTokens)+1)
+	for i := range common {
+		common[i] = make([]int, len(newTokens)+1)
+	}
+	for i := len(oldTokens) - 1; i >= 0; i-- {
+		for j := len(newTokens) - 1; j >= 0; j-- {
+			if oldTokens[i] == newTokens[j] {
+				common[i][j] = common[i+1][j+1] + len([]rune(oldTokens[i]))
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	oldLength, newLength := len([]rune(oldLine)), len([]rune(newLine))
+	if 2*float64(common[0][0])/float64(oldLength+newLength) < modifiedLineThreshold {
+		return nil, nil
+	}
+
+	var oldSpans, newSpans []DiffSpan
+	oldOffset, newOffset := 0, 0
+	for i, j := 0, 0; i < len(oldTokens) || j < len(newTokens); {
+		switch {
+		case i < len(oldTokens) && j < len(newTokens) && oldTokens[i] == newTokens[j]:
+			oldOffset += len([]rune(oldTokens[i]))
+			newOffset += len([]rune(newTokens[j]))
+			i++
+			j++
+		case j == len(newTokens) || (i < len(oldTokens) && common[i+1][j] >= common[i][j+1]):
+			length := len([]rune(oldTokens[i]))
+			oldSpans = appendSpan(oldSpans, oldOffset, oldOffset+length)
+			oldOffset += length
+			i++
+		default:
+			length := len([]rune(newTokens[j]))
+			newSpans = appendSpan(newSpans, newOffset, newOffset+length)
+			newOffset += length
+			j++
+		}
+	}
+	return oldSpans, newSpans
+}
+
+// appendSpan adds a span, merging it with the previous span when they touch
+func appendSpan(spans []DiffSpan, start, end int) []DiffSpan {
+	if len(spans) > 0 && spans[len(spans)-1].End == start {
+		spans[len(spans)-1].End = end
+		return spans
+	}
+	return append(spans, DiffSpan{Start: start, End: end})
+}
+
+// tokenizeLine splits a line into characters, or into words, runs of whitespace and single punctuation characters
+func tokenizeLine(line, granularity string) []string {
+	tokens := []string{}
+	if granularity == DiffGranularityChar {
+		for _, char := range line {
+			tokens = append(tokens, string(char))
+		}
+		return tokens
+	}
+
+	isWord := func(char rune) bool {
+		return unicode.IsLetter(char) || unicode.IsDigit(char) || char == '_'
+	}
+	runes := []rune(line)
+	for start := 0; start < len(runes); {
+		end := start + 1
+		switch {
+		case isWord(runes[start]):
+			for end < len(runes) && isWord(runes[end]) {
+				end++
+			}
+		case unicode.IsSpace(runes[start]):
+			for end < len(runes) && unicode.IsSpace(runes[end]) {
+				end++
+			}
+		}
+		tokens = append(tokens, string(runes[start:end]))
+		start = end
+	}
+	return tokens
+}
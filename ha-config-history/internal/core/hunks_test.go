@@ -0,0 +1,125 @@
+package core_test
+
+import (
+	"ha-config-history/internal/core"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffHunks(t *testing.T) {
+	diff := func(t *testing.T, left, right string, options core.DiffOptions) *core.BackupDiff {
+		backupDir := t.TempDir()
+		configDir := filepath.Join(backupDir, "automations.yaml", "1234")
+		if err := os.MkdirAll(configDir, 0755); err != nil {
+			t.Fatalf("Failed to create backup dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(configDir, "20240101T000000.backup"), []byte(left), 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(configDir, "20240102T000000.backup"), []byte(right), 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+
+		result, err := core.DiffBackups(backupDir, "automations.yaml", "1234",
+			"20240101T000000.backup", "20240102T000000.backup", options)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return result
+	}
+
+	left := `id: '1234'
+alias: Heating
+condition:
+  - condition: template
+    value_template: "{{ states('sensor.temperature') | float < 18 }}"
+action:
+  - service: climate.turn_on
+`
+	right := `id: '1234'
+alias: Heating
+condition:
+  - condition: template
+    value_template: "{{ states('sensor.temperature') | float < 19 }}"
+action:
+  - service: climate.set_temperature
+`
+
+	t.Run("Hunks have line numbers and spans for modified lines", func(t *testing.T) {
+		result := diff(t, left, right, core.DiffOptions{})
+
+		expected := []core.DiffHunk{
+			{
+				OldStart: 2,
+				OldLines: 6,
+				NewStart: 2,
+				NewLines: 6,
+				Lines: []core.DiffLine{
+					{Kind: core.DiffLineContext, OldLine: 2, NewLine: 2, Content: "alias: Heating"},
+					{Kind: core.DiffLineContext, OldLine: 3, NewLine: 3, Content: "condition:"},
+					{Kind: core.DiffLineContext, OldLine: 4, NewLine: 4, Content: "  - condition: template"},
+					{
+						Kind:    core.DiffLineRemoved,
+						OldLine: 5,
+						Content: `    value_template: "{{ states('sensor.temperature') | float < 18 }}"`,
+						Spans:   []core.DiffSpan{{Start: 63, End: 65}},
+					},
+					{
+						Kind:    core.DiffLineAdded,
+						NewLine: 5,
+						Content: `    value_template: "{{ states('sensor.temperature') | float < 19 }}"`,
+						Spans:   []core.DiffSpan{{Start: 63, End: 65}},
+					},
+					{Kind: core.DiffLineContext, OldLine: 6, NewLine: 6, Content: "action:"},
+					{
+						Kind:    core.DiffLineRemoved,
+						OldLine: 7,
+						Content: "  - service: climate.turn_on",
+						Spans:   []core.DiffSpan{{Start: 21, End: 28}},
+					},
+					{
+						Kind:    core.DiffLineAdded,
+						NewLine: 7,
+						Content: "  - service: climate.set_temperature",
+						Spans:   []core.DiffSpan{{Start: 21, End: 36}},
+					},
+				},
+			},
+		}
+		if diff := cmp.Diff(expected, result.Hunks); diff != "" {
+			t.Errorf("Hunks do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Character granularity", func(t *testing.T) {
+		result := diff(t, left, right, core.DiffOptions{Granularity: core.DiffGranularityChar})
+
+		lines := result.Hunks[0].Lines
+		if diff := cmp.Diff([]core.DiffSpan{{Start: 64, End: 65}}, lines[3].Spans); diff != "" {
+			t.Errorf("Removed spans do not match expected:\n%s", diff)
+		}
+		if diff := cmp.Diff([]core.DiffSpan{{Start: 64, End: 65}}, lines[4].Spans); diff != "" {
+			t.Errorf("Added spans do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Replaced lines have no spans", func(t *testing.T) {
+		result := diff(t, "alias: Morning lights\n", "description: Turns on the kitchen\n", core.DiffOptions{})
+
+		for _, line := range result.Hunks[0].Lines {
+			if len(line.Spans) > 0 {
+				t.Errorf("Expected no spans for %q, got: %v", line.Content, line.Spans)
+			}
+		}
+	})
+
+	t.Run("Invalid granularity", func(t *testing.T) {
+		options := core.DiffOptions{Granularity: "sentence"}
+		if err := options.Validate(); err == nil {
+			t.Error("Expected an error for an invalid granularity")
+		}
+	})
+}
@@ -0,0 +1,281 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoHABackupDir is returned when listing Home Assistant backups without a backup directory in the settings
+var ErrNoHABackupDir = errors.New("no Home Assistant backup directory is set")
+
+// HABackups lists the archives in the Home Assistant backup directory, oldest first
+func (s *Server) HABackups() ([]*io.HABackup, error) {
+	backupDir := s.Settings().HomeAssistantBackupDir
+	if backupDir == "" {
+		return nil, ErrNoHABackupDir
+	}
+	return io.ListHABackups(backupDir)
+}
+
+// StartImportJob imports versions from Home Assistant backup archives in the background and returns immediately.
+// Paths can be archives or directories of archives, which are imported oldest first.
+func (s *Server) StartImportJob(paths []string) *Job {
+	job := newJob(JobKindImport, false)
+	s.jobs.add(job)
+	settings := s.Settings()
+
+	go func() {
+		job.start()
+		s.importHABackups(settings, paths, job)
+		job.finishEnqueue()
+	}()
+
+	return job
+}
+
+// ImportHABackups imports versions from Home Assistant backup archives and waits for the import to finish
+func (s *Server) ImportHABackups(paths []string) JobInfo {
+	job := s.StartImportJob(paths)
+	job.Wait()
+	return job.Info()
+}
+
+func (s *Server) importHABackups(settings *types.AppSettings, paths []string, job *Job) {
+	backups := []*io.HABackup{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			job.addError(fmt.Errorf("failed to read %s: %w", path, err))
+			continue
+		}
+
+		if info.IsDir() {
+			found, err := io.ListHABackups(path)
+			if err != nil {
+				job.addError(err)
+				continue
+			}
+			backups = append(backups, found...)
+			continue
+		}
+
+		backup, err := io.ReadHABackup(path)
+		if err != nil {
+			job.addError(err)
+			continue
+		}
+		backups = append(backups, backup)
+	}
+	io.SortHABackups(backups)
+
+	for _, backup := range backups {
+		if job.Cancelled() {
+			return
+		}
+		if err := s.importHABackup(settings, backup, job); err != nil {
+			slog.Error("Error importing Home Assistant backup", "file", backup.Path, "error", err)
+			job.addError(err)
+		}
+	}
+}
+
+// importHABackup extracts the files tracked by the configs from a backup archive and saves each item as a version
+// dated to when the backup was created
+func (s *Server) importHABackup(settings *types.AppSettings, backup *io.HABackup, job *Job) error {
+	slog.Info("Importing Home Assistant backup", "file", backup.Path, "name", backup.Name, "date", backup.Date)
+
+	configDir, err := os.MkdirTemp("", "ha-config-history-import-")
+	if err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", backup.Path, err)
+	}
+	defer os.RemoveAll(configDir)
+
+	configs := settings.Configs
+	if err := io.ExtractHABackupConfig(backup, configDir, func(relPath string) bool {
+		return importWanted(configs, relPath)
+	}); err != nil {
+		return err
+	}
+
+	for _, options := range configs {
+		configBackups, err := readArchivedConfigs(configDir, options, configs)
+		if err != nil {
+			job.addError(fmt.Errorf("failed to read %s from %s: %w", options.Path, filepath.Base(backup.Path), err))
+			continue
+		}
+
+		for _, item := range configBackups {
+			if job.Cancelled() {
+				return nil
+			}
+			item.backup.ModifiedDate = backup.Date
+			job.itemQueued()
+			created, err := s.importVersion(settings, item.options, item.backup)
+			job.itemProcessed(created, err)
+		}
+	}
+	return nil
+}
+
+// archivedConfig is an item read from a backup archive, with the options of the config that owns it
+type archivedConfig struct {
+	options *types.ConfigBackupOptions
+	backup  *types.ConfigBackup
+}
+
+// readArchivedConfigs reads every item of a config from an extracted backup, following includes. Configs that
+// didn't exist when the backup was made have no items.
+func readArchivedConfigs(
+	rootPath string,
+	options *types.ConfigBackupOptions,
+	configs []*types.ConfigBackupOptions,
+) ([]archivedConfig, error) {
+	if _, err := os.Stat(filepath.Join(rootPath, options.Path)); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	var configBackups []*types.ConfigBackup
+	var err error
+	switch options.BackupType {
+	case types.BackupTypeMultipleName:
+		configBackups, err = io.ReadMultipleConfigsFromSingleFile(rootPath, options)
+	case types.BackupTypeMappingName:
+		configBackups, err = io.ReadKeyedConfigsFromSingleFile(rootPath, options)
+	case types.BackupTypeJsonName:
+		configBackups, err = io.ReadJsonConfigsFromSingleFile(rootPath, options)
+	case types.BackupTypeDirectoryName:
+		configBackups, err = io.ReadMultipleConfigsFromDirectory(rootPath, options)
+	case types.BackupTypeSingleName:
+		var configBackup *types.ConfigBackup
+		configBackup, err = io.ReadSingleConfigFromSingleFile(rootPath, options)
+		if errors.Is(err, io.ErrFileTooLarge) {
+			return nil, nil
+		}
+		configBackups = []*types.ConfigBackup{configBackup}
+	case types.BackupTypeIncludesName:
+		graph, err := io.ResolveIncludes(rootPath, options, configs)
+		if err != nil {
+			return nil, err
+		}
+		items := []archivedConfig{}
+		for _, derived := range graph.Configs {
+			derivedItems, err := readArchivedConfigs(rootPath, derived, configs)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, derivedItems...)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown backup type: %s", options.BackupType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]archivedConfig, 0, len(configBackups))
+	for _, configBackup := range configBackups {
+		items = append(items, archivedConfig{options: options, backup: configBackup})
+	}
+	return items, nil
+}
+
+// importWanted reports whether a file in a backup archive is tracked by one of the configs. Every YAML file is
+// extracted for includes configs, as included files can be anywhere in the config directory.
+func importWanted(configs []*types.ConfigBackupOptions, relPath string) bool {
+	for _, options := range configs {
+		if options.BackupType == types.BackupTypeIncludesName {
+			if ext := filepath.Ext(relPath); ext == ".yaml" || ext == ".yml" {
+				return true
+			}
+			continue
+		}
+		if relPath == options.Path || strings.HasPrefix(relPath, options.Path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// importVersion saves an archived item as a version, unless the nearest version at or before its date has the same
+// content, reporting whether a version was created. Versions older than the latest only update the backup count,
+// so the metadata keeps describing the latest version. The config is locked against the queue workers throughout, so
+// the versions compared against are current and metadata updates aren't lost.
+func (s *Server) importVersion(
+	settings *types.AppSettings,
+	options *types.ConfigBackupOptions,
+	configBackup *types.ConfigBackup,
+) (bool, error) {
+	defer s.configLocks.lock(configBackup.ConfigIdentifier)()
+
+	backupDir, err := io.GetBackupDirectory(settings.BackupDir, configBackup)
+	if err != nil {
+		return false, fmt.Errorf("failed to get backup directory for %s: %w", configBackup.ID, err)
+	}
+
+	versions, err := io.ListConfigBackups(settings.BackupDir, configBackup.Group, configBackup.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, version := range versions {
+		if version.Date.After(configBackup.ModifiedDate) {
+			continue
+		}
+
+		hash, err := io.HashFile(filepath.Join(backupDir, version.Filename))
+		if err != nil {
+			return false, err
+		}
+		if hash == configBackup.RawHash {
+			return false, nil
+		}
+		if version.Date.Equal(configBackup.ModifiedDate) {
+			return false, fmt.Errorf("a different version of %s/%s already exists at %s",
+				configBackup.Group, configBackup.ID, version.Filename)
+		}
+		break
+	}
+
+	if err := io.SaveConfigBackup(configBackup, backupDir); err != nil {
+		return false, fmt.Errorf("failed to save backup for %s: %w", configBackup.ID, err)
+	}
+	slog.Info("Imported version",
+		"group", configBackup.Group,
+		"id", configBackup.ID,
+		"date", configBackup.ModifiedDate,
+	)
+
+	latest := len(versions) == 0 || configBackup.ModifiedDate.After(versions[0].Date)
+
+	s.State.Mu.Lock()
+	defer s.State.Mu.Unlock()
+
+	var metadata *types.ConfigMetadata
+	cached, exists := s.State.CachedConfigMetadata[configBackup.ConfigIdentifier]
+	switch {
+	case exists && !latest:
+		updated := *cached
+		metadata = &updated
+	case exists:
+		metadata = types.NewConfigMetadata(configBackup, 0, 0, options.BackupType)
+		metadata.FormatOnlyChanges = cached.FormatOnlyChanges
+		metadata.LastFormatOnlyChange = cached.LastFormatOnlyChange
+		metadata.Deleted = cached.Deleted
+		metadata.Deletions = cached.Deletions
+	default:
+		metadata = types.NewConfigMetadata(configBackup, 0, 0, options.BackupType)
+	}
+	if err := io.RecountMetadata(backupDir, metadata); err != nil {
+		return true, fmt.Errorf("failed to update metadata for %s: %w", configBackup.ID, err)
+	}
+	if s.Settings().BackupDir == settings.BackupDir {
+		s.State.CachedConfigMetadata[configBackup.ConfigIdentifier] = metadata
+	}
+	return true, nil
+}
@@ -0,0 +1,231 @@
+package core_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestImportHABackups(t *testing.T) {
+	writeTar := func(t *testing.T, writer *tar.Writer, files map[string][]byte) {
+		for name, content := range files {
+			header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+			if err := writer.WriteHeader(header); err != nil {
+				t.Fatalf("Failed to write tar header: %v", err)
+			}
+			if _, err := writer.Write(content); err != nil {
+				t.Fatalf("Failed to write tar content: %v", err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("Failed to close tar: %v", err)
+		}
+	}
+
+	// writeHABackup writes a Home Assistant backup archive with the config files under data/ in homeassistant.tar.gz
+	writeHABackup := func(t *testing.T, dir, slug string, date time.Time, protected bool, files map[string]string) {
+		var config bytes.Buffer
+		gzipWriter := gzip.NewWriter(&config)
+		configFiles := map[string][]byte{}
+		for name, content := range files {
+			configFiles["data/"+name] = []byte(content)
+		}
+		writeTar(t, tar.NewWriter(gzipWriter), configFiles)
+		if err := gzipWriter.Close(); err != nil {
+			t.Fatalf("Failed to close gzip: %v", err)
+		}
+
+		backupJSON, _ := json.Marshal(map[string]any{
+			"slug":      slug,
+			"name":      "Backup " + slug,
+			"date":      date.Format("2006-01-02T15:04:05.000000-07:00"),
+			"type":      "partial",
+			"protected": protected,
+		})
+
+		file, err := os.Create(filepath.Join(dir, slug+".tar"))
+		if err != nil {
+			t.Fatalf("Failed to create archive: %v", err)
+		}
+		defer file.Close()
+		writeTar(t, tar.NewWriter(file), map[string][]byte{
+			"./backup.json":          backupJSON,
+			"./homeassistant.tar.gz": config.Bytes(),
+		})
+	}
+
+	newServer := func(t *testing.T) *core.Server {
+		return core.NewServer(&types.AppSettings{
+			HomeAssistantConfigDir: t.TempDir(),
+			BackupDir:              t.TempDir(),
+			HomeAssistantBackupDir: t.TempDir(),
+			Configs: []*types.ConfigBackupOptions{
+				types.NewSingleConfigBackupOptions("Configuration", "configuration.yaml"),
+				types.NewMultipleConfigBackupOptions("Automations", "automations.yaml", "id", "alias"),
+				types.NewDirectoryConfigBackupOptions("ESP Home", "esphome", []string{"*.yaml"}, nil),
+			},
+		})
+	}
+
+	listFilenames := func(t *testing.T, server *core.Server, group, id string) []string {
+		backups, err := io.ListConfigBackups(server.Settings().BackupDir, group, id)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		filenames := []string{}
+		for _, backup := range backups {
+			filenames = append(filenames, backup.Filename)
+		}
+		return filenames
+	}
+
+	first := time.Date(2024, 1, 1, 3, 0, 0, 123456000, time.UTC)
+	second := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	t.Run("Versions are imported per item, dated to the backup and de-duplicated", func(t *testing.T) {
+		server := newServer(t)
+		backupDir := server.Settings().HomeAssistantBackupDir
+		writeHABackup(t, backupDir, "second", second, false, map[string]string{
+			"configuration.yaml": "homeassistant:\n",
+			"automations.yaml":   "- id: one\n  alias: One updated\n- id: two\n  alias: Two\n",
+			"esphome/node.yaml":  "esphome:\n  name: node\n",
+		})
+		writeHABackup(t, backupDir, "first", first, false, map[string]string{
+			"configuration.yaml":     "homeassistant:\n",
+			"automations.yaml":       "- id: one\n  alias: One\n- id: two\n  alias: Two\n",
+			"home-assistant_v2.db":   "not tracked",
+			"custom_components/x.py": "not tracked",
+			"esphome/secrets.txt":    "not included",
+		})
+
+		job := server.ImportHABackups([]string{backupDir})
+		if job.Status != core.JobStatusCompleted || job.Kind != core.JobKindImport {
+			t.Fatalf("Expected completed import job, got: %s %s %v", job.Kind, job.Status, job.Errors)
+		}
+		if job.VersionsCreated != 5 {
+			t.Errorf("Expected 5 versions, got: %d", job.VersionsCreated)
+		}
+
+		expected := []string{"20240102T030000.backup", "20240101T030000.backup"}
+		if diff := cmp.Diff(expected, listFilenames(t, server, "automations.yaml", "one")); diff != "" {
+			t.Errorf("Versions do not match expected:\n%s", diff)
+		}
+		expected = []string{"20240101T030000.backup"}
+		if diff := cmp.Diff(expected, listFilenames(t, server, "automations.yaml", "two")); diff != "" {
+			t.Errorf("Versions do not match expected:\n%s", diff)
+		}
+		if diff := cmp.Diff(expected, listFilenames(t, server, "configuration.yaml", "configuration.yaml")); diff != "" {
+			t.Errorf("Versions do not match expected:\n%s", diff)
+		}
+		if _, err := os.Stat(filepath.Join(server.Settings().BackupDir, "home-assistant_v2.db")); err == nil {
+			t.Error("Expected untracked files not to be imported")
+		}
+
+		metadata := server.State.CachedConfigMetadata[types.ConfigIdentifier{Group: "automations.yaml", ID: "one"}]
+		if metadata == nil || metadata.FriendlyName != "One updated" || metadata.BackupCount != 2 {
+			t.Errorf("Expected metadata for the latest version, got: %+v", metadata)
+		}
+
+		job = server.ImportHABackups([]string{backupDir})
+		if job.Status != core.JobStatusCompleted || job.VersionsCreated != 0 {
+			t.Errorf("Expected importing again to create no versions, got: %s %d", job.Status, job.VersionsCreated)
+		}
+	})
+
+	t.Run("Older backups are imported before existing versions", func(t *testing.T) {
+		server := newServer(t)
+		backupDir := server.Settings().HomeAssistantBackupDir
+		writeHABackup(t, backupDir, "second", second, false, map[string]string{
+			"automations.yaml": "- id: one\n  alias: Latest\n",
+		})
+		server.ImportHABackups([]string{filepath.Join(backupDir, "second.tar")})
+
+		writeHABackup(t, backupDir, "first", first, false, map[string]string{
+			"automations.yaml": "- id: one\n  alias: Oldest\n",
+		})
+		job := server.ImportHABackups([]string{filepath.Join(backupDir, "first.tar")})
+		if job.VersionsCreated != 1 {
+			t.Fatalf("Expected 1 version, got: %d %v", job.VersionsCreated, job.Errors)
+		}
+
+		metadata := server.State.CachedConfigMetadata[types.ConfigIdentifier{Group: "automations.yaml", ID: "one"}]
+		if metadata.FriendlyName != "Latest" || metadata.BackupCount != 2 {
+			t.Errorf("Expected metadata to keep describing the latest version, got: %+v", metadata)
+		}
+	})
+
+	t.Run("Imports and backups of the same config don't lose metadata updates", func(t *testing.T) {
+		server := newServer(t)
+		settings := server.Settings()
+		var live, archived strings.Builder
+		for i := 0; i < 50; i++ {
+			fmt.Fprintf(&live, "- id: \"%d\"\n  alias: Live\n", i)
+			fmt.Fprintf(&archived, "- id: \"%d\"\n  alias: Archived\n", i)
+		}
+		for name, content := range map[string]string{
+			"automations.yaml":   live.String(),
+			"configuration.yaml": "homeassistant:\n",
+			"esphome/node.yaml":  "esphome:\n",
+		} {
+			path := filepath.Join(settings.HomeAssistantConfigDir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("Failed to create directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+		writeHABackup(t, settings.HomeAssistantBackupDir, "first", first, false, map[string]string{
+			"automations.yaml": archived.String(),
+		})
+
+		server.Start()
+		t.Cleanup(server.Shutdown)
+		imported := server.StartImportJob([]string{settings.HomeAssistantBackupDir})
+		scanned := server.StartBackupJob(core.JobKindManual, true)
+		imported.Wait()
+		scanned.Wait()
+		for _, job := range []*core.Job{imported, scanned} {
+			if info := job.Info(); info.Status != core.JobStatusCompleted {
+				t.Fatalf("Expected completed %s job, got: %s %v", info.Kind, info.Status, info.Errors)
+			}
+		}
+
+		for i := 0; i < 50; i++ {
+			identifier := types.ConfigIdentifier{Group: "automations.yaml", ID: fmt.Sprint(i)}
+			filenames := listFilenames(t, server, identifier.Group, identifier.ID)
+			server.State.Mu.RLock()
+			metadata := *server.State.CachedConfigMetadata[identifier]
+			server.State.Mu.RUnlock()
+			if len(filenames) != 2 || metadata.BackupCount != 2 || metadata.FriendlyName != "Live" {
+				t.Fatalf("Expected the archived and live versions of %s, got: %v %+v", identifier.ID, filenames, metadata)
+			}
+		}
+	})
+
+	t.Run("Protected backups are reported", func(t *testing.T) {
+		server := newServer(t)
+		backupDir := server.Settings().HomeAssistantBackupDir
+		writeHABackup(t, backupDir, "protected", first, true, map[string]string{
+			"configuration.yaml": "homeassistant:\n",
+		})
+
+		job := server.ImportHABackups([]string{backupDir})
+		if job.Status != core.JobStatusFailed || len(job.Errors) != 1 ||
+			!strings.Contains(job.Errors[0], "password protected") {
+			t.Errorf("Expected protected backup error, got: %s %v", job.Status, job.Errors)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"log/slog"
+	"slices"
+)
+
+// processIncludes resolves the include graph for an includes config and processes every referenced file.
+// Files that are no longer referenced stop being watched.
+func (s *Server) processIncludes(settings *types.AppSettings, options *types.ConfigBackupOptions, job *Job) {
+	graph, err := io.ResolveIncludes(settings.HomeAssistantConfigDir, options, settings.Configs)
+	if err != nil {
+		slog.Error("Error resolving includes", "path", options.Path, "error", err)
+		job.addError(err)
+		return
+	}
+
+	slog.Info("Processing backups for included configs",
+		"root", options.Path,
+		"files", len(graph.Files),
+		"configs", len(graph.Configs),
+	)
+
+	files := make(map[string]struct{}, len(graph.Files))
+	for _, file := range graph.Files {
+		files[settings.HomeAssistantConfigDir+"/"+file] = struct{}{}
+	}
+
+	s.State.Mu.Lock()
+	if previous, exists := s.State.IncludeGraphs[options.Path]; exists {
+		for _, file := range previous.Files {
+			path := settings.HomeAssistantConfigDir + "/" + file
+			if _, stillIncluded := files[path]; !stillIncluded {
+				delete(s.State.IncludeRoots, path)
+				if lookup, tracked := s.State.FileLookup[path]; tracked && slices.Contains(previous.Configs, lookup) {
+					delete(s.State.FileLookup, path)
+				}
+			}
+		}
+	}
+	s.State.IncludeGraphs[options.Path] = graph
+	for path := range files {
+		s.State.IncludeRoots[path] = options
+	}
+	s.State.Mu.Unlock()
+
+	for _, derived := range graph.Configs {
+		s.processConfigOptions(settings, derived, job)
+	}
+}
+
+// LoadIncludeGraphs resolves the include graph of every includes config without processing or watching the files,
+// so FindConfigOptions can find included configs outside the server (eg. the command line)
+func (s *Server) LoadIncludeGraphs() {
+	settings := s.Settings()
+	for _, options := range settings.Configs {
+		if options.BackupType != "includes" {
+			continue
+		}
+
+		graph, err := io.ResolveIncludes(settings.HomeAssistantConfigDir, options, settings.Configs)
+		if err != nil {
+			slog.Error("Error resolving includes", "path", options.Path, "error", err)
+			continue
+		}
+
+		s.State.Mu.Lock()
+		s.State.IncludeGraphs[options.Path] = graph
+		s.State.Mu.Unlock()
+	}
+}
+
+// FindConfigOptions returns the backup options that own a group, including files tracked through an includes config
+func (s *Server) FindConfigOptions(group string) *types.ConfigBackupOptions {
+	for _, options := range s.Settings().Configs {
+		if options.Path == group && options.BackupType != "includes" {
+			return options
+		}
+	}
+
+	s.State.Mu.RLock()
+	defer s.State.Mu.RUnlock()
+	for _, graph := range s.State.IncludeGraphs {
+		for _, options := range graph.Configs {
+			if options.Path == group {
+				return options
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package core
+
+import (
+	"fmt"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job kinds, describing what started a backup job
+const (
+	JobKindManual    = "manual"
+	JobKindScheduled = "scheduled"
+	JobKindStartup   = "startup"
+	JobKindResume    = "resume"
+	JobKindSettings  = "settings"
+	JobKindImport    = "import"
+)
+
+// Job statuses
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+// maxJobHistory is the number of finished jobs kept for the job status API
+const maxJobHistory = 50
+
+// JobInfo is a point in time view of a backup job, as returned by the job status API
+type JobInfo struct {
+	ID              string     `json:"id"`
+	Kind            string     `json:"kind"`
+	Status          string     `json:"status"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	StartedAt       *time.Time `json:"startedAt,omitempty"`
+	FinishedAt      *time.Time `json:"finishedAt,omitempty"`
+	ItemsQueued     int        `json:"itemsQueued"`
+	ItemsProcessed  int        `json:"itemsProcessed"`
+	FilesSkipped    int        `json:"filesSkipped"`
+	FullRehash      bool       `json:"fullRehash"`
+	VersionsCreated int        `json:"versionsCreated"`
+	Errors          []string   `json:"errors"`
+}
+
+// Job tracks a single run over all configs. Items are counted as they are queued, and the job finishes once
+// every config has been read and every queued item has been processed. Methods are safe to call on a nil job,
+// which is used for backups triggered by the file watcher.
+type Job struct {
+	mu          sync.Mutex
+	info        JobInfo
+	enqueueDone bool
+	done        chan struct{}
+	fullRehash  bool
+	// stats are the files read by this job, saved to the stat cache only if the job completes without errors
+	stats      map[string]io.StatCacheEntry
+	onComplete func(stats map[string]io.StatCacheEntry)
+	// statCache is the stat cache of the backup directory the job started with
+	statCache *io.StatCache
+}
+
+func newJob(kind string, fullRehash bool) *Job {
+	return &Job{
+		info: JobInfo{
+			ID:         uuid.New().String(),
+			Kind:       kind,
+			Status:     JobStatusQueued,
+			CreatedAt:  time.Now().UTC(),
+			FullRehash: fullRehash,
+			Errors:     []string{},
+		},
+		done:       make(chan struct{}),
+		fullRehash: fullRehash,
+		stats:      map[string]io.StatCacheEntry{},
+	}
+}
+
+// Info returns a copy of the job's current state
+func (j *Job) Info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := j.info
+	info.Errors = append([]string{}, j.info.Errors...)
+	return info
+}
+
+// Wait blocks until the job has completed, failed or been cancelled
+func (j *Job) Wait() {
+	if j == nil {
+		return
+	}
+	<-j.done
+}
+
+// Cancelled reports whether the job was cancelled, in which case remaining items are skipped
+func (j *Job) Cancelled() bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info.Status == JobStatusCancelled
+}
+
+func (j *Job) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.info.Status != JobStatusQueued {
+		return
+	}
+	now := time.Now().UTC()
+	j.info.Status = JobStatusRunning
+	j.info.StartedAt = &now
+}
+
+func (j *Job) cancel() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.isFinished() {
+		return false
+	}
+	j.finish(JobStatusCancelled)
+	return true
+}
+
+func (j *Job) itemQueued() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.info.ItemsQueued++
+}
+
+func (j *Job) itemProcessed(created bool, err error) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.info.ItemsProcessed++
+	if created {
+		j.info.VersionsCreated++
+	}
+	if err != nil {
+		j.info.Errors = append(j.info.Errors, err.Error())
+	}
+	j.completeIfDone()
+}
+
+func (j *Job) fileSkipped() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.info.FilesSkipped++
+}
+
+func (j *Job) stageStat(path string, entry io.StatCacheEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stats[path] = entry
+}
+
+func (j *Job) addError(err error) {
+	if j == nil || err == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.info.Errors = append(j.info.Errors, err.Error())
+}
+
+// finishEnqueue marks that every config has been read, so the job completes once the queue catches up
+func (j *Job) finishEnqueue() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.enqueueDone = true
+	j.completeIfDone()
+}
+
+// completeIfDone must be called with the lock held
+func (j *Job) completeIfDone() {
+	if j.isFinished() || !j.enqueueDone || j.info.ItemsProcessed < j.info.ItemsQueued {
+		return
+	}
+	if len(j.info.Errors) > 0 {
+		j.finish(JobStatusFailed)
+		return
+	}
+	if j.onComplete != nil {
+		j.onComplete(j.stats)
+	}
+	j.finish(JobStatusCompleted)
+}
+
+// finish must be called with the lock held
+func (j *Job) finish(status string) {
+	now := time.Now().UTC()
+	j.info.Status = status
+	j.info.FinishedAt = &now
+	close(j.done)
+}
+
+// isFinished must be called with the lock held
+func (j *Job) isFinished() bool {
+	switch j.info.Status {
+	case JobStatusCompleted, JobStatusFailed, JobStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// JobManager keeps track of recent backup jobs, newest last
+type JobManager struct {
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+func NewJobManager() *JobManager {
+	return &JobManager{jobs: []*Job{}}
+}
+
+func (m *JobManager) add(job *Job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs = append(m.jobs, job)
+	if len(m.jobs) > maxJobHistory {
+		m.jobs = m.jobs[len(m.jobs)-maxJobHistory:]
+	}
+}
+
+func (m *JobManager) get(id string) *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range m.jobs {
+		if job.info.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// cancelUnfinished cancels every job that hasn't finished, returning how many were cancelled
+func (m *JobManager) cancelUnfinished() int {
+	m.mu.Lock()
+	jobs := append([]*Job{}, m.jobs...)
+	m.mu.Unlock()
+
+	cancelled := 0
+	for _, job := range jobs {
+		if job.cancel() {
+			cancelled++
+		}
+	}
+	return cancelled
+}
+
+func (m *JobManager) list() []JobInfo {
+	m.mu.Lock()
+	jobs := append([]*Job{}, m.jobs...)
+	m.mu.Unlock()
+
+	infos := make([]JobInfo, 0, len(jobs))
+	for i := len(jobs) - 1; i >= 0; i-- {
+		infos = append(infos, jobs[i].Info())
+	}
+	return infos
+}
+
+// StartBackupJob reads every config in the background, queueing changed items for backup, and returns immediately.
+// Files that match the stat cache are skipped unless fullRehash is set.
+func (s *Server) StartBackupJob(kind string, fullRehash bool) *Job {
+	settings := s.Settings()
+	return s.startBackupJob(kind, fullRehash, settings, settings.Configs)
+}
+
+// startBackupJob processes configs in the background with one snapshot of the settings and stat cache, so settings
+// applied while it runs don't change the directories it reads from and writes to
+func (s *Server) startBackupJob(
+	kind string,
+	fullRehash bool,
+	settings *types.AppSettings,
+	configs []*types.ConfigBackupOptions,
+) *Job {
+	job := newJob(kind, fullRehash)
+	job.statCache = s.statCache.Load()
+	job.onComplete = func(stats map[string]io.StatCacheEntry) {
+		updateStatCache(job.statCache, stats)
+	}
+	s.jobs.add(job)
+
+	go func() {
+		job.start()
+		for _, options := range configs {
+			if job.Cancelled() {
+				break
+			}
+			s.processConfigOptions(settings, options, job)
+		}
+		job.finishEnqueue()
+	}()
+
+	return job
+}
+
+// Jobs returns recent backup jobs, newest first
+func (s *Server) Jobs() []JobInfo {
+	return s.jobs.list()
+}
+
+func (s *Server) GetJob(id string) (JobInfo, bool) {
+	job := s.jobs.get(id)
+	if job == nil {
+		return JobInfo{}, false
+	}
+	return job.Info(), true
+}
+
+// CancelJob stops a job from queueing or processing any more items. Items already processed are kept.
+func (s *Server) CancelJob(id string) (JobInfo, error) {
+	job := s.jobs.get(id)
+	if job == nil {
+		return JobInfo{}, fmt.Errorf("job not found: %s", id)
+	}
+	if !job.cancel() {
+		return job.Info(), fmt.Errorf("job %s has already finished", id)
+	}
+	return job.Info(), nil
+}
@@ -0,0 +1,147 @@
+package core_test
+
+import (
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupJobs(t *testing.T) {
+	newServer := func(t *testing.T) (*core.Server, string) {
+		configDir := t.TempDir()
+		automations := []byte("- id: one\n  alias: One\n- id: two\n  alias: Two\n")
+		if err := os.WriteFile(filepath.Join(configDir, "automations.yaml"), automations, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		server := core.NewServer(&types.AppSettings{
+			HomeAssistantConfigDir: configDir,
+			BackupDir:              t.TempDir(),
+			Configs: []*types.ConfigBackupOptions{
+				types.NewMultipleConfigBackupOptions("Automations", "automations.yaml", "id", "alias"),
+			},
+		})
+		server.Start()
+		t.Cleanup(server.Shutdown)
+		return server, configDir
+	}
+
+	waitForJobs := func(t *testing.T, server *core.Server) []core.JobInfo {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			jobs := server.Jobs()
+			finished := true
+			for _, job := range jobs {
+				if job.Status == core.JobStatusQueued || job.Status == core.JobStatusRunning {
+					finished = false
+				}
+			}
+			if finished {
+				return jobs
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("Timed out waiting for jobs to finish")
+		return nil
+	}
+
+	t.Run("Startup scan is reported as a job", func(t *testing.T) {
+		server, _ := newServer(t)
+
+		jobs := waitForJobs(t, server)
+		if len(jobs) != 1 {
+			t.Fatalf("Expected 1 job, got: %d", len(jobs))
+		}
+		if jobs[0].Kind != core.JobKindStartup || jobs[0].Status != core.JobStatusCompleted {
+			t.Fatalf("Expected completed startup job, got: %s %s", jobs[0].Kind, jobs[0].Status)
+		}
+		if jobs[0].ItemsProcessed != 2 || jobs[0].VersionsCreated != 2 {
+			t.Fatalf("Expected 2 items and 2 versions, got: %d items, %d versions",
+				jobs[0].ItemsProcessed, jobs[0].VersionsCreated)
+		}
+	})
+
+	t.Run("Manual jobs only count new versions", func(t *testing.T) {
+		server, configDir := newServer(t)
+		waitForJobs(t, server)
+
+		unchanged := server.ProcessAllConfigOptions(false)
+		if unchanged.FilesSkipped != 1 || unchanged.ItemsProcessed != 0 || unchanged.VersionsCreated != 0 {
+			t.Fatalf("Expected unchanged file to be skipped, got: %d skipped, %d items, %d versions",
+				unchanged.FilesSkipped, unchanged.ItemsProcessed, unchanged.VersionsCreated)
+		}
+
+		rehashed := server.ProcessAllConfigOptions(true)
+		if rehashed.FilesSkipped != 0 || rehashed.ItemsProcessed != 2 || rehashed.VersionsCreated != 0 {
+			t.Fatalf("Expected full rehash to read every item, got: %d skipped, %d items, %d versions",
+				rehashed.FilesSkipped, rehashed.ItemsProcessed, rehashed.VersionsCreated)
+		}
+
+		automations := []byte("- id: one\n  alias: One\n- id: two\n  alias: Changed\n")
+		if err := os.WriteFile(filepath.Join(configDir, "automations.yaml"), automations, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		changed := server.ProcessAllConfigOptions(false)
+		if changed.VersionsCreated != 1 {
+			t.Fatalf("Expected 1 version, got: %d", changed.VersionsCreated)
+		}
+
+		job, exists := server.GetJob(changed.ID)
+		if !exists || job.Status != core.JobStatusCompleted {
+			t.Fatalf("Expected completed job to be found, got: %v", job)
+		}
+	})
+
+	t.Run("Stat cache is reloaded on restart", func(t *testing.T) {
+		server, _ := newServer(t)
+		waitForJobs(t, server)
+		server.Shutdown()
+
+		restarted := core.NewServer(server.Settings())
+		restarted.Start()
+		t.Cleanup(restarted.Shutdown)
+
+		jobs := waitForJobs(t, restarted)
+		if jobs[0].FilesSkipped != 1 || jobs[0].ItemsProcessed != 0 {
+			t.Fatalf("Expected startup scan to skip the unchanged file, got: %d skipped, %d items",
+				jobs[0].FilesSkipped, jobs[0].ItemsProcessed)
+		}
+
+		restarted.InvalidateStatCache("automations.yaml")
+		invalidated := restarted.ProcessAllConfigOptions(false)
+		if invalidated.FilesSkipped != 0 || invalidated.ItemsProcessed != 2 {
+			t.Fatalf("Expected invalidated file to be read, got: %d skipped, %d items",
+				invalidated.FilesSkipped, invalidated.ItemsProcessed)
+		}
+	})
+
+	t.Run("Read errors fail the job", func(t *testing.T) {
+		server, configDir := newServer(t)
+		waitForJobs(t, server)
+
+		if err := os.Remove(filepath.Join(configDir, "automations.yaml")); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+
+		job := server.ProcessAllConfigOptions(false)
+		if job.Status != core.JobStatusFailed || len(job.Errors) != 1 {
+			t.Fatalf("Expected failed job with 1 error, got: %s %v", job.Status, job.Errors)
+		}
+	})
+
+	t.Run("Finished jobs can't be cancelled", func(t *testing.T) {
+		server, _ := newServer(t)
+		jobs := waitForJobs(t, server)
+
+		if _, err := server.CancelJob(jobs[0].ID); err == nil {
+			t.Fatal("Expected error cancelling a finished job")
+		}
+		if _, err := server.CancelJob("missing"); err == nil {
+			t.Fatal("Expected error cancelling a missing job")
+		}
+	})
+}
@@ -0,0 +1,528 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hexops/gotextdiff"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrIgnoreKeyOrderUnsupported is returned when ignoring key order and a version isn't valid YAML or JSON
+var ErrIgnoreKeyOrderUnsupported = errors.New("backups can't be compared ignoring key order")
+
+// maxMyersTrace limits the memory used by the Myers algorithm, whose trace grows with the square of the number of
+// differences. When it's reached the diff is finished coarsely, as when the time budget runs out.
+const maxMyersTrace = 1 << 22
+
+// maxHistogramOccurrences is how often a line can appear and still be used to line up the files, as in git's
+// histogram diff
+const maxHistogramOccurrences = 64
+
+// lineMatch pairs a line of the old file with the same line in the new file
+type lineMatch struct {
+	old, new int
+}
+
+// lineOp is a line of an edit script, with the number of old and new lines before it
+type lineOp struct {
+	kind     gotextdiff.OpKind
+	oldIndex int
+	newIndex int
+	content  string
+	// ignorable changes don't make a hunk on their own, eg. comment lines when ignoring comments
+	ignorable bool
+}
+
+// diffLines compares two texts line by line, returning a unified diff and whether the budget ran out, so that part
+// of the diff is coarser than it could be
+func diffLines(leftLabel, rightLabel, oldText, newText string, options DiffOptions) (gotextdiff.Unified, bool) {
+	oldLines, newLines := splitLines(oldText), splitLines(newText)
+	keys := map[string]int{}
+	oldKeys, oldIgnorable := lineKeys(oldLines, keys, options)
+	newKeys, newIgnorable := lineKeys(newLines, keys, options)
+
+	differ := &lineDiffer{algorithm: options.Algorithm, deadline: time.Now().Add(options.Timeout)}
+	var matches []lineMatch
+	differ.diff(oldKeys, newKeys, 0, 0, &matches)
+
+	ops := editScript(oldLines, newLines, matches, oldIgnorable, newIgnorable)
+	return gotextdiff.Unified{
+		From:  leftLabel,
+		To:    rightLabel,
+		Hunks: groupHunks(ops, *options.Context),
+	}, differ.approximate
+}
+
+// splitLines splits text into lines, keeping their line endings
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lineKeys numbers lines so that lines which compare as equal have the same number, returning the numbers and
+// which lines are ignorable
+func lineKeys(lines []string, keys map[string]int, options DiffOptions) ([]int, []bool) {
+	numbers := make([]int, len(lines))
+	ignorable := make([]bool, len(lines))
+	for i, line := range lines {
+		key, newline := strings.CutSuffix(line, "\n")
+		if options.IgnoreComments {
+			stripped := strings.TrimRightFunc(stripYAMLComment(key), unicode.IsSpace)
+			ignorable[i] = stripped == "" && strings.TrimSpace(key) != ""
+			key = stripped
+		}
+		if options.IgnoreWhitespace {
+			key = collapseWhitespace(key)
+		} else if newline {
+			key += "\n"
+		}
+
+		number, exists := keys[key]
+		if !exists {
+			number = len(keys)
+			keys[key] = number
+		}
+		numbers[i] = number
+	}
+	return numbers, ignorable
+}
+
+// stripYAMLComment removes a comment from a line: a # at the start of the line or after whitespace, outside quoted
+// strings. Lines of block scalars are treated like any other line.
+func stripYAMLComment(line string) string {
+	var quote rune
+	previous := ' '
+	for i, char := range line {
+		switch {
+		case quote == '"' && char == '\\' && previous == '\\':
+			// An escaped backslash doesn't escape the next character
+			char = 0
+		case quote == '"' && char == '"' && previous != '\\', quote == '\'' && char == '\'':
+			quote = 0
+		case quote == 0 && char == '\'' && previous == '\'':
+			// An escaped single quote ('') closed the string, so reopen it
+			quote = char
+		case quote == 0 && (char == '"' || char == '\'') &&
+			(unicode.IsSpace(previous) || strings.ContainsRune("[{,", previous)):
+			// Quotes only start a string at the start of a value, not within text like "Bob's lights"
+			quote = char
+		case quote == 0 && char == '#' && unicode.IsSpace(previous):
+			return line[:i]
+		}
+		previous = char
+	}
+	return line
+}
+
+// collapseWhitespace replaces runs of whitespace with a single space and removes trailing whitespace
+func collapseWhitespace(line string) string {
+	var builder strings.Builder
+	space := false
+	for _, char := range line {
+		if unicode.IsSpace(char) {
+			space = true
+			continue
+		}
+		if space {
+			builder.WriteByte(' ')
+			space = false
+		}
+		builder.WriteRune(char)
+	}
+	return builder.String()
+}
+
+// editScript converts matching lines to the lines of each file, in order, marking each as unchanged, removed or
+// added. Unchanged lines have their new content, which can differ from the old when ignoring whitespace or comments.
+func editScript(oldLines, newLines []string, matches []lineMatch, oldIgnorable, newIgnorable []bool) []lineOp {
+	ops := make([]lineOp, 0, max(len(oldLines), len(newLines)))
+	oldIndex, newIndex := 0, 0
+	changes := func(oldEnd, newEnd int) {
+		for ; oldIndex < oldEnd; oldIndex++ {
+			ops = append(ops, lineOp{
+				kind:      gotextdiff.Delete,
+				oldIndex:  oldIndex,
+				newIndex:  newIndex,
+				content:   oldLines[oldIndex],
+				ignorable: oldIgnorable[oldIndex],
+			})
+		}
+		for ; newIndex < newEnd; newIndex++ {
+			ops = append(ops, lineOp{
+				kind:      gotextdiff.Insert,
+				oldIndex:  oldIndex,
+				newIndex:  newIndex,
+				content:   newLines[newIndex],
+				ignorable: newIgnorable[newIndex],
+			})
+		}
+	}
+
+	for _, match := range matches {
+		changes(match.old, match.new)
+		ops = append(ops, lineOp{
+			kind:     gotextdiff.Equal,
+			oldIndex: oldIndex,
+			newIndex: newIndex,
+			content:  newLines[newIndex],
+		})
+		oldIndex++
+		newIndex++
+	}
+	changes(len(oldLines), len(newLines))
+	return ops
+}
+
+// groupHunks groups changed lines into hunks with up to context unchanged lines around them, joining hunks whose
+// context would overlap. Runs of changes that are all ignorable don't make a hunk, but are shown within one.
+func groupHunks(ops []lineOp, context int) []*gotextdiff.Hunk {
+	hunks := []*gotextdiff.Hunk{}
+	var hunk *gotextdiff.Hunk
+	appendLines := func(ops []lineOp) {
+		for _, op := range ops {
+			hunk.Lines = append(hunk.Lines, gotextdiff.Line{Kind: op.kind, Content: op.content})
+		}
+	}
+
+	// changesEnd is the end of the last changes in the current hunk
+	changesEnd := 0
+	for start := 0; start < len(ops); {
+		if ops[start].kind == gotextdiff.Equal {
+			start++
+			continue
+		}
+		end, ignorable := start, true
+		for ; end < len(ops) && ops[end].kind != gotextdiff.Equal; end++ {
+			ignorable = ignorable && ops[end].ignorable
+		}
+		if ignorable {
+			start = end
+			continue
+		}
+
+		if hunk != nil && start-changesEnd <= 2*context {
+			appendLines(ops[changesEnd:start])
+		} else {
+			if hunk != nil {
+				appendLines(ops[changesEnd:min(changesEnd+context, len(ops))])
+				hunks = append(hunks, hunk)
+			}
+			from := max(start-context, 0)
+			hunk = &gotextdiff.Hunk{FromLine: ops[from].oldIndex + 1, ToLine: ops[from].newIndex + 1}
+			appendLines(ops[from:start])
+		}
+		appendLines(ops[start:end])
+		changesEnd, start = end, end
+	}
+	if hunk != nil {
+		appendLines(ops[changesEnd:min(changesEnd+context, len(ops))])
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+// lineDiffer finds the matching lines of two files, as numbered by lineKeys, within a time budget
+type lineDiffer struct {
+	algorithm string
+	deadline  time.Time
+	// approximate is set once the budget has run out, after which lines are only matched coarsely
+	approximate bool
+}
+
+// diff appends the matching lines of a and b, which start at aStart and bStart in their files, to matches
+func (d *lineDiffer) diff(a, b []int, aStart, bStart int, matches *[]lineMatch) {
+	prefix := 0
+	for ; prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix]; prefix++ {
+		*matches = append(*matches, lineMatch{aStart + prefix, bStart + prefix})
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(middleA) > 0 && len(middleB) > 0 {
+		switch {
+		case d.approximate || time.Now().After(d.deadline):
+			d.coarse(middleA, middleB, aStart+prefix, bStart+prefix, matches)
+		case d.algorithm == DiffAlgorithmPatience:
+			d.patience(middleA, middleB, aStart+prefix, bStart+prefix, matches)
+		case d.algorithm == DiffAlgorithmHistogram:
+			d.histogram(middleA, middleB, aStart+prefix, bStart+prefix, matches)
+		default:
+			d.myers(middleA, middleB, aStart+prefix, bStart+prefix, matches)
+		}
+	}
+
+	for i := suffix; i > 0; i-- {
+		*matches = append(*matches, lineMatch{aStart + len(a) - i, bStart + len(b) - i})
+	}
+}
+
+// coarse only matches lines that appear once in both a and b, showing the lines between them as replaced. It's used
+// once the budget has run out, as it takes little time or memory however different the files are.
+func (d *lineDiffer) coarse(a, b []int, aStart, bStart int, matches *[]lineMatch) {
+	d.approximate = true
+	for _, match := range uniqueMatches(a, b) {
+		*matches = append(*matches, lineMatch{aStart + match.old, bStart + match.new})
+	}
+}
+
+// myers finds the fewest lines to remove and add, preferring to remove lines first
+func (d *lineDiffer) myers(a, b []int, aStart, bStart int, matches *[]lineMatch) {
+	n, m := len(a), len(b)
+	offset := n + m
+	// v[offset+k] is the furthest x reached on diagonal k, where y = x - k
+	v := make([]int, 2*offset+2)
+	// trace[e] is v[offset-e:offset+e+1] after e changes
+	var trace [][]int
+	traceSize := 0
+
+	for e := 0; e <= n+m; e++ {
+		if traceSize > maxMyersTrace || time.Now().After(d.deadline) {
+			d.coarse(a, b, aStart, bStart, matches)
+			return
+		}
+		for k := -e; k <= e; k += 2 {
+			var x int
+			if k == -e || (k != e && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x == n && y == m {
+				trace = append(trace, slices.Clone(v[offset-e:offset+e+1]))
+				*matches = append(*matches, myersMatches(trace, n, m, aStart, bStart)...)
+				return
+			}
+		}
+		trace = append(trace, slices.Clone(v[offset-e:offset+e+1]))
+		traceSize += 2*e + 1
+	}
+}
+
+// myersMatches follows the trace of the Myers algorithm back from the end of both files, returning the matching
+// lines in order
+func myersMatches(trace [][]int, n, m, aStart, bStart int) []lineMatch {
+	var matches []lineMatch
+	x, y := n, m
+	for e := len(trace) - 1; e > 0; e-- {
+		previous := trace[e-1]
+		k := x - y
+		previousK := k - 1
+		if k == -e || (k != e && previous[k-1+e-1] < previous[k+1+e-1]) {
+			previousK = k + 1
+		}
+		previousX := previous[previousK+e-1]
+		previousY := previousX - previousK
+		for x > previousX && y > previousY {
+			x--
+			y--
+			matches = append(matches, lineMatch{aStart + x, bStart + y})
+		}
+		x, y = previousX, previousY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		matches = append(matches, lineMatch{aStart + x, bStart + y})
+	}
+
+	slices.Reverse(matches)
+	return matches
+}
+
+// patience lines up the lines that appear once in both a and b, then compares the lines between them the same way,
+// falling back to Myers where there are no unique lines
+func (d *lineDiffer) patience(a, b []int, aStart, bStart int, matches *[]lineMatch) {
+	anchors := uniqueMatches(a, b)
+	if len(anchors) == 0 {
+		d.myers(a, b, aStart, bStart, matches)
+		return
+	}
+
+	oldIndex, newIndex := 0, 0
+	for _, anchor := range anchors {
+		d.diff(a[oldIndex:anchor.old], b[newIndex:anchor.new], aStart+oldIndex, bStart+newIndex, matches)
+		*matches = append(*matches, lineMatch{aStart + anchor.old, bStart + anchor.new})
+		oldIndex, newIndex = anchor.old+1, anchor.new+1
+	}
+	d.diff(a[oldIndex:], b[newIndex:], aStart+oldIndex, bStart+newIndex, matches)
+}
+
+// uniqueMatches returns the longest sequence of lines, in the same order in both, that appear once in a and once
+// in b
+func uniqueMatches(a, b []int) []lineMatch {
+	type occurrences struct {
+		oldCount, newCount int
+		old, new           int
+	}
+	lines := map[int]*occurrences{}
+	for i, line := range a {
+		if lines[line] == nil {
+			lines[line] = &occurrences{}
+		}
+		lines[line].oldCount++
+		lines[line].old = i
+	}
+	for j, line := range b {
+		if lines[line] != nil {
+			lines[line].newCount++
+			lines[line].new = j
+		}
+	}
+
+	var candidates []lineMatch
+	for _, line := range a {
+		if lines[line].oldCount == 1 && lines[line].newCount == 1 {
+			candidates = append(candidates, lineMatch{lines[line].old, lines[line].new})
+		}
+	}
+
+	// Patience sorting: tails[length-1] is the candidate ending the increasing sequence of that length with the
+	// lowest new line, and previous links each candidate to the one before it in its sequence
+	var tails []int
+	previous := make([]int, len(candidates))
+	for c, candidate := range candidates {
+		length := sort.Search(len(tails), func(i int) bool { return candidates[tails[i]].new > candidate.new })
+		previous[c] = -1
+		if length > 0 {
+			previous[c] = tails[length-1]
+		}
+		if length == len(tails) {
+			tails = append(tails, c)
+		} else {
+			tails[length] = c
+		}
+	}
+
+	sequence := make([]lineMatch, len(tails))
+	if len(tails) > 0 {
+		for i, c := len(tails)-1, tails[len(tails)-1]; i >= 0; i, c = i-1, previous[c] {
+			sequence[i] = candidates[c]
+		}
+	}
+	return sequence
+}
+
+// histogram lines up the longest run of matching lines containing the rarest line, then compares the lines before
+// and after it the same way, falling back to Myers where no line is rare enough
+func (d *lineDiffer) histogram(a, b []int, aStart, bStart int, matches *[]lineMatch) {
+	positions := map[int][]int{}
+	for i, line := range a {
+		positions[line] = append(positions[line], i)
+	}
+
+	bestOld, bestNew, bestLength, bestCount := 0, 0, 0, maxHistogramOccurrences
+	for j := 0; j < len(b); {
+		next := j + 1
+		if count := len(positions[b[j]]); count > 0 && count <= bestCount {
+			for _, i := range positions[b[j]] {
+				oldStart, newStart := i, j
+				for oldStart > 0 && newStart > 0 && a[oldStart-1] == b[newStart-1] {
+					oldStart--
+					newStart--
+				}
+				oldEnd, newEnd := i+1, j+1
+				for oldEnd < len(a) && newEnd < len(b) && a[oldEnd] == b[newEnd] {
+					oldEnd++
+					newEnd++
+				}
+
+				regionCount := count
+				for _, line := range a[oldStart:oldEnd] {
+					regionCount = min(regionCount, len(positions[line]))
+				}
+				if regionCount < bestCount || (regionCount == bestCount && oldEnd-oldStart > bestLength) {
+					bestOld, bestNew, bestLength, bestCount = oldStart, newStart, oldEnd-oldStart, regionCount
+				}
+				next = max(next, newEnd)
+			}
+		}
+		j = next
+	}
+
+	if bestLength == 0 {
+		d.myers(a, b, aStart, bStart, matches)
+		return
+	}
+
+	d.diff(a[:bestOld], b[:bestNew], aStart, bStart, matches)
+	for i := range bestLength {
+		*matches = append(*matches, lineMatch{aStart + bestOld + i, bStart + bestNew + i})
+	}
+	d.diff(a[bestOld+bestLength:], b[bestNew+bestLength:], aStart+bestOld+bestLength, bStart+bestNew+bestLength,
+		matches)
+}
+
+// sortKeys re-encodes a YAML or JSON document with its mapping keys sorted. JSON stays JSON, indented by two spaces.
+func sortKeys(content []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(content)) == 0 {
+		return content, nil
+	}
+
+	var buffer bytes.Buffer
+	if json.Valid(content) {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(&buffer)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(value); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	sortMappingKeys(&document)
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func sortMappingKeys(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		pairs := make([][2]*yaml.Node, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			pairs = append(pairs, [2]*yaml.Node{node.Content[i], node.Content[i+1]})
+		}
+		sort.SliceStable(pairs, func(i, j int) bool { return pairs[i][0].Value < pairs[j][0].Value })
+		for i, pair := range pairs {
+			node.Content[2*i], node.Content[2*i+1] = pair[0], pair[1]
+		}
+	}
+	for _, child := range node.Content {
+		sortMappingKeys(child)
+	}
+}
@@ -0,0 +1,243 @@
+package core_test
+
+import (
+	"errors"
+	"ha-config-history/internal/core"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffOptions(t *testing.T) {
+	diff := func(t *testing.T, left, right string, options core.DiffOptions) (*core.BackupDiff, error) {
+		backupDir := t.TempDir()
+		configDir := filepath.Join(backupDir, "configuration.yaml", "configuration.yaml")
+		if err := os.MkdirAll(configDir, 0755); err != nil {
+			t.Fatalf("Failed to create backup dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(configDir, "20240101T000000.backup"), []byte(left), 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(configDir, "20240102T000000.backup"), []byte(right), 0644); err != nil {
+			t.Fatalf("Failed to write backup: %v", err)
+		}
+
+		return core.DiffBackups(backupDir, "configuration.yaml", "configuration.yaml",
+			"20240101T000000.backup", "20240102T000000.backup", options)
+	}
+	lines := func(lines int) *int {
+		return &lines
+	}
+	mustDiff := func(t *testing.T, left, right string, options core.DiffOptions) *core.BackupDiff {
+		result, err := diff(t, left, right, options)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return result
+	}
+
+	t.Run("Ignore whitespace", func(t *testing.T) {
+		left := "light:\n  - platform: group\n    name: Kitchen\n"
+		right := "light:\n  -   platform: group  \n    name:  Kitchen\n"
+
+		if result := mustDiff(t, left, right, core.DiffOptions{}); result.UnifiedDiff == "" {
+			t.Error("Expected whitespace changes without IgnoreWhitespace")
+		}
+		if result := mustDiff(t, left, right, core.DiffOptions{IgnoreWhitespace: true}); result.UnifiedDiff != "" {
+			t.Errorf("Expected no changes, got:\n%s", result.UnifiedDiff)
+		}
+	})
+
+	t.Run("Ignore comments", func(t *testing.T) {
+		left := "# Lights\nlight:\n  - platform: group # old comment\n    name: Kitchen\n"
+		right := "light:\n  - platform: group # new comment\n    name: Kitchen\n    # entities: []\n"
+
+		result := mustDiff(t, left, right, core.DiffOptions{IgnoreComments: true})
+		if result.UnifiedDiff != "" {
+			t.Errorf("Expected no changes, got:\n%s", result.UnifiedDiff)
+		}
+
+		right = "light:\n  - platform: template # new comment\n    name: Kitchen\n"
+		result = mustDiff(t, left, right, core.DiffOptions{IgnoreComments: true})
+		expected := `--- 20240101T000000.backup
++++ 20240102T000000.backup
+@@ -1,4 +1,3 @@
+-# Lights
+ light:
+-  - platform: group # old comment
++  - platform: template # new comment
+     name: Kitchen
+`
+		if diff := cmp.Diff(expected, result.UnifiedDiff); diff != "" {
+			t.Errorf("Unified diff does not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Comment markers in strings are kept", func(t *testing.T) {
+		left := "name: 'Bob''s # lights'\ncolor: \"#ff0000\"\nicon: mdi:lamp # lamp\n"
+		right := "name: 'Bob''s # lamps'\ncolor: \"#00ff00\"\nicon: mdi:lamp\n"
+
+		result := mustDiff(t, left, right, core.DiffOptions{IgnoreComments: true})
+		var changed []string
+		for _, line := range result.Hunks[0].Lines {
+			if line.Kind == core.DiffLineAdded {
+				changed = append(changed, line.Content)
+			}
+		}
+		if diff := cmp.Diff([]string{"name: 'Bob''s # lamps'", `color: "#00ff00"`}, changed); diff != "" {
+			t.Errorf("Added lines do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Ignore key order", func(t *testing.T) {
+		left := "alias: Test\nmode: single\naction:\n  - service: light.turn_on\n    target: {entity_id: light.a}\n"
+		right := "action:\n  - target: {entity_id: light.a}\n    service: light.turn_on\nmode: single\nalias: Test\n"
+		if result := mustDiff(t, left, right, core.DiffOptions{IgnoreKeyOrder: true}); result.UnifiedDiff != "" {
+			t.Errorf("Expected no changes, got:\n%s", result.UnifiedDiff)
+		}
+
+		left, right = `{"version": 1, "data": {"b": 2, "a": 1}}`, `{"data": {"a": 1, "b": 3}, "version": 1}`
+		result := mustDiff(t, left, right, core.DiffOptions{IgnoreKeyOrder: true})
+		expected := `--- 20240101T000000.backup
++++ 20240102T000000.backup
+@@ -1,7 +1,7 @@
+ {
+   "data": {
+     "a": 1,
+-    "b": 2
++    "b": 3
+   },
+   "version": 1
+ }
+`
+		if diff := cmp.Diff(expected, result.UnifiedDiff); diff != "" {
+			t.Errorf("Unified diff does not match expected:\n%s", diff)
+		}
+
+		_, err := diff(t, "alias: Test\n", "alias: [unclosed\n", core.DiffOptions{IgnoreKeyOrder: true})
+		if !errors.Is(err, core.ErrIgnoreKeyOrderUnsupported) {
+			t.Errorf("Expected ErrIgnoreKeyOrderUnsupported, got: %v", err)
+		}
+	})
+
+	t.Run("Context lines", func(t *testing.T) {
+		left := "a: 1\nb: 2\nc: 3\nd: 4\ne: 5\nf: 6\ng: 7\n"
+		right := "a: 1\nb: 2\nc: 3\nd: 40\ne: 5\nf: 6\ng: 7\n"
+
+		for _, tt := range []struct {
+			context  *int
+			expected core.DiffHunk
+		}{
+			{nil, core.DiffHunk{OldStart: 1, OldLines: 7, NewStart: 1, NewLines: 7}},
+			{lines(1), core.DiffHunk{OldStart: 3, OldLines: 3, NewStart: 3, NewLines: 3}},
+			{lines(0), core.DiffHunk{OldStart: 4, OldLines: 1, NewStart: 4, NewLines: 1}},
+		} {
+			result := mustDiff(t, left, right, core.DiffOptions{Context: tt.context})
+			if len(result.Hunks) != 1 {
+				t.Fatalf("Expected 1 hunk, got: %d", len(result.Hunks))
+			}
+			hunk := result.Hunks[0]
+			hunk.Lines = nil
+			if diff := cmp.Diff(tt.expected, hunk); diff != "" {
+				t.Errorf("Hunk does not match expected:\n%s", diff)
+			}
+		}
+	})
+
+	t.Run("Algorithms", func(t *testing.T) {
+		left := `automation:
+  - alias: Morning
+    action:
+      - service: light.turn_on
+        target:
+          entity_id: light.kitchen
+  - alias: Evening
+    action:
+      - service: light.turn_on
+        target:
+          entity_id: light.lounge
+`
+		right := `automation:
+  - alias: Evening
+    action:
+      - service: light.turn_on
+        target:
+          entity_id: light.lounge
+  - alias: Morning
+    action:
+      - service: light.turn_on
+        target:
+          entity_id: light.kitchen
+`
+		// Myers matches the repeated lines of each automation with the other automation's, so the unique lines are
+		// shown as changed, while patience and histogram line up the evening automation and move the morning one below it
+		expected := map[string]string{
+			core.DiffAlgorithmMyers:     "-+--++-+",
+			core.DiffAlgorithmPatience:  "-----+++++",
+			core.DiffAlgorithmHistogram: "-----+++++",
+		}
+		for algorithm, expectedChanges := range expected {
+			result := mustDiff(t, left, right, core.DiffOptions{Algorithm: algorithm})
+			var changes strings.Builder
+			for _, line := range result.Hunks[0].Lines {
+				switch line.Kind {
+				case core.DiffLineRemoved:
+					changes.WriteByte('-')
+				case core.DiffLineAdded:
+					changes.WriteByte('+')
+				}
+			}
+			if changes.String() != expectedChanges {
+				t.Errorf("Expected %s changes %q, got: %q\n%s", algorithm, expectedChanges, changes.String(),
+					result.UnifiedDiff)
+			}
+		}
+	})
+
+	t.Run("Files larger than the max size are summarised", func(t *testing.T) {
+		result := mustDiff(t, "alias: Morning\n", "alias: Morning lights\n", core.DiffOptions{MaxSize: 16})
+		if !result.TooLarge || result.UnifiedDiff != "" || result.Summary != "too large to compare (15 B → 22 B)" {
+			t.Errorf("Expected a summary of files too large to compare, got: %+v", result)
+		}
+	})
+
+	t.Run("Diffs are finished coarsely when the time runs out", func(t *testing.T) {
+		left := "a: 1\nb: 2\nc: 3\nd: 4\n"
+		right := "a: 1\nb: 20\nc: 3\nd: 40\n"
+
+		result := mustDiff(t, left, right, core.DiffOptions{Timeout: time.Nanosecond})
+		if !result.Approximate {
+			t.Error("Expected the diff to be approximate")
+		}
+		expected := `--- 20240101T000000.backup
++++ 20240102T000000.backup
+@@ -1,4 +1,4 @@
+ a: 1
+-b: 2
++b: 20
+ c: 3
+-d: 4
++d: 40
+`
+		if diff := cmp.Diff(expected, result.UnifiedDiff); diff != "" {
+			t.Errorf("Unified diff does not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Invalid options", func(t *testing.T) {
+		for _, options := range []core.DiffOptions{
+			{Algorithm: "minimal"},
+			{Context: lines(-1)},
+			{MaxSize: core.MaxDiffMaxSize + 1},
+			{Timeout: time.Hour},
+		} {
+			if err := options.Validate(); err == nil {
+				t.Errorf("Expected an error for %+v", options)
+			}
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"os"
+	"path/filepath"
+)
+
+// LiveFilename is the filename shown for the live side of a comparison
+const LiveFilename = "live"
+
+var (
+	// ErrConfigNotFound is returned when no config tracks a group, or a single file config is given another id
+	ErrConfigNotFound = errors.New("config not found")
+	// ErrLiveConfigNotFound is returned when a config's file, or its item within the file, no longer exists
+	ErrLiveConfigNotFound = errors.New("config no longer exists in the Home Assistant config directory")
+)
+
+// ReadLiveConfig reads the current content of a config item from the Home Assistant config directory, the same way
+// it's read when backing up. A single file config has one item, whose id is the config's path.
+func (s *Server) ReadLiveConfig(group, id string) (*types.ConfigBackup, error) {
+	return s.readLiveConfig(group, id, nil)
+}
+
+// liveItems holds the items read from live config files by group and id, so comparing many items of a config reads
+// and parses its file once. Single file and directory configs read one file per item, so they aren't held.
+type liveItems map[string]map[string]*types.ConfigBackup
+
+// readLiveConfig reads a live config item like ReadLiveConfig, reading its file into live unless it's already there.
+// A nil live reads the file every time.
+func (s *Server) readLiveConfig(group, id string, live liveItems) (*types.ConfigBackup, error) {
+	if items, exists := live[group]; exists {
+		return liveItem(items, group, id)
+	}
+
+	options := s.FindConfigOptions(group)
+	if options == nil {
+		return nil, fmt.Errorf("%w: %s", ErrConfigNotFound, group)
+	}
+	if options.BackupType == types.BackupTypeSingleName && id != options.Path {
+		return nil, fmt.Errorf("%w: %s has no item %s", ErrConfigNotFound, options.Path, id)
+	}
+
+	rootPath := s.Settings().HomeAssistantConfigDir
+	if _, err := os.Stat(filepath.Join(rootPath, options.Path)); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrLiveConfigNotFound, options.Path)
+	}
+
+	var configBackups []*types.ConfigBackup
+	var err error
+	switch options.BackupType {
+	case types.BackupTypeMultipleName:
+		configBackups, err = io.ReadMultipleConfigsFromSingleFile(rootPath, options)
+	case types.BackupTypeMappingName:
+		configBackups, err = io.ReadKeyedConfigsFromSingleFile(rootPath, options)
+	case types.BackupTypeJsonName:
+		configBackups, err = io.ReadJsonConfigsFromSingleFile(rootPath, options)
+	case types.BackupTypeSingleName:
+		return io.ReadSingleConfigFromSingleFile(rootPath, options)
+	case types.BackupTypeDirectoryName:
+		if err := io.SanitizePath(id); err != nil {
+			return nil, err
+		}
+		directoryPath := rootPath + "/" + options.Path
+		if _, err := os.Stat(filepath.Join(directoryPath, id)); errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s/%s", ErrLiveConfigNotFound, options.Path, id)
+		}
+		return io.ReadSingleConfigFromSingleFilename(directoryPath, id, options)
+	default:
+		return nil, fmt.Errorf("unknown backup type: %s", options.BackupType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]*types.ConfigBackup, len(configBackups))
+	for _, configBackup := range configBackups {
+		if _, exists := items[configBackup.ID]; !exists {
+			items[configBackup.ID] = configBackup
+		}
+	}
+	if live != nil {
+		live[group] = items
+	}
+	return liveItem(items, group, id)
+}
+
+// liveItem returns an item read from a live config file
+func liveItem(items map[string]*types.ConfigBackup, group, id string) (*types.ConfigBackup, error) {
+	configBackup, exists := items[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s in %s", ErrLiveConfigNotFound, id, group)
+	}
+	return configBackup, nil
+}
+
+// DiffBackupAgainstLive compares a backup with the current content of its config item. When the item no longer
+// exists the diff is against empty content and LiveMissing is set.
+func (s *Server) DiffBackupAgainstLive(group, id, filename string, options DiffOptions) (*BackupDiff, error) {
+	identifier := types.ConfigIdentifier{Group: group, ID: id}
+	return s.DiffVersions(
+		ConfigVersion{ConfigIdentifier: identifier, Filename: filename},
+		ConfigVersion{ConfigIdentifier: identifier, Filename: LiveFilename},
+		options,
+	)
+}
+
+// liveInput converts a live config item to be compared. Streamed items, which are binary or large, are read from
+// their file.
+func liveInput(configBackup *types.ConfigBackup) (diffInput, error) {
+	input := diffInput{
+		filename: LiveFilename,
+		content:  configBackup.Blob,
+		binary:   configBackup.Binary,
+		size:     int64(len(configBackup.Blob)),
+		hash:     configBackup.RawHash,
+	}
+	if configBackup.Blob != nil {
+		return input, nil
+	}
+
+	input.size = configBackup.Size
+	if !configBackup.Binary {
+		content, err := os.ReadFile(configBackup.FilePath)
+		if err != nil {
+			return diffInput{}, fmt.Errorf("failed to read %s: %w", configBackup.FilePath, err)
+		}
+		input.content = content
+	}
+	return input, nil
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"fmt"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// globalPauseKey is the timer key used for maintenance mode, as config paths are never empty
+const globalPauseKey = ""
+
+// Pause stops file watching and scans from recording backups for a config, or for every config (maintenance mode)
+// when configPath is empty. A duration of 0 pauses until resumed.
+func (s *Server) Pause(configPath string, duration time.Duration, reason string) (types.PauseState, error) {
+	if configPath != globalPauseKey && s.findConfigOrIncludes(configPath) == nil {
+		return types.PauseState{}, fmt.Errorf("config not found: %s", configPath)
+	}
+
+	now := time.Now().UTC()
+	pause := &types.Pause{Since: now, Reason: reason}
+	if duration > 0 {
+		until := now.Add(duration)
+		pause.Until = &until
+	}
+
+	s.pauseMu.Lock()
+	if configPath == globalPauseKey {
+		s.pauses.Global = pause
+	} else {
+		s.pauses.Configs[configPath] = pause
+	}
+	s.schedulePauseExpiry(configPath, pause)
+	err := io.SavePauseState(s.Settings().BackupDir, s.pauses)
+	s.pauseMu.Unlock()
+
+	if err != nil {
+		slog.Error("Error saving pause state", "error", err)
+	}
+	slog.Info("Paused backups", "config", configPath, "until", pause.Until, "reason", reason)
+	return s.PauseStatus(), nil
+}
+
+// Resume removes a pause, or maintenance mode when configPath is empty, and starts a job to scan the affected configs
+// so changes made while paused are recorded as one snapshot
+func (s *Server) Resume(configPath string) (*Job, error) {
+	s.pauseMu.Lock()
+	paused := s.pauses.Global != nil
+	if configPath != globalPauseKey {
+		_, paused = s.pauses.Configs[configPath]
+	}
+	if !paused {
+		s.pauseMu.Unlock()
+		return nil, fmt.Errorf("not paused: %s", configPath)
+	}
+	s.removePause(configPath)
+	s.pauseMu.Unlock()
+
+	return s.startResumeScan(configPath), nil
+}
+
+// PauseStatus returns the current pauses, leaving out any that have expired
+func (s *Server) PauseStatus() types.PauseState {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	now := time.Now().UTC()
+	status := types.PauseState{Configs: map[string]*types.Pause{}}
+	if s.pauses.Global != nil && !s.pauses.Global.Expired(now) {
+		global := *s.pauses.Global
+		status.Global = &global
+	}
+	for path, pause := range s.pauses.Configs {
+		if !pause.Expired(now) {
+			copied := *pause
+			status.Configs[path] = &copied
+		}
+	}
+	return status
+}
+
+// isPaused reports whether backups for a config are paused, directly, through maintenance mode or through the
+// includes config it was found by
+func (s *Server) isPaused(options *types.ConfigBackupOptions) bool {
+	paths := []string{options.Path}
+	s.State.Mu.RLock()
+	for root, graph := range s.State.IncludeGraphs {
+		if slices.Contains(graph.Configs, options) {
+			paths = append(paths, root)
+		}
+	}
+	s.State.Mu.RUnlock()
+
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	now := time.Now().UTC()
+	if s.pauses.Global != nil && !s.pauses.Global.Expired(now) {
+		return true
+	}
+	for _, path := range paths {
+		if pause, exists := s.pauses.Configs[path]; exists && !pause.Expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPauses restores persisted pauses at startup, dropping any that expired while the server was stopped
+func (s *Server) loadPauses() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	now := time.Now().UTC()
+	if s.pauses.Global != nil {
+		if s.pauses.Global.Expired(now) {
+			s.pauses.Global = nil
+		} else {
+			s.schedulePauseExpiry(globalPauseKey, s.pauses.Global)
+		}
+	}
+	for path, pause := range s.pauses.Configs {
+		if pause.Expired(now) {
+			delete(s.pauses.Configs, path)
+		} else {
+			s.schedulePauseExpiry(path, pause)
+		}
+	}
+
+	if err := io.SavePauseState(s.Settings().BackupDir, s.pauses); err != nil {
+		slog.Error("Error saving pause state", "error", err)
+	}
+}
+
+// schedulePauseExpiry must be called with the pause lock held
+func (s *Server) schedulePauseExpiry(configPath string, pause *types.Pause) {
+	if timer, exists := s.pauseTimers[configPath]; exists {
+		timer.Stop()
+		delete(s.pauseTimers, configPath)
+	}
+	if pause.Until == nil {
+		return
+	}
+
+	s.pauseTimers[configPath] = time.AfterFunc(time.Until(*pause.Until), func() {
+		s.pauseMu.Lock()
+		current := s.pauses.Global
+		if configPath != globalPauseKey {
+			current = s.pauses.Configs[configPath]
+		}
+		if current != pause {
+			s.pauseMu.Unlock()
+			return
+		}
+		s.removePause(configPath)
+		s.pauseMu.Unlock()
+
+		slog.Info("Pause expired, resuming backups", "config", configPath)
+		s.startResumeScan(configPath)
+	})
+}
+
+// removePause must be called with the pause lock held
+func (s *Server) removePause(configPath string) {
+	if timer, exists := s.pauseTimers[configPath]; exists {
+		timer.Stop()
+		delete(s.pauseTimers, configPath)
+	}
+
+	if configPath == globalPauseKey {
+		s.pauses.Global = nil
+	} else {
+		delete(s.pauses.Configs, configPath)
+	}
+
+	if err := io.SavePauseState(s.Settings().BackupDir, s.pauses); err != nil {
+		slog.Error("Error saving pause state", "error", err)
+	}
+}
+
+func (s *Server) startResumeScan(configPath string) *Job {
+	settings := s.Settings()
+	configs := settings.Configs
+	if configPath != globalPauseKey {
+		configs = []*types.ConfigBackupOptions{}
+		if options := s.findConfigOrIncludes(configPath); options != nil {
+			configs = append(configs, options)
+		}
+	}
+
+	slog.Info("Resumed backups, scanning affected configs", "config", configPath, "configs", len(configs))
+	return s.startBackupJob(JobKindResume, false, settings, configs)
+}
+
+// findConfigOrIncludes returns the options for a config path, including includes configs and the configs found
+// through them
+func (s *Server) findConfigOrIncludes(configPath string) *types.ConfigBackupOptions {
+	for _, options := range s.Settings().Configs {
+		if options.Path == configPath {
+			return options
+		}
+	}
+	return s.FindConfigOptions(configPath)
+}
+
+func (s *Server) stopPauseTimers() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	for _, timer := range s.pauseTimers {
+		timer.Stop()
+	}
+	clear(s.pauseTimers)
+}
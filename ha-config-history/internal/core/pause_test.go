@@ -0,0 +1,159 @@
+package core_test
+
+import (
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPause(t *testing.T) {
+	newServer := func(t *testing.T, backupDir string) (*core.Server, string) {
+		configDir := t.TempDir()
+		automations := []byte("- id: one\n  alias: One\n")
+		if err := os.WriteFile(filepath.Join(configDir, "automations.yaml"), automations, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		server := core.NewServer(&types.AppSettings{
+			HomeAssistantConfigDir: configDir,
+			BackupDir:              backupDir,
+			Configs: []*types.ConfigBackupOptions{
+				types.NewMultipleConfigBackupOptions("Automations", "automations.yaml", "id", "alias"),
+			},
+		})
+		server.Start()
+		t.Cleanup(server.Shutdown)
+		return server, configDir
+	}
+
+	waitForJob := func(t *testing.T, server *core.Server, id string) core.JobInfo {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			job, _ := server.GetJob(id)
+			if job.Status != core.JobStatusQueued && job.Status != core.JobStatusRunning {
+				return job
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("Timed out waiting for job to finish")
+		return core.JobInfo{}
+	}
+
+	// The file watcher may record the edit as soon as backups resume, before the resume scan reaches it
+	lastHash := func(server *core.Server) string {
+		server.State.Mu.RLock()
+		defer server.State.Mu.RUnlock()
+		identifier := types.ConfigIdentifier{ID: "one", Group: "automations.yaml"}
+		if metadata, exists := server.State.CachedConfigMetadata[identifier]; exists {
+			return metadata.LastHash
+		}
+		return ""
+	}
+	waitForNewVersion := func(t *testing.T, server *core.Server, previousHash string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if hash := lastHash(server); hash != "" && hash != previousHash {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("Timed out waiting for a new version")
+	}
+
+	editAutomations := func(t *testing.T, configDir string) {
+		automations := []byte("- id: one\n  alias: Edited\n")
+		if err := os.WriteFile(filepath.Join(configDir, "automations.yaml"), automations, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	t.Run("Paused configs are skipped until resumed", func(t *testing.T) {
+		server, configDir := newServer(t, t.TempDir())
+		waitForJob(t, server, server.Jobs()[0].ID)
+		originalHash := lastHash(server)
+
+		if _, err := server.Pause("automations.yaml", 0, "migration"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		editAutomations(t, configDir)
+
+		paused := server.ProcessAllConfigOptions(false)
+		if paused.ItemsProcessed != 0 || paused.VersionsCreated != 0 {
+			t.Fatalf("Expected paused config to be skipped, got: %d items, %d versions",
+				paused.ItemsProcessed, paused.VersionsCreated)
+		}
+		if lastHash(server) != originalHash {
+			t.Fatal("Expected no new version while paused")
+		}
+
+		job, err := server.Resume("automations.yaml")
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		resumed := waitForJob(t, server, job.Info().ID)
+		if resumed.Kind != core.JobKindResume || resumed.Status != core.JobStatusCompleted {
+			t.Fatalf("Expected completed resume scan, got: %s %s", resumed.Kind, resumed.Status)
+		}
+		waitForNewVersion(t, server, originalHash)
+
+		if _, err := server.Resume("automations.yaml"); err == nil {
+			t.Fatal("Expected error resuming a config that isn't paused")
+		}
+	})
+
+	t.Run("Maintenance mode pauses every config and is persisted", func(t *testing.T) {
+		backupDir := t.TempDir()
+		server, _ := newServer(t, backupDir)
+		waitForJob(t, server, server.Jobs()[0].ID)
+
+		if _, err := server.Pause("", time.Hour, "upgrade"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		server.Shutdown()
+
+		restarted, _ := newServer(t, backupDir)
+		status := restarted.PauseStatus()
+		if status.Global == nil || status.Global.Reason != "upgrade" || status.Global.Until == nil {
+			t.Fatalf("Expected maintenance mode to be restored, got: %+v", status.Global)
+		}
+
+		startup := waitForJob(t, restarted, restarted.Jobs()[0].ID)
+		if startup.ItemsProcessed != 0 {
+			t.Fatalf("Expected startup scan to skip paused configs, got: %d items", startup.ItemsProcessed)
+		}
+	})
+
+	t.Run("Pauses expire and trigger a scan", func(t *testing.T) {
+		server, configDir := newServer(t, t.TempDir())
+		waitForJob(t, server, server.Jobs()[0].ID)
+		originalHash := lastHash(server)
+
+		if _, err := server.Pause("automations.yaml", 50*time.Millisecond, ""); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		editAutomations(t, configDir)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) && server.Jobs()[0].Kind != core.JobKindResume {
+			time.Sleep(10 * time.Millisecond)
+		}
+		resumed := waitForJob(t, server, server.Jobs()[0].ID)
+		if resumed.Kind != core.JobKindResume {
+			t.Fatalf("Expected expiry to start a resume scan, got: %s", resumed.Kind)
+		}
+		waitForNewVersion(t, server, originalHash)
+		if len(server.PauseStatus().Configs) != 0 {
+			t.Fatal("Expected pause to be removed")
+		}
+	})
+
+	t.Run("Unknown configs can't be paused", func(t *testing.T) {
+		server, _ := newServer(t, t.TempDir())
+		if _, err := server.Pause("missing.yaml", 0, ""); err == nil {
+			t.Fatal("Expected error pausing an unknown config")
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"fmt"
+	"ha-config-history/internal/io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher backend names, as used by ConfigBackupOptions.Watcher and reported in health output
+const (
+	WatcherBackendFsnotify = "fsnotify"
+	WatcherBackendPoll     = "poll"
+	WatcherBackendAuto     = "auto"
+)
+
+const defaultPollInterval = 10 * time.Second
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+	inode   uint64
+}
+
+// PollingWatcher detects changes by periodically comparing the mtime, size and inode of every file in the
+// watched directories. It is used where fsnotify can't be, such as network filesystems or when the inotify
+// watch limit has been reached, and emits the same events as fsnotify.
+type PollingWatcher struct {
+	Events chan fsnotify.Event
+	Errors chan error
+
+	interval    time.Duration
+	mu          sync.Mutex
+	directories map[string]map[string]fileStat
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func NewPollingWatcher(interval time.Duration) *PollingWatcher {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	return &PollingWatcher{
+		Events:      make(chan fsnotify.Event),
+		Errors:      make(chan error),
+		interval:    interval,
+		directories: make(map[string]map[string]fileStat),
+		done:        make(chan struct{}),
+	}
+}
+
+// Add starts polling a directory, taking an initial snapshot so only later changes produce events
+func (w *PollingWatcher) Add(directory string) error {
+	snapshot, err := snapshotDirectory(directory)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, exists := w.directories[directory]; !exists {
+		w.directories[directory] = snapshot
+	}
+	return nil
+}
+
+func (w *PollingWatcher) Remove(directory string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, exists := w.directories[directory]; !exists {
+		return fmt.Errorf("directory not being polled: %s", directory)
+	}
+	delete(w.directories, directory)
+	return nil
+}
+
+func (w *PollingWatcher) WatchList() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	directories := make([]string, 0, len(w.directories))
+	for directory := range w.directories {
+		directories = append(directories, directory)
+	}
+	slices.Sort(directories)
+	return directories
+}
+
+func (w *PollingWatcher) Start() {
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		defer close(w.Events)
+		defer close(w.Errors)
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				w.poll()
+			}
+		}
+	}()
+}
+
+func (w *PollingWatcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+	return nil
+}
+
+func (w *PollingWatcher) poll() {
+	for _, directory := range w.WatchList() {
+		current, err := snapshotDirectory(directory)
+		if err != nil {
+			w.send(nil, fmt.Errorf("failed to poll directory %s: %w", directory, err))
+			continue
+		}
+
+		w.mu.Lock()
+		previous, exists := w.directories[directory]
+		if exists {
+			w.directories[directory] = current
+		}
+		w.mu.Unlock()
+		if !exists {
+			continue
+		}
+
+		for _, event := range diffSnapshots(directory, previous, current) {
+			if !w.send(&event, nil) {
+				return
+			}
+		}
+	}
+}
+
+// send delivers an event or error, returning false if the watcher was closed while waiting
+func (w *PollingWatcher) send(event *fsnotify.Event, err error) bool {
+	if event != nil {
+		select {
+		case w.Events <- *event:
+			return true
+		case <-w.done:
+			return false
+		}
+	}
+
+	select {
+	case w.Errors <- err:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+func snapshotDirectory(directory string) (map[string]fileStat, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]fileStat, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshot[entry.Name()] = fileStat{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			inode:   io.FileInode(info),
+		}
+	}
+	return snapshot, nil
+}
+
+func diffSnapshots(directory string, previous, current map[string]fileStat) []fsnotify.Event {
+	events := []fsnotify.Event{}
+	for name, stat := range current {
+		old, existed := previous[name]
+		if !existed {
+			events = append(events, fsnotify.Event{Name: filepath.Join(directory, name), Op: fsnotify.Create})
+			continue
+		}
+		if !stat.modTime.Equal(old.modTime) || stat.size != old.size || stat.inode != old.inode {
+			events = append(events, fsnotify.Event{Name: filepath.Join(directory, name), Op: fsnotify.Write})
+		}
+	}
+	for name := range previous {
+		if _, exists := current[name]; !exists {
+			events = append(events, fsnotify.Event{Name: filepath.Join(directory, name), Op: fsnotify.Remove})
+		}
+	}
+	slices.SortFunc(events, func(a, b fsnotify.Event) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return events
+}
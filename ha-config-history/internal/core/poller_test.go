@@ -0,0 +1,97 @@
+package core_test
+
+import (
+	"ha-config-history/internal/core"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestPollingWatcher(t *testing.T) {
+	waitForEvent := func(t *testing.T, watcher *core.PollingWatcher) fsnotify.Event {
+		select {
+		case event := <-watcher.Events:
+			return event
+		case err := <-watcher.Errors:
+			t.Fatalf("Unexpected polling error: %v", err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for polling event")
+		}
+		return fsnotify.Event{}
+	}
+
+	t.Run("Emits write events for changed files", func(t *testing.T) {
+		directory := t.TempDir()
+		file := filepath.Join(directory, "automations.yaml")
+		if err := os.WriteFile(file, []byte("- id: one\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		watcher := core.NewPollingWatcher(10 * time.Millisecond)
+		if err := watcher.Add(directory); err != nil {
+			t.Fatalf("Failed to add directory: %v", err)
+		}
+		watcher.Start()
+		defer watcher.Close()
+
+		if err := os.WriteFile(file, []byte("- id: one\n- id: two\n"), 0644); err != nil {
+			t.Fatalf("Failed to update file: %v", err)
+		}
+
+		event := waitForEvent(t, watcher)
+		if event.Name != file || !event.Has(fsnotify.Write) {
+			t.Errorf("Expected write event for %s, got %v", file, event)
+		}
+	})
+
+	t.Run("Emits create and remove events", func(t *testing.T) {
+		directory := t.TempDir()
+		existing := filepath.Join(directory, "old.yaml")
+		if err := os.WriteFile(existing, []byte("old: true\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		watcher := core.NewPollingWatcher(10 * time.Millisecond)
+		if err := watcher.Add(directory); err != nil {
+			t.Fatalf("Failed to add directory: %v", err)
+		}
+
+		created := filepath.Join(directory, "new.yaml")
+		if err := os.WriteFile(created, []byte("new: true\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.Remove(existing); err != nil {
+			t.Fatalf("Failed to remove file: %v", err)
+		}
+
+		watcher.Start()
+		defer watcher.Close()
+
+		first := waitForEvent(t, watcher)
+		second := waitForEvent(t, watcher)
+
+		if first.Name != created || !first.Has(fsnotify.Create) {
+			t.Errorf("Expected create event for %s, got %v", created, first)
+		}
+		if second.Name != existing || !second.Has(fsnotify.Remove) {
+			t.Errorf("Expected remove event for %s, got %v", existing, second)
+		}
+	})
+
+	t.Run("Stops polling removed directories", func(t *testing.T) {
+		watcher := core.NewPollingWatcher(time.Second)
+		directory := t.TempDir()
+		if err := watcher.Add(directory); err != nil {
+			t.Fatalf("Failed to add directory: %v", err)
+		}
+		if err := watcher.Remove(directory); err != nil {
+			t.Fatalf("Failed to remove directory: %v", err)
+		}
+		if len(watcher.WatchList()) != 0 {
+			t.Errorf("Expected no polled directories, got %v", watcher.WatchList())
+		}
+	})
+}
@@ -1,109 +1,282 @@
 package core
 
 import (
+	"errors"
+	"fmt"
 	"ha-config-history/internal/io"
 	"ha-config-history/internal/types"
 	"log/slog"
+	"path/filepath"
 	"time"
 )
 
-func (s *Server) ProcessAllConfigOptions() {
-	for _, options := range s.AppSettings.Configs {
-		if options.BackupType == "multiple" {
-			current, err := io.ReadMultipleConfigsFromSingleFile(s.AppSettings.HomeAssistantConfigDir, options)
-			if err != nil {
-				slog.Error("Error reading single file for multiple configs", "error", err)
-				continue
-			}
+// ProcessAllConfigOptions runs a manual backup job and waits for it to finish
+func (s *Server) ProcessAllConfigOptions(fullRehash bool) JobInfo {
+	job := s.StartBackupJob(JobKindManual, fullRehash)
+	job.Wait()
+	return job.Info()
+}
 
-			slog.Info("Processing backups for multiple configs",
-				"found_active_configs", len(current),
-				"known_backups", len(s.State.CachedConfigMetadata),
-			)
+// Scan runs a single backup job without starting the file watchers or cron job, for use outside the server
+// (eg. the command line). The backup queue is closed once the job finishes.
+func (s *Server) Scan(fullRehash bool) JobInfo {
+	s.startQueueProcessor()
+	defer s.queue.Close()
+	return s.ProcessAllConfigOptions(fullRehash)
+}
 
-			for _, configBackup := range current {
-				s.queue <- BackupJob{
-					Options: options,
-					Backup:  configBackup,
-				}
-			}
+// processConfigOptions reads a config and queues its items for backup. The settings are the snapshot the job or
+// event started with.
+func (s *Server) processConfigOptions(settings *types.AppSettings, options *types.ConfigBackupOptions, job *Job) {
+	if s.isPaused(options) {
+		slog.Info("Backups paused, skipping config", "path", options.Path)
+		return
+	}
 
-			for _, configBackup := range current {
-				err = s.watchDirectoryForFile(configBackup.FilePath, options)
-				if err != nil {
-					slog.Error("Error watching file for changes", "error", err)
-				}
+	if options.BackupType == "multiple" || options.BackupType == "mapping" ||
+		options.BackupType == "json" || options.BackupType == "single" {
+		filePath := settings.HomeAssistantConfigDir + "/" + options.Path
+		if s.fileUnchanged(filePath, options, job) {
+			if err := s.watchDirectoryForFile(filePath, options); err != nil {
+				slog.Error("Error watching file for changes", "error", err)
 			}
+			return
+		}
+	}
+
+	if options.BackupType == "multiple" {
+		current, err := io.ReadMultipleConfigsFromSingleFile(settings.HomeAssistantConfigDir, options)
+		if err != nil {
+			slog.Error("Error reading single file for multiple configs", "error", err)
+			job.addError(err)
+			return
 		}
 
-		if options.BackupType == "single" {
-			configBackup, err := io.ReadSingleConfigFromSingleFile(s.AppSettings.HomeAssistantConfigDir, options)
+		slog.Info("Processing backups for multiple configs",
+			"found_active_configs", len(current),
+			"known_backups", s.cachedMetadataCount(),
+		)
+
+		for _, configBackup := range current {
+			s.enqueue(BackupJob{
+				Options:  options,
+				Backup:   configBackup,
+				Job:      job,
+				Settings: settings,
+			})
+		}
+		s.queueDeletions(settings, options, itemIDs(current), job)
+
+		for _, configBackup := range current {
+			err = s.watchDirectoryForFile(configBackup.FilePath, options)
 			if err != nil {
-				slog.Error("Error reading single config file", "error", err)
-				continue
+				slog.Error("Error watching file for changes", "error", err)
 			}
+		}
+	}
+
+	if options.BackupType == "mapping" {
+		current, err := io.ReadKeyedConfigsFromSingleFile(settings.HomeAssistantConfigDir, options)
+		if err != nil {
+			slog.Error("Error reading single file for mapping configs", "error", err)
+			job.addError(err)
+			return
+		}
 
-			slog.Info("Processing backup for single config",
-				"id", configBackup.ID,
-				"friendlyName", configBackup.FriendlyName,
-			)
+		slog.Info("Processing backups for mapping configs",
+			"found_active_configs", len(current),
+			"known_backups", s.cachedMetadataCount(),
+		)
 
-			s.queue <- BackupJob{
-				Options: options,
-				Backup:  configBackup,
+		for _, configBackup := range current {
+			s.enqueue(BackupJob{
+				Options:  options,
+				Backup:   configBackup,
+				Job:      job,
+				Settings: settings,
+			})
+		}
+		s.queueDeletions(settings, options, itemIDs(current), job)
+
+		for _, configBackup := range current {
+			err = s.watchDirectoryForFile(configBackup.FilePath, options)
+			if err != nil {
+				slog.Error("Error watching file for changes", "error", err)
 			}
+		}
+	}
+
+	if options.BackupType == "json" {
+		current, err := io.ReadJsonConfigsFromSingleFile(settings.HomeAssistantConfigDir, options)
+		if err != nil {
+			slog.Error("Error reading single file for json configs", "error", err)
+			job.addError(err)
+			return
+		}
+
+		slog.Info("Processing backups for json configs",
+			"found_active_configs", len(current),
+			"known_backups", s.cachedMetadataCount(),
+		)
 
+		for _, configBackup := range current {
+			s.enqueue(BackupJob{
+				Options:  options,
+				Backup:   configBackup,
+				Job:      job,
+				Settings: settings,
+			})
+		}
+		s.queueDeletions(settings, options, itemIDs(current), job)
+
+		for _, configBackup := range current {
 			err = s.watchDirectoryForFile(configBackup.FilePath, options)
 			if err != nil {
 				slog.Error("Error watching file for changes", "error", err)
 			}
 		}
+	}
+
+	if options.BackupType == "single" {
+		configBackup, err := io.ReadSingleConfigFromSingleFile(settings.HomeAssistantConfigDir, options)
+		if errors.Is(err, io.ErrFileTooLarge) {
+			slog.Warn("Skipping file larger than max file size", "error", err)
+			return
+		}
+		if err != nil {
+			slog.Error("Error reading single config file", "error", err)
+			job.addError(err)
+			return
+		}
 
-		if options.BackupType == "directory" {
-			current, err := io.ReadMultipleConfigsFromDirectory(s.AppSettings.HomeAssistantConfigDir, options)
+		slog.Info("Processing backup for single config",
+			"id", configBackup.ID,
+			"friendlyName", configBackup.FriendlyName,
+		)
+
+		s.enqueue(BackupJob{
+			Options:  options,
+			Backup:   configBackup,
+			Job:      job,
+			Settings: settings,
+		})
+
+		err = s.watchDirectoryForFile(configBackup.FilePath, options)
+		if err != nil {
+			slog.Error("Error watching file for changes", "error", err)
+		}
+	}
+
+	if options.BackupType == "directory" {
+		files, err := io.ListDirectoryConfigFiles(settings.HomeAssistantConfigDir, options)
+		if err != nil {
+			slog.Error("Error reading configs from directory", "error", err)
+			job.addError(err)
+			return
+		}
+
+		current := []*types.ConfigBackup{}
+		directoryPath := settings.HomeAssistantConfigDir + "/" + options.Path
+		for _, filePath := range files {
+			if s.fileUnchanged(filePath, options, job) {
+				continue
+			}
+
+			configBackup, err := io.ReadSingleConfigFromSingleFilename(directoryPath, filepath.Base(filePath), options)
+			if errors.Is(err, io.ErrFileTooLarge) {
+				slog.Warn("Skipping file larger than max file size", "error", err)
+				continue
+			}
 			if err != nil {
 				slog.Error("Error reading configs from directory", "error", err)
-				continue
+				job.addError(err)
+				return
 			}
+			current = append(current, configBackup)
+		}
+
+		slog.Info("Processing backups for directory configs",
+			"found_active_configs", len(files),
+			"changed_configs", len(current),
+			"known_backups", s.cachedMetadataCount(),
+		)
 
-			slog.Info("Processing backups for directory configs",
-				"found_active_configs", len(current),
-				"known_backups", len(s.State.CachedConfigMetadata),
-			)
+		for _, configBackup := range current {
+			s.enqueue(BackupJob{
+				Options:  options,
+				Backup:   configBackup,
+				Job:      job,
+				Settings: settings,
+			})
+		}
 
-			for _, configBackup := range current {
-				s.queue <- BackupJob{
-					Options: options,
-					Backup:  configBackup,
-				}
-			}
+		present := make(map[string]struct{}, len(files))
+		for _, filePath := range files {
+			present[filepath.Base(filePath)] = struct{}{}
+		}
+		s.queueDeletions(settings, options, present, job)
 
-			for _, configBackup := range current {
-				err = s.watchDirectoryForFile(configBackup.FilePath, options)
-				if err != nil {
-					slog.Error("Error watching file for changes", "error", err)
-				}
+		for _, filePath := range files {
+			err = s.watchDirectoryForFile(filePath, options)
+			if err != nil {
+				slog.Error("Error watching file for changes", "error", err)
 			}
 		}
 	}
+
+	if options.BackupType == "includes" {
+		s.processIncludes(settings, options, job)
+	}
+}
+
+func (s *Server) cachedMetadataCount() int {
+	s.State.Mu.RLock()
+	defer s.State.Mu.RUnlock()
+	return len(s.State.CachedConfigMetadata)
 }
 
 func (s *Server) startQueueProcessor() {
-	go func() {
-		for job := range s.queue {
-			start := time.Now()
-			s.handleUpdateToFile(job.Options, job.Backup)
-			slog.Debug("Processed backup job",
-				"id", job.Backup.ID,
-				"group", job.Backup.Group,
-				"duration", time.Since(start),
-			)
-		}
-		slog.Info("Queue processor stopped")
-	}()
+	s.queue.Start(func(item BackupJob) {
+		if item.Job.Cancelled() {
+			return
+		}
+
+		start := time.Now()
+		var created bool
+		var err error
+		unlock := s.configLocks.lock(item.Backup.ConfigIdentifier)
+		if item.Deleted {
+			err = s.handleDeletion(item.Settings, item.Backup)
+		} else {
+			created, err = s.handleUpdateToFile(item.Settings, item.Options, item.Backup)
+		}
+		unlock()
+		item.Job.itemProcessed(created, err)
+		slog.Debug("Processed backup job",
+			"id", item.Backup.ID,
+			"group", item.Backup.Group,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// enqueue queues a backup for processing, counting it against its job unless the job was cancelled
+func (s *Server) enqueue(item BackupJob) {
+	if item.Job.Cancelled() {
+		return
+	}
+	item.Job.itemQueued()
+	if !s.queue.Push(item) {
+		item.Job.itemProcessed(false, fmt.Errorf("backup queue closed before %s could be processed", item.Backup.ID))
+	}
 }
 
-func (s *Server) handleUpdateToFile(backupOptions *types.ConfigBackupOptions, activeConfigBackup *types.ConfigBackup) {
+// handleUpdateToFile saves a backup if the config has changed, reporting whether a new version was created
+func (s *Server) handleUpdateToFile(
+	settings *types.AppSettings,
+	backupOptions *types.ConfigBackupOptions,
+	activeConfigBackup *types.ConfigBackup,
+) (bool, error) {
 	s.State.Mu.RLock()
 	for _, metadata := range s.State.CachedConfigMetadata {
 		slog.Debug("Cached config metadata",
@@ -115,43 +288,107 @@ func (s *Server) handleUpdateToFile(backupOptions *types.ConfigBackupOptions, ac
 	}
 
 	metadata, exists := s.State.CachedConfigMetadata[activeConfigBackup.ConfigIdentifier]
-	needsBackup := !exists || activeConfigBackup.Hash != metadata.LastHash
+	// An item that reappears after being deleted is saved even when unchanged, dating its return
+	needsBackup := !exists || activeConfigBackup.Hash != metadata.LastHash || metadata.Deleted
+	formatOnly := exists && !needsBackup && metadata.LastRawHash != "" && activeConfigBackup.RawHash != metadata.LastRawHash
+	var deletions []time.Time
+	if exists {
+		deletions = metadata.Deletions
+	}
 	s.State.Mu.RUnlock()
 
-	if needsBackup {
-		slog.Info("Config changed, saving backup",
-			"friendlyName", activeConfigBackup.FriendlyName,
+	if formatOnly {
+		s.recordFormatOnlyChange(settings, metadata, activeConfigBackup)
+	}
+
+	if !needsBackup {
+		return false, nil
+	}
+
+	slog.Info("Config changed, saving backup",
+		"friendlyName", activeConfigBackup.FriendlyName,
+		"id", activeConfigBackup.ID,
+	)
+
+	backupDir, err := io.GetBackupDirectory(settings.BackupDir, activeConfigBackup)
+	if err != nil {
+		slog.Error("Error getting config backup directory",
 			"id", activeConfigBackup.ID,
+			"error", err,
 		)
+		return false, fmt.Errorf("failed to get backup directory for %s: %w", activeConfigBackup.ID, err)
+	}
 
-		backupDir, err := io.GetBackupDirectory(s.AppSettings.BackupDir, activeConfigBackup)
-		if err != nil {
-			slog.Error("Error getting config backup directory",
-				"id", activeConfigBackup.ID,
-				"error", err,
-			)
-		}
+	err = io.SaveConfigBackup(activeConfigBackup, backupDir)
+	if err != nil {
+		slog.Error("Error saving config backup",
+			"id", activeConfigBackup.ID,
+			"error", err,
+		)
+		return false, fmt.Errorf("failed to save backup for %s: %w", activeConfigBackup.ID, err)
+	}
 
-		err = io.SaveConfigBackup(activeConfigBackup, backupDir)
-		if err != nil {
-			slog.Error("Error saving config backup",
-				"id", activeConfigBackup.ID,
-				"error", err,
-			)
-		}
+	updatedMetadata, err := io.CleanupAndUpdateMetadata(activeConfigBackup, backupOptions, backupDir, settings.DefaultMaxBackups, settings.DefaultMaxBackupAgeDays, deletions)
+	if err != nil {
+		slog.Error("Error updating config metadata",
+			"id", activeConfigBackup.ID,
+			"error", err,
+		)
+		return true, fmt.Errorf("failed to update metadata for %s: %w", activeConfigBackup.ID, err)
+	}
 
-		updatedMetadata, err := io.CleanupAndUpdateMetadata(activeConfigBackup, backupOptions, backupDir, s.AppSettings.DefaultMaxBackups, s.AppSettings.DefaultMaxBackupAgeDays)
-		if err != nil {
-			slog.Error("Error updating config metadata",
-				"id", activeConfigBackup.ID,
-				"error", err,
-			)
-		}
+	if updatedMetadata != nil {
+		s.cacheMetadata(settings, updatedMetadata)
+	}
 
-		if updatedMetadata != nil {
-			s.State.Mu.Lock()
-			s.State.CachedConfigMetadata[activeConfigBackup.ConfigIdentifier] = updatedMetadata
-			s.State.Mu.Unlock()
-		}
+	return true, nil
+}
+
+// recordFormatOnlyChange flags a change that only affected formatting, without saving a new backup
+func (s *Server) recordFormatOnlyChange(
+	settings *types.AppSettings,
+	metadata *types.ConfigMetadata,
+	activeConfigBackup *types.ConfigBackup,
+) {
+	slog.Info("Config formatting changed without a meaningful change, skipping backup",
+		"friendlyName", activeConfigBackup.FriendlyName,
+		"id", activeConfigBackup.ID,
+	)
+
+	now := time.Now().UTC()
+	updatedMetadata := *metadata
+	updatedMetadata.LastRawHash = activeConfigBackup.RawHash
+	updatedMetadata.FormatOnlyChanges++
+	updatedMetadata.LastFormatOnlyChange = &now
+
+	backupDir, err := io.GetBackupDirectory(settings.BackupDir, activeConfigBackup)
+	if err != nil {
+		slog.Error("Error getting config backup directory",
+			"id", activeConfigBackup.ID,
+			"error", err,
+		)
+		return
+	}
+
+	if err := io.SaveMetadata(backupDir, &updatedMetadata); err != nil {
+		slog.Error("Error updating config metadata",
+			"id", activeConfigBackup.ID,
+			"error", err,
+		)
+		return
+	}
+
+	s.cacheMetadata(settings, &updatedMetadata)
+}
+
+// cacheMetadata updates the cached metadata of a config, unless the backup directory it was saved to has since been
+// replaced by applying settings
+func (s *Server) cacheMetadata(settings *types.AppSettings, metadata *types.ConfigMetadata) {
+	s.State.Mu.Lock()
+	defer s.State.Mu.Unlock()
+	if s.Settings().BackupDir != settings.BackupDir {
+		slog.Info("Backup directory changed while saving, not caching metadata", "id", metadata.ID)
+		return
 	}
+	s.State.CachedConfigMetadata[metadata.ConfigIdentifier] = metadata
 }
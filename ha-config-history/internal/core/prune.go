@@ -0,0 +1,85 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"path/filepath"
+	"sort"
+)
+
+// PrunedBackup is a backup removed, or that would be removed, by Prune
+type PrunedBackup struct {
+	types.ConfigIdentifier
+	Filename string `json:"filename"`
+	Reason   string `json:"reason"`
+}
+
+// Prune applies retention limits to the backups of every config, which otherwise only happens when a new backup is
+// saved. Backups of configs that are no longer in the settings use the default limits. With dryRun, the backups that
+// would be removed are returned without removing them.
+func (s *Server) Prune(dryRun bool) ([]PrunedBackup, error) {
+	settings := s.Settings()
+	s.State.Mu.RLock()
+	identifiers := make([]types.ConfigIdentifier, 0, len(s.State.CachedConfigMetadata))
+	for identifier := range s.State.CachedConfigMetadata {
+		identifiers = append(identifiers, identifier)
+	}
+	s.State.Mu.RUnlock()
+
+	sort.Slice(identifiers, func(i, j int) bool {
+		if identifiers[i].Group != identifiers[j].Group {
+			return identifiers[i].Group < identifiers[j].Group
+		}
+		return identifiers[i].ID < identifiers[j].ID
+	})
+
+	pruned := []PrunedBackup{}
+	var errs []error
+	for _, identifier := range identifiers {
+		options := s.FindConfigOptions(identifier.Group)
+		if options == nil {
+			options = &types.ConfigBackupOptions{Path: identifier.Group}
+		}
+
+		backupDirectory := filepath.Join(settings.BackupDir, identifier.Group, identifier.ID)
+		maxBackups, oldestAllowed := io.RetentionLimits(options, settings.DefaultMaxBackups,
+			settings.DefaultMaxBackupAgeDays)
+		expired, err := io.ExpiredBackups(backupDirectory, maxBackups, oldestAllowed)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(expired) == 0 {
+			continue
+		}
+
+		for _, backup := range expired {
+			pruned = append(pruned, PrunedBackup{
+				ConfigIdentifier: identifier,
+				Filename:         backup.Filename,
+				Reason:           backup.Reason,
+			})
+			if !dryRun {
+				io.RemoveBackup(backupDirectory, backup.Filename, backup.Reason)
+			}
+		}
+		if dryRun {
+			continue
+		}
+
+		metadata, err := io.UpdateMetadataAfterDeletion(settings.BackupDir, identifier.Group, identifier.ID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to update metadata for %s: %w", identifier.ID, err))
+			continue
+		}
+		if metadata != nil {
+			s.State.Mu.Lock()
+			s.State.CachedConfigMetadata[identifier] = metadata
+			s.State.Mu.Unlock()
+		}
+	}
+
+	return pruned, errors.Join(errs...)
+}
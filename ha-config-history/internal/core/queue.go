@@ -0,0 +1,169 @@
+package core
+
+import (
+	"ha-config-history/internal/types"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBackupWorkers = 4
+	defaultQueueDepth    = 100
+)
+
+// QueueStats reports how busy the backup queue is. BlockedPushes counts items that had to wait for space in a
+// full worker queue, and BlockedTime is the total time spent waiting, so a growing value means the workers
+// can't keep up with the file watcher and scans.
+type QueueStats struct {
+	Workers       int           `json:"workers"`
+	Capacity      int           `json:"capacity"`
+	Depth         int64         `json:"depth"`
+	MaxDepth      int64         `json:"maxDepth"`
+	Enqueued      int64         `json:"enqueued"`
+	Processed     int64         `json:"processed"`
+	BlockedPushes int64         `json:"blockedPushes"`
+	BlockedTime   time.Duration `json:"blockedTimeNs"`
+}
+
+// BackupQueue spreads backup items over a pool of workers. Items are routed by their ConfigIdentifier, so every
+// item for the same config is handled by the same worker in the order it was pushed, while different configs are
+// processed in parallel. Each worker has a bounded queue and Push blocks while it is full.
+type BackupQueue struct {
+	workers []chan BackupJob
+	depth   int
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+
+	queued        atomic.Int64
+	maxQueued     atomic.Int64
+	enqueued      atomic.Int64
+	processed     atomic.Int64
+	blockedPushes atomic.Int64
+	blockedTime   atomic.Int64
+}
+
+// configLocks serialises saving versions of each config between the queue workers and imports, which save versions
+// dated to their archive outside the queue. The zero value is ready to use.
+type configLocks struct {
+	mu    sync.Mutex
+	locks map[types.ConfigIdentifier]*sync.Mutex
+}
+
+// lock locks a config, returning the function that unlocks it
+func (l *configLocks) lock(identifier types.ConfigIdentifier) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[types.ConfigIdentifier]*sync.Mutex{}
+	}
+	lock, exists := l.locks[identifier]
+	if !exists {
+		lock = &sync.Mutex{}
+		l.locks[identifier] = lock
+	}
+	l.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+func NewBackupQueue(workers, depth int) *BackupQueue {
+	if workers <= 0 {
+		workers = defaultBackupWorkers
+	}
+	if depth <= 0 {
+		depth = defaultQueueDepth
+	}
+
+	queue := &BackupQueue{
+		workers: make([]chan BackupJob, workers),
+		depth:   depth,
+	}
+	for i := range queue.workers {
+		queue.workers[i] = make(chan BackupJob, depth)
+	}
+	return queue
+}
+
+// Start runs handle for every pushed item on the worker pool
+func (q *BackupQueue) Start(handle func(BackupJob)) {
+	for _, items := range q.workers {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for item := range items {
+				q.queued.Add(-1)
+				handle(item)
+				q.processed.Add(1)
+			}
+		}()
+	}
+}
+
+// Push queues an item, blocking while its worker's queue is full. Items pushed after Close are dropped.
+func (q *BackupQueue) Push(item BackupJob) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+
+	worker := q.workers[q.workerFor(item)]
+
+	q.enqueued.Add(1)
+	queued := q.queued.Add(1)
+	for {
+		max := q.maxQueued.Load()
+		if queued <= max || q.maxQueued.CompareAndSwap(max, queued) {
+			break
+		}
+	}
+
+	select {
+	case worker <- item:
+	default:
+		start := time.Now()
+		worker <- item
+		q.blockedPushes.Add(1)
+		q.blockedTime.Add(int64(time.Since(start)))
+	}
+	return true
+}
+
+// Close stops accepting items and waits for the workers to finish what has already been queued
+func (q *BackupQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	for _, items := range q.workers {
+		close(items)
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+func (q *BackupQueue) Stats() QueueStats {
+	return QueueStats{
+		Workers:       len(q.workers),
+		Capacity:      len(q.workers) * q.depth,
+		Depth:         q.queued.Load(),
+		MaxDepth:      q.maxQueued.Load(),
+		Enqueued:      q.enqueued.Load(),
+		Processed:     q.processed.Load(),
+		BlockedPushes: q.blockedPushes.Load(),
+		BlockedTime:   time.Duration(q.blockedTime.Load()),
+	}
+}
+
+func (q *BackupQueue) workerFor(item BackupJob) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(item.Backup.Group))
+	_, _ = hash.Write([]byte{0})
+	_, _ = hash.Write([]byte(item.Backup.ID))
+	return int(hash.Sum32() % uint32(len(q.workers)))
+}
@@ -0,0 +1,131 @@
+package core_test
+
+import (
+	"fmt"
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/types"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newQueueItem(group, id string, sequence int) core.BackupJob {
+	return core.BackupJob{
+		Backup: &types.ConfigBackup{
+			ConfigIdentifier: types.ConfigIdentifier{ID: id, Group: group},
+			FriendlyName:     strconv.Itoa(sequence),
+		},
+	}
+}
+
+func TestBackupQueue(t *testing.T) {
+	t.Run("Keeps items for the same config in order", func(t *testing.T) {
+		queue := core.NewBackupQueue(4, 2)
+
+		var mu sync.Mutex
+		seen := map[types.ConfigIdentifier][]int{}
+		queue.Start(func(item core.BackupJob) {
+			sequence, _ := strconv.Atoi(item.Backup.FriendlyName)
+			mu.Lock()
+			seen[item.Backup.ConfigIdentifier] = append(seen[item.Backup.ConfigIdentifier], sequence)
+			mu.Unlock()
+		})
+
+		var producers sync.WaitGroup
+		for p := range 4 {
+			producers.Add(1)
+			go func() {
+				defer producers.Done()
+				for sequence := range 50 {
+					for id := range 5 {
+						queue.Push(newQueueItem(fmt.Sprintf("group-%d", p), strconv.Itoa(id), sequence))
+					}
+				}
+			}()
+		}
+		producers.Wait()
+		queue.Close()
+
+		if len(seen) != 20 {
+			t.Fatalf("Expected 20 configs, got: %d", len(seen))
+		}
+		for identifier, sequences := range seen {
+			if len(sequences) != 50 {
+				t.Fatalf("Expected 50 items for %v, got: %d", identifier, len(sequences))
+			}
+			for i, sequence := range sequences {
+				if sequence != i {
+					t.Fatalf("Expected item %d for %v, got: %d", i, identifier, sequence)
+				}
+			}
+		}
+
+		stats := queue.Stats()
+		if stats.Enqueued != 1000 || stats.Processed != 1000 || stats.Depth != 0 {
+			t.Fatalf("Expected 1000 items enqueued and processed with an empty queue, got: %+v", stats)
+		}
+	})
+
+	t.Run("Processes different configs in parallel", func(t *testing.T) {
+		queue := core.NewBackupQueue(4, 10)
+
+		var running, maxRunning atomic.Int64
+		queue.Start(func(item core.BackupJob) {
+			current := running.Add(1)
+			for {
+				max := maxRunning.Load()
+				if current <= max || maxRunning.CompareAndSwap(max, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		})
+
+		for id := range 40 {
+			queue.Push(newQueueItem("group", strconv.Itoa(id), 0))
+		}
+		queue.Close()
+
+		if maxRunning.Load() < 2 {
+			t.Fatalf("Expected items to be processed in parallel, max running: %d", maxRunning.Load())
+		}
+	})
+
+	t.Run("Records backpressure when a worker queue is full", func(t *testing.T) {
+		queue := core.NewBackupQueue(1, 1)
+
+		release := make(chan struct{})
+		queue.Start(func(item core.BackupJob) {
+			<-release
+		})
+
+		pushed := make(chan struct{})
+		go func() {
+			for sequence := range 3 {
+				queue.Push(newQueueItem("group", "id", sequence))
+			}
+			close(pushed)
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		stats := queue.Stats()
+		if stats.MaxDepth < 2 {
+			t.Fatalf("Expected max depth of at least 2, got: %d", stats.MaxDepth)
+		}
+
+		close(release)
+		<-pushed
+		queue.Close()
+
+		stats = queue.Stats()
+		if stats.BlockedPushes < 1 || stats.BlockedTime <= 0 {
+			t.Fatalf("Expected blocked pushes to be recorded, got: %+v", stats)
+		}
+		if stats.Capacity != 1 || stats.Workers != 1 {
+			t.Fatalf("Expected 1 worker with capacity 1, got: %+v", stats)
+		}
+	})
+}
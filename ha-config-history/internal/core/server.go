@@ -6,6 +6,8 @@ import (
 	"log"
 	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/robfig/cron/v3"
@@ -15,30 +17,40 @@ import (
 type BackupJob struct {
 	Options *types.ConfigBackupOptions
 	Backup  *types.ConfigBackup
+	Job     *Job // nil for backups triggered by the file watcher
+	// Settings are the settings the backup was read with
+	Settings *types.AppSettings
+	// Deleted marks an item that is no longer in its config, whose Backup only identifies it
+	Deleted bool
 }
 
 type Server struct {
-	State       *State
-	AppSettings *types.AppSettings
-	queue       chan BackupJob
+	State *State
+	// settings are replaced, never modified, when settings are applied
+	settings    atomic.Pointer[types.AppSettings]
+	queue       *BackupQueue
+	configLocks configLocks
 	fileWatcher *fsnotify.Watcher
+	poller      *PollingWatcher
+	jobs        *JobManager
+	statCache   atomic.Pointer[io.StatCache]
+	pauseMu     sync.Mutex
+	pauses      *types.PauseState
+	pauseTimers map[string]*time.Timer
+	settingsMu  sync.Mutex
+	// settingsPath is the absolute path of the watched settings file, guarded by State.Mu
+	settingsPath string
 }
 
+// validateConfig logs problems with the settings at startup. The serve and scan commands refuse settings with errors
+// before getting here, so this mostly reports warnings.
 func (s *Server) validateConfig() {
-	if !io.DirectoryExists(s.AppSettings.HomeAssistantConfigDir) {
-		slog.Error("Home Assistant configuration directory does not exist",
-			"dir", s.AppSettings.HomeAssistantConfigDir)
+	validation := ValidateSettings(s.Settings())
+	for _, issue := range validation.Errors {
+		slog.Error("Invalid setting", "field", issue.Field, "error", issue.Message)
 	}
-
-	uniquePaths := make(map[string]struct{})
-	for _, options := range s.AppSettings.Configs {
-		if _, exists := uniquePaths[options.Path]; exists {
-			slog.Error("Duplicate config path found in settings",
-				"path", options.Path,
-				"name", options.Name)
-		} else {
-			uniquePaths[options.Path] = struct{}{}
-		}
+	for _, issue := range validation.Warnings {
+		slog.Warn("Setting may be incorrect", "field", issue.Field, "warning", issue.Message)
 	}
 }
 
@@ -51,27 +63,72 @@ func NewServer(config *types.AppSettings) *Server {
 		metadataMap = map[types.ConfigIdentifier]*types.ConfigMetadata{}
 	}
 
+	statCache, err := io.LoadStatCache(config.BackupDir)
+	if err != nil {
+		slog.Warn("Unable to load stat cache, all files will be read", "error", err)
+	}
+
+	pauses, err := io.LoadPauseState(config.BackupDir)
+	if err != nil {
+		slog.Error("Error loading pause state, nothing will be paused", "error", err)
+	}
+
 	fileWatcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	return &Server{
+	pollInterval := defaultPollInterval
+	if config.PollIntervalSeconds != nil {
+		pollInterval = time.Duration(*config.PollIntervalSeconds) * time.Second
+	}
+
+	workers, queueDepth := 0, 0
+	if config.BackupWorkers != nil {
+		workers = *config.BackupWorkers
+	}
+	if config.QueueDepth != nil {
+		queueDepth = *config.QueueDepth
+	}
+
+	server := &Server{
 		State: &State{
 			CachedConfigMetadata: metadataMap,
 			FileLookup:           make(map[string]*types.ConfigBackupOptions),
+			IncludeGraphs:        make(map[string]*types.IncludeGraph),
+			IncludeRoots:         make(map[string]*types.ConfigBackupOptions),
+			WatchBackends:        make(map[string]string),
 		},
-		AppSettings: config,
-		queue:       make(chan BackupJob),
+		queue:       NewBackupQueue(workers, queueDepth),
 		fileWatcher: fileWatcher,
+		poller:      NewPollingWatcher(pollInterval),
+		jobs:        NewJobManager(),
+		pauses:      pauses,
+		pauseTimers: make(map[string]*time.Timer),
 	}
+	server.settings.Store(config)
+	server.statCache.Store(statCache)
+	return server
+}
+
+// Settings returns the current settings. Settings can be applied at any time, so a job or event should take one
+// snapshot and use it throughout rather than calling Settings again.
+func (s *Server) Settings() *types.AppSettings {
+	return s.settings.Load()
 }
 
 func (s *Server) Start() {
 	s.startQueueProcessor()
 	s.startFileWatcher()
 	s.validateConfig()
-	s.ProcessAllConfigOptions()
+
+	// Record the settings in case they were edited while stopped, so the first change from here can be rolled back
+	s.settingsMu.Lock()
+	s.recordSettingsVersion()
+	s.settingsMu.Unlock()
+
+	s.loadPauses()
+	s.StartBackupJob(JobKindStartup, false)
 	_ = s.RestartCronJob()
 }
 
@@ -80,6 +137,14 @@ type State struct {
 	CachedConfigMetadata map[types.ConfigIdentifier]*types.ConfigMetadata
 	CronJob              *cron.Cron
 	FileLookup           map[string]*types.ConfigBackupOptions
+	IncludeGraphs        map[string]*types.IncludeGraph
+	IncludeRoots         map[string]*types.ConfigBackupOptions
+	WatchBackends        map[string]string
+}
+
+// QueueStats reports backup queue depth and backpressure
+func (s *Server) QueueStats() QueueStats {
+	return s.queue.Stats()
 }
 
 // Shutdown gracefully stops the server resources
@@ -90,8 +155,12 @@ func (s *Server) Shutdown() {
 			slog.Error("Error closing file watcher", "error", err)
 		}
 	}
+	if s.poller != nil {
+		_ = s.poller.Close()
+	}
+	s.stopPauseTimers()
 	if s.queue != nil {
-		close(s.queue)
+		s.queue.Close()
 	}
 	if s.State.CronJob != nil {
 		s.State.CronJob.Stop()
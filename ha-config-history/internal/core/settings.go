@@ -0,0 +1,331 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const defaultSettingsFile = "config.json"
+
+// SettingsChanges describes what ApplySettings changed, by config path
+type SettingsChanges struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+	// Job is the scan of added and changed configs, if any
+	Job *JobInfo `json:"job,omitempty"`
+}
+
+// SaveSettings writes settings to the settings file, with the current schema version, and applies them
+func (s *Server) SaveSettings(newSettings *types.AppSettings) (SettingsChanges, error) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	newSettings.SchemaVersion = types.CurrentSchemaVersion()
+
+	configData, err := json.MarshalIndent(newSettings, "", "  ")
+	if err != nil {
+		return SettingsChanges{}, fmt.Errorf("failed to serialize settings: %w", err)
+	}
+	if err := os.WriteFile(s.settingsFile(), configData, 0644); err != nil {
+		return SettingsChanges{}, fmt.Errorf("failed to save settings file: %w", err)
+	}
+
+	// Apply the settings as they will be read back from the file, with defaults filled in, so reloading the file
+	// doesn't see a change
+	saved, err := types.ParseConfig(configData)
+	if err != nil {
+		return SettingsChanges{}, fmt.Errorf("failed to read back settings: %w", err)
+	}
+	return s.applySettings(saved), nil
+}
+
+// ApplySettings switches the server to new settings without a restart. Configs that were removed or changed stop
+// being watched, added and changed configs are scanned, and the cron job is restarted if its schedule changed.
+// Changing the Home Assistant config directory or the backup directory rescans every config, after loading the new
+// backup directory's metadata. Backup worker and queue depth changes still need a restart. The new settings are saved
+// to the settings history.
+func (s *Server) ApplySettings(newSettings *types.AppSettings) SettingsChanges {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	return s.applySettings(newSettings)
+}
+
+// applySettings must be called with the settings lock held
+func (s *Server) applySettings(newSettings *types.AppSettings) SettingsChanges {
+	oldSettings := s.Settings()
+	changes := SettingsChanges{Added: []string{}, Removed: []string{}, Changed: []string{}}
+
+	oldConfigs := make(map[string]*types.ConfigBackupOptions, len(oldSettings.Configs))
+	for _, options := range oldSettings.Configs {
+		oldConfigs[options.Path] = options
+	}
+	newConfigs := make(map[string]*types.ConfigBackupOptions, len(newSettings.Configs))
+	for _, options := range newSettings.Configs {
+		newConfigs[options.Path] = options
+	}
+
+	rootChanged := oldSettings.HomeAssistantConfigDir != newSettings.HomeAssistantConfigDir
+	backupDirChanged := oldSettings.BackupDir != newSettings.BackupDir
+	toScan := []*types.ConfigBackupOptions{}
+	for _, options := range newSettings.Configs {
+		previous, existed := oldConfigs[options.Path]
+		switch {
+		case !existed:
+			changes.Added = append(changes.Added, options.Path)
+			toScan = append(toScan, options)
+		case rootChanged || io.OptionsHash(previous) != io.OptionsHash(options):
+			changes.Changed = append(changes.Changed, options.Path)
+			toScan = append(toScan, options)
+		case backupDirChanged:
+			// The new backup directory may not have the current version of every file yet
+			toScan = append(toScan, options)
+		}
+	}
+	for _, options := range oldSettings.Configs {
+		if _, exists := newConfigs[options.Path]; !exists {
+			changes.Removed = append(changes.Removed, options.Path)
+		}
+	}
+
+	stale := []*types.ConfigBackupOptions{}
+	for _, path := range slices.Concat(changes.Removed, changes.Changed) {
+		stale = append(stale, oldConfigs[path])
+	}
+	s.untrackConfigs(stale)
+
+	if rootChanged || backupDirChanged {
+		// Jobs keep the settings they started with, so stop them reading from or writing to the old directories.
+		// Every config is rescanned below.
+		if cancelled := s.jobs.cancelUnfinished(); cancelled > 0 {
+			slog.Info("Cancelled jobs started with the previous directories", "jobs", cancelled)
+		}
+	}
+
+	if backupDirChanged {
+		s.moveBackupDir(newSettings)
+	} else {
+		s.settings.Store(newSettings)
+	}
+	s.removeUnusedWatches()
+	s.recordSettingsVersion()
+
+	if cronSchedule(oldSettings) != cronSchedule(newSettings) {
+		_ = s.RestartCronJob()
+		slog.Info("Cron schedule updated", "schedule", cronSchedule(newSettings))
+	}
+
+	if len(toScan) > 0 {
+		info := s.startBackupJob(JobKindSettings, false, newSettings, toScan).Info()
+		changes.Job = &info
+	}
+
+	slog.Info("Settings applied",
+		"added", changes.Added,
+		"removed", changes.Removed,
+		"changed", changes.Changed,
+	)
+	return changes
+}
+
+// WatchSettingsFile reloads settings whenever the settings file is edited outside the app
+func (s *Server) WatchSettingsFile(path string) error {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve settings file %s: %w", path, err)
+	}
+
+	directory := filepath.Dir(absolutePath)
+	s.State.Mu.RLock()
+	_, watched := s.State.WatchBackends[directory]
+	s.State.Mu.RUnlock()
+
+	// The directory's existing watcher already reports changes to the settings file
+	backend := WatcherBackendFsnotify
+	if !watched {
+		if err := s.fileWatcher.Add(directory); err != nil {
+			slog.Warn("Unable to watch settings file with fsnotify, falling back to polling",
+				"file", absolutePath, "error", err)
+			backend = WatcherBackendPoll
+			if err := s.poller.Add(directory); err != nil {
+				return fmt.Errorf("failed to watch settings file %s: %w", absolutePath, err)
+			}
+		}
+	}
+
+	s.State.Mu.Lock()
+	s.settingsPath = absolutePath
+	if !watched {
+		s.State.WatchBackends[directory] = backend
+	}
+	s.State.Mu.Unlock()
+	return nil
+}
+
+// reloadSettingsFile applies settings from the settings file, unless they are invalid or match the current settings
+// (eg. when the file was just written by the settings API). The file is read with the settings lock held, so an event
+// for an earlier write can't apply content that has since been replaced.
+func (s *Server) reloadSettingsFile(path string) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("Error reading settings file", "file", path, "error", err)
+		return
+	}
+
+	newSettings, err := types.ParseConfig(data)
+	if err != nil {
+		slog.Error("Error parsing settings file, keeping current settings", "file", path, "error", err)
+		return
+	}
+	if validation := ValidateSettings(newSettings); !validation.Valid() {
+		slog.Error("Invalid settings file, keeping current settings", "file", path, "error", validation.Err())
+		return
+	}
+
+	current, _ := json.Marshal(s.Settings())
+	updated, _ := json.Marshal(newSettings)
+	if bytes.Equal(current, updated) {
+		return
+	}
+
+	slog.Info("Settings file changed, applying settings", "file", path)
+	s.applySettings(newSettings)
+}
+
+func (s *Server) isSettingsFile(path string) bool {
+	s.State.Mu.RLock()
+	defer s.State.Mu.RUnlock()
+	return s.settingsPath != "" && path == s.settingsPath
+}
+
+// settingsFile returns the watched settings file, or config.json in the working directory if none is watched
+func (s *Server) settingsFile() string {
+	s.State.Mu.RLock()
+	defer s.State.Mu.RUnlock()
+	if s.settingsPath == "" {
+		return defaultSettingsFile
+	}
+	return s.settingsPath
+}
+
+// untrackConfigs forgets the files, include graphs and stat cache entries of configs that are being removed or
+// replaced, so file events for them are ignored until they are scanned again
+func (s *Server) untrackConfigs(configs []*types.ConfigBackupOptions) {
+	if len(configs) == 0 {
+		return
+	}
+
+	s.State.Mu.Lock()
+	for _, options := range configs {
+		// Configs left unchanged by earlier settings are still tracked with the options they were scanned with, so
+		// the config itself is matched by path
+		owned := func(lookup *types.ConfigBackupOptions) bool {
+			return lookup.Path == options.Path
+		}
+		if graph, exists := s.State.IncludeGraphs[options.Path]; exists {
+			owned = func(lookup *types.ConfigBackupOptions) bool {
+				return lookup.Path == options.Path || slices.Contains(graph.Configs, lookup)
+			}
+			delete(s.State.IncludeGraphs, options.Path)
+		}
+
+		for path, root := range s.State.IncludeRoots {
+			if root.Path == options.Path {
+				delete(s.State.IncludeRoots, path)
+			}
+		}
+		for path, lookup := range s.State.FileLookup {
+			if owned(lookup) {
+				delete(s.State.FileLookup, path)
+			}
+		}
+	}
+	s.State.Mu.Unlock()
+
+	for _, options := range configs {
+		s.InvalidateStatCache(options.Path)
+	}
+}
+
+// removeUnusedWatches stops watching directories that no longer contain a tracked file
+func (s *Server) removeUnusedWatches() {
+	s.State.Mu.Lock()
+	defer s.State.Mu.Unlock()
+
+	used := map[string]struct{}{}
+	for path := range s.State.FileLookup {
+		used[filepath.Dir(path)] = struct{}{}
+	}
+	for path := range s.State.IncludeRoots {
+		used[filepath.Dir(path)] = struct{}{}
+	}
+	if s.settingsPath != "" {
+		used[filepath.Dir(s.settingsPath)] = struct{}{}
+	}
+
+	for directory, backend := range s.State.WatchBackends {
+		if _, inUse := used[directory]; inUse {
+			continue
+		}
+
+		var err error
+		if backend == WatcherBackendPoll {
+			err = s.poller.Remove(directory)
+		} else {
+			err = s.fileWatcher.Remove(directory)
+		}
+		if err != nil {
+			slog.Warn("Error removing directory watcher", "directory", directory, "error", err)
+		}
+		delete(s.State.WatchBackends, directory)
+		slog.Info("Stopped watching directory", "directory", directory, "backend", backend)
+	}
+}
+
+// moveBackupDir switches to new settings with a different backup directory, loading its metadata and stat cache and
+// carrying pauses over. The settings are stored with the metadata, so backups saved with the previous settings can
+// tell their directory was replaced.
+func (s *Server) moveBackupDir(newSettings *types.AppSettings) {
+	backupDir := newSettings.BackupDir
+	metadataMap, err := io.LoadAllMetadata(backupDir)
+	if err != nil {
+		slog.Error("Error loading metadata from new backup directory", "dir", backupDir, "error", err)
+	}
+	if metadataMap == nil {
+		metadataMap = map[types.ConfigIdentifier]*types.ConfigMetadata{}
+	}
+
+	statCache, err := io.LoadStatCache(backupDir)
+	if err != nil {
+		slog.Warn("Unable to load stat cache from new backup directory, all files will be read", "error", err)
+	}
+
+	s.State.Mu.Lock()
+	s.State.CachedConfigMetadata = metadataMap
+	s.statCache.Store(statCache)
+	s.settings.Store(newSettings)
+	s.State.Mu.Unlock()
+
+	s.pauseMu.Lock()
+	if err := io.SavePauseState(backupDir, s.pauses); err != nil {
+		slog.Error("Error saving pause state", "error", err)
+	}
+	s.pauseMu.Unlock()
+}
+
+func cronSchedule(settings *types.AppSettings) string {
+	if settings.CronSchedule == nil {
+		return ""
+	}
+	return *settings.CronSchedule
+}
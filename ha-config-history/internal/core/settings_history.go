@@ -0,0 +1,95 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// ErrSettingsVersionNotFound is returned when a settings version doesn't exist
+var ErrSettingsVersionNotFound = errors.New("settings version not found")
+
+// recordSettingsVersion saves the current settings to the settings history and prunes old versions. Errors are only
+// logged, as the settings have already been applied. It must be called with the settings lock held.
+func (s *Server) recordSettingsVersion() {
+	settings := s.Settings()
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		slog.Error("Error serializing settings for history", "error", err)
+		return
+	}
+
+	filename, err := io.SaveSettingsVersion(settings.BackupDir, data, time.Now())
+	if err != nil {
+		slog.Error("Error saving settings version", "error", err)
+		return
+	}
+	if filename == "" {
+		return
+	}
+	slog.Info("Saved settings version", "filename", filename)
+
+	maxVersions, oldestAllowed := io.RetentionLimits(&types.ConfigBackupOptions{}, settings.DefaultMaxBackups,
+		settings.DefaultMaxBackupAgeDays)
+	if _, err := io.PruneSettingsVersions(settings.BackupDir, maxVersions, oldestAllowed); err != nil {
+		slog.Error("Error pruning settings versions", "error", err)
+	}
+}
+
+// SettingsVersions lists the saved versions of the settings, newest first
+func (s *Server) SettingsVersions() ([]io.BackupInfo, error) {
+	return io.ListSettingsVersions(s.Settings().BackupDir)
+}
+
+// SettingsVersion returns the contents of a saved settings version
+func (s *Server) SettingsVersion(filename string) ([]byte, error) {
+	content, err := io.GetConfigBackup(s.Settings().BackupDir, io.SettingsHistoryGroup, io.SettingsHistoryID, filename)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSettingsVersionNotFound, err)
+	}
+	return content, nil
+}
+
+// DiffSettingsVersions compares two saved settings versions
+func (s *Server) DiffSettingsVersions(leftFilename, rightFilename string, options DiffOptions) (*BackupDiff, error) {
+	return DiffBackups(s.Settings().BackupDir, io.SettingsHistoryGroup, io.SettingsHistoryID,
+		leftFilename, rightFilename, options)
+}
+
+// RollbackSettings saves and applies a previous settings version, which becomes the newest version. Versions from
+// older releases are migrated first. Default configs that have been offered since stay known, so they aren't offered
+// again. Invalid versions are not applied, and their validation is returned.
+func (s *Server) RollbackSettings(filename string) (SettingsChanges, SettingsValidation, error) {
+	content, err := s.SettingsVersion(filename)
+	if err != nil {
+		return SettingsChanges{}, SettingsValidation{}, err
+	}
+
+	settings, err := types.ParseConfig(content)
+	if err != nil {
+		return SettingsChanges{}, SettingsValidation{},
+			fmt.Errorf("failed to parse settings version %s: %w", filename, err)
+	}
+	for _, path := range s.Settings().KnownDefaultConfigs {
+		if !slices.Contains(settings.KnownDefaultConfigs, path) {
+			settings.KnownDefaultConfigs = append(settings.KnownDefaultConfigs, path)
+		}
+	}
+
+	validation := ValidateSettings(settings)
+	if !validation.Valid() {
+		return SettingsChanges{}, validation, nil
+	}
+
+	changes, err := s.SaveSettings(settings)
+	if err != nil {
+		return SettingsChanges{}, validation, err
+	}
+	slog.Info("Settings rolled back", "filename", filename)
+	return changes, validation, nil
+}
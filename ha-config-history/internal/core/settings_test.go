@@ -0,0 +1,327 @@
+package core_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/types"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApplySettings(t *testing.T) {
+	writeFile := func(t *testing.T, path string, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	newSettings := func(configDir, backupDir string, configs ...*types.ConfigBackupOptions) *types.AppSettings {
+		return &types.AppSettings{
+			HomeAssistantConfigDir: configDir,
+			BackupDir:              backupDir,
+			Port:                   ":40613",
+			Configs:                configs,
+		}
+	}
+	automations := func() *types.ConfigBackupOptions {
+		return types.NewMultipleConfigBackupOptions("Automations", "automations.yaml", "id", "alias")
+	}
+	scripts := func() *types.ConfigBackupOptions {
+		return types.NewMappingConfigBackupOptions("Scripts", "packages/scripts.yaml", "alias")
+	}
+
+	newServer := func(t *testing.T, settings *types.AppSettings) *core.Server {
+		writeFile(t, filepath.Join(settings.HomeAssistantConfigDir, "automations.yaml"), "- id: one\n  alias: One\n")
+		writeFile(t, filepath.Join(settings.HomeAssistantConfigDir, "packages/scripts.yaml"), "hello:\n  alias: Hello\n")
+
+		server := core.NewServer(settings)
+		server.Start()
+		t.Cleanup(server.Shutdown)
+		return server
+	}
+
+	waitForJob := func(t *testing.T, server *core.Server, id string) core.JobInfo {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			job, _ := server.GetJob(id)
+			if job.Status != core.JobStatusQueued && job.Status != core.JobStatusRunning {
+				return job
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("Timed out waiting for job to finish")
+		return core.JobInfo{}
+	}
+
+	hasMetadata := func(server *core.Server, identifier types.ConfigIdentifier) bool {
+		server.State.Mu.RLock()
+		defer server.State.Mu.RUnlock()
+		_, exists := server.State.CachedConfigMetadata[identifier]
+		return exists
+	}
+
+	t.Run("Added configs are scanned and removed configs stop being watched", func(t *testing.T) {
+		configDir := t.TempDir()
+		server := newServer(t, newSettings(configDir, t.TempDir(), automations()))
+		waitForJob(t, server, server.Jobs()[0].ID)
+
+		changes := server.ApplySettings(newSettings(configDir, server.Settings().BackupDir, automations(), scripts()))
+		if len(changes.Added) != 1 || changes.Added[0] != "packages/scripts.yaml" {
+			t.Fatalf("Expected scripts to be added, got: %v", changes.Added)
+		}
+		if len(changes.Changed) != 0 || len(changes.Removed) != 0 {
+			t.Fatalf("Expected no other changes, got: changed %v, removed %v", changes.Changed, changes.Removed)
+		}
+		if changes.Job == nil {
+			t.Fatal("Expected a job scanning the added config")
+		}
+
+		job := waitForJob(t, server, changes.Job.ID)
+		if job.Kind != core.JobKindSettings || job.Status != core.JobStatusCompleted || job.ItemsQueued != 1 {
+			t.Fatalf("Expected completed settings job with 1 item, got: %s %s %d", job.Kind, job.Status, job.ItemsQueued)
+		}
+		if !hasMetadata(server, types.ConfigIdentifier{ID: "hello", Group: "packages/scripts.yaml"}) {
+			t.Fatal("Expected added config to be backed up")
+		}
+		packagesDir := filepath.Join(configDir, "packages")
+		if _, watched := server.WatcherStatus()[packagesDir]; !watched {
+			t.Fatal("Expected added config's directory to be watched")
+		}
+
+		changes = server.ApplySettings(newSettings(configDir, server.Settings().BackupDir, automations()))
+		if len(changes.Removed) != 1 || changes.Removed[0] != "packages/scripts.yaml" || changes.Job != nil {
+			t.Fatalf("Expected scripts to be removed without a scan, got: %+v", changes)
+		}
+		if _, watched := server.WatcherStatus()[packagesDir]; watched {
+			t.Fatal("Expected removed config's directory to stop being watched")
+		}
+		if _, watched := server.WatcherStatus()[configDir]; !watched {
+			t.Fatal("Expected remaining config's directory to still be watched")
+		}
+	})
+
+	t.Run("Switching a directory to polling stops watching it with fsnotify", func(t *testing.T) {
+		configDir := t.TempDir()
+		server := newServer(t, newSettings(configDir, t.TempDir(), automations()))
+		waitForJob(t, server, server.Jobs()[0].ID)
+		if !slices.Contains(server.FsnotifyWatchList(), configDir) {
+			t.Fatalf("Expected the config directory to be watched with fsnotify, got: %v", server.FsnotifyWatchList())
+		}
+
+		writeFile(t, filepath.Join(configDir, "scenes.yaml"), "- id: evening\n  name: Evening\n")
+		scenes := types.NewMultipleConfigBackupOptions("Scenes", "scenes.yaml", "id", "name")
+		scenes.Watcher = core.WatcherBackendPoll
+		changes := server.ApplySettings(newSettings(configDir, server.Settings().BackupDir, automations(), scenes))
+		waitForJob(t, server, changes.Job.ID)
+
+		if backend := server.WatcherStatus()[configDir]; backend != core.WatcherBackendPoll {
+			t.Fatalf("Expected the config directory to be polled, got: %q", backend)
+		}
+		if slices.Contains(server.FsnotifyWatchList(), configDir) {
+			t.Fatalf("Expected the config directory to stop being watched with fsnotify, got: %v",
+				server.FsnotifyWatchList())
+		}
+
+		server.ApplySettings(newSettings(configDir, server.Settings().BackupDir))
+		if _, watched := server.WatcherStatus()[configDir]; watched {
+			t.Fatal("Expected the config directory to stop being watched")
+		}
+		if len(server.FsnotifyWatchList()) != 0 {
+			t.Errorf("Expected no directories watched with fsnotify, got: %v", server.FsnotifyWatchList())
+		}
+	})
+
+	t.Run("Changed configs are rescanned", func(t *testing.T) {
+		configDir := t.TempDir()
+		server := newServer(t, newSettings(configDir, t.TempDir(), automations()))
+		waitForJob(t, server, server.Jobs()[0].ID)
+
+		changed := automations()
+		changed.IgnoreKeys = []string{"alias"}
+		changes := server.ApplySettings(newSettings(configDir, server.Settings().BackupDir, changed))
+		if len(changes.Changed) != 1 || changes.Job == nil {
+			t.Fatalf("Expected automations to be changed and rescanned, got: %+v", changes)
+		}
+		if job := waitForJob(t, server, changes.Job.ID); job.Status != core.JobStatusCompleted {
+			t.Fatalf("Expected settings job to complete, got: %s %v", job.Status, job.Errors)
+		}
+
+		changes = server.ApplySettings(newSettings(configDir, server.Settings().BackupDir, changed))
+		if len(changes.Added)+len(changes.Changed)+len(changes.Removed) != 0 || changes.Job != nil {
+			t.Fatalf("Expected identical settings to change nothing, got: %+v", changes)
+		}
+	})
+
+	t.Run("Changing the backup directory rescans into it", func(t *testing.T) {
+		configDir := t.TempDir()
+		server := newServer(t, newSettings(configDir, t.TempDir(), automations()))
+		waitForJob(t, server, server.Jobs()[0].ID)
+
+		backupDir := t.TempDir()
+		changes := server.ApplySettings(newSettings(configDir, backupDir, automations()))
+		if len(changes.Changed) != 0 || changes.Job == nil {
+			t.Fatalf("Expected unchanged configs to be scanned into the new backup directory, got: %+v", changes)
+		}
+		if job := waitForJob(t, server, changes.Job.ID); job.VersionsCreated != 1 {
+			t.Fatalf("Expected a version in the new backup directory, got: %d", job.VersionsCreated)
+		}
+		if _, err := os.Stat(filepath.Join(backupDir, "automations.yaml")); err != nil {
+			t.Fatalf("Expected backups in the new backup directory, got: %v", err)
+		}
+	})
+
+	t.Run("Settings applied while a backup job is running", func(t *testing.T) {
+		configDir := t.TempDir()
+		server := newServer(t, newSettings(configDir, t.TempDir(), automations()))
+		waitForJob(t, server, server.Jobs()[0].ID)
+
+		var content strings.Builder
+		for i := range 200 {
+			fmt.Fprintf(&content, "- id: automation_%d\n  alias: Automation %d\n", i, i)
+		}
+		writeFile(t, filepath.Join(configDir, "automations.yaml"), content.String())
+		running := server.StartBackupJob(core.JobKindManual, false)
+
+		added := server.ApplySettings(newSettings(configDir, server.Settings().BackupDir, automations(), scripts()))
+		backupDir := t.TempDir()
+		moved := server.ApplySettings(newSettings(configDir, backupDir, automations(), scripts()))
+
+		waitForJob(t, server, running.Info().ID)
+		waitForJob(t, server, added.Job.ID)
+		if job := waitForJob(t, server, moved.Job.ID); job.Status != core.JobStatusCompleted {
+			t.Fatalf("Expected the rescan into the new backup directory to complete, got: %s %v", job.Status, job.Errors)
+		}
+
+		server.State.Mu.RLock()
+		defer server.State.Mu.RUnlock()
+		if len(server.State.CachedConfigMetadata) != 201 {
+			t.Fatalf("Expected metadata for 200 automations and a script, got: %d", len(server.State.CachedConfigMetadata))
+		}
+		for identifier := range server.State.CachedConfigMetadata {
+			if _, err := os.Stat(filepath.Join(backupDir, identifier.Group, identifier.ID)); err != nil {
+				t.Fatalf("Expected cached metadata to be from the new backup directory, got: %v", err)
+			}
+		}
+	})
+
+	t.Run("Settings file edits are applied", func(t *testing.T) {
+		configDir := t.TempDir()
+		server := newServer(t, newSettings(configDir, t.TempDir(), automations()))
+		waitForJob(t, server, server.Jobs()[0].ID)
+
+		settingsPath := filepath.Join(t.TempDir(), "config.json")
+		writeFile(t, settingsPath, "{}")
+		if err := server.WatchSettingsFile(settingsPath); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		writeFile(t, settingsPath, "{ invalid")
+		data, _ := json.Marshal(newSettings(configDir, server.Settings().BackupDir, automations(), scripts()))
+		writeFile(t, settingsPath, string(data))
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) && server.Jobs()[0].Kind != core.JobKindSettings {
+			time.Sleep(10 * time.Millisecond)
+		}
+		job := waitForJob(t, server, server.Jobs()[0].ID)
+		if job.Kind != core.JobKindSettings || job.ItemsQueued != 1 {
+			t.Fatalf("Expected settings file edit to scan the added config, got: %s %d", job.Kind, job.ItemsQueued)
+		}
+	})
+}
+
+func TestValidateSettings(t *testing.T) {
+	newSettings := func(t *testing.T, configs ...*types.ConfigBackupOptions) *types.AppSettings {
+		return &types.AppSettings{
+			HomeAssistantConfigDir: t.TempDir(),
+			BackupDir:              t.TempDir(),
+			Port:                   ":40613",
+			Configs:                configs,
+		}
+	}
+	fields := func(issues []core.SettingsIssue) []string {
+		result := []string{}
+		for _, issue := range issues {
+			result = append(result, issue.Field)
+		}
+		return result
+	}
+
+	t.Run("Valid settings", func(t *testing.T) {
+		validation := core.ValidateSettings(newSettings(t,
+			types.NewSingleConfigBackupOptions("Configuration", "configuration.yaml"),
+			types.NewMultipleConfigBackupOptions("Automations", "automations.yaml", "id", "alias"),
+		))
+		if !validation.Valid() || len(validation.Warnings) != 0 {
+			t.Fatalf("Expected no issues, got: %+v", validation)
+		}
+	})
+
+	t.Run("Duplicate config paths", func(t *testing.T) {
+		validation := core.ValidateSettings(newSettings(t,
+			types.NewSingleConfigBackupOptions("Configuration", "configuration.yaml"),
+			types.NewSingleConfigBackupOptions("Configuration again", "configuration.yaml"),
+		))
+		if diff := cmp.Diff([]string{"configs[1].path"}, fields(validation.Errors)); diff != "" {
+			t.Fatalf("Errors do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Invalid cron schedule and port", func(t *testing.T) {
+		schedule := "every day"
+		settings := newSettings(t)
+		settings.CronSchedule = &schedule
+		settings.Port = "40613"
+		validation := core.ValidateSettings(settings)
+		if diff := cmp.Diff([]string{"port", "cronSchedule"}, fields(validation.Errors)); diff != "" {
+			t.Fatalf("Errors do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Invalid config options are reported per field", func(t *testing.T) {
+		maxBackups := -1
+		automations := types.NewMultipleConfigBackupOptions("Automations", "automations.yaml", "", "alias")
+		automations.MaxBackups = &maxBackups
+		directory := types.NewSingleConfigBackupOptions("", "/etc/blueprints")
+		directory.BackupType = "folder"
+		directory.IncludeFilePatterns = []string{"*.yaml", "[invalid"}
+
+		validation := core.ValidateSettings(newSettings(t, automations, directory))
+		expectedErrors := []string{
+			"configs[0].idNode",
+			"configs[0].maxBackups",
+			"configs[1].path",
+			"configs[1].backupType",
+			"configs[1].includeFilePatterns[1]",
+		}
+		if diff := cmp.Diff(expectedErrors, fields(validation.Errors)); diff != "" {
+			t.Errorf("Errors do not match expected:\n%s", diff)
+		}
+		expectedWarnings := []string{"configs[1].name", "configs[1].includeFilePatterns"}
+		if diff := cmp.Diff(expectedWarnings, fields(validation.Warnings)); diff != "" {
+			t.Errorf("Warnings do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Missing directories are warnings", func(t *testing.T) {
+		settings := newSettings(t)
+		settings.BackupDir = filepath.Join(settings.BackupDir, "missing")
+		validation := core.ValidateSettings(settings)
+		if !validation.Valid() {
+			t.Fatalf("Expected no errors, got: %+v", validation.Errors)
+		}
+		if diff := cmp.Diff([]string{"backupDir"}, fields(validation.Warnings)); diff != "" {
+			t.Fatalf("Warnings do not match expected:\n%s", diff)
+		}
+	})
+}
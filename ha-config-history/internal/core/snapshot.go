@@ -0,0 +1,179 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"sort"
+	"time"
+)
+
+const (
+	SnapshotChangeAdded    = "added"
+	SnapshotChangeRemoved  = "removed"
+	SnapshotChangeModified = "modified"
+)
+
+// ErrInvalidSnapshotRange is returned when the start of a snapshot diff isn't before its end
+var ErrInvalidSnapshotRange = errors.New("from must be before to")
+
+// SnapshotChange is a config item that was added, removed or modified between two snapshots
+type SnapshotChange struct {
+	types.ConfigIdentifier
+	FriendlyName string `json:"friendlyName"`
+	// Type is SnapshotChangeAdded, SnapshotChangeRemoved or SnapshotChangeModified
+	Type string `json:"type"`
+	// OldFilename and NewFilename are the versions in effect at each time, empty when the item had no version.
+	// NewFilename is LiveFilename when comparing with the live config.
+	OldFilename string `json:"oldFilename,omitempty"`
+	NewFilename string `json:"newFilename,omitempty"`
+	// Diff is the change to the item, when diffs are requested
+	Diff *BackupDiff `json:"diff,omitempty"`
+}
+
+// SnapshotDiff is the changeset between the configs at two points in time
+type SnapshotDiff struct {
+	From time.Time `json:"from"`
+	// To is nil when comparing with the live config
+	To      *time.Time       `json:"to,omitempty"`
+	Changes []SnapshotChange `json:"changes"`
+}
+
+// DiffSnapshots compares every config at two points in time, eg. Friday evening and Saturday morning. The version
+// of an item in effect at a time is the last backup saved at or before it, unless the item was deleted since.
+//
+// Deletions are recorded when a scan or file change finds an item missing, so items deleted before that was
+// recorded are never removed between two past times. With a nil to, items are compared with the live config instead,
+// and items that no longer exist are removed. Items whose backups from before from were pruned are reported as added.
+func (s *Server) DiffSnapshots(from time.Time, to *time.Time, includeDiffs bool, options DiffOptions) (*SnapshotDiff,
+	error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if to != nil && !from.Before(*to) {
+		return nil, ErrInvalidSnapshotRange
+	}
+
+	s.State.Mu.RLock()
+	friendlyNames := make(map[types.ConfigIdentifier]string, len(s.State.CachedConfigMetadata))
+	deletions := make(map[types.ConfigIdentifier][]time.Time, len(s.State.CachedConfigMetadata))
+	identifiers := make([]types.ConfigIdentifier, 0, len(s.State.CachedConfigMetadata))
+	for identifier, metadata := range s.State.CachedConfigMetadata {
+		friendlyNames[identifier] = metadata.FriendlyName
+		deletions[identifier] = metadata.Deletions
+		identifiers = append(identifiers, identifier)
+	}
+	s.State.Mu.RUnlock()
+
+	sort.Slice(identifiers, func(i, j int) bool {
+		if identifiers[i].Group != identifiers[j].Group {
+			return identifiers[i].Group < identifiers[j].Group
+		}
+		return identifiers[i].ID < identifiers[j].ID
+	})
+
+	diff := &SnapshotDiff{From: from, To: to, Changes: []SnapshotChange{}}
+	live := liveItems{}
+	for _, identifier := range identifiers {
+		backups, err := io.ListConfigBackups(s.Settings().BackupDir, identifier.Group, identifier.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		left := ConfigVersion{ConfigIdentifier: identifier, Filename: versionAt(backups, deletions[identifier], from)}
+		right := ConfigVersion{ConfigIdentifier: identifier, Filename: LiveFilename}
+		if to != nil {
+			right.Filename = versionAt(backups, deletions[identifier], *to)
+		} else if s.FindConfigOptions(identifier.Group) == nil && len(backups) > 0 {
+			// Configs no longer in the settings can't be read live, so their latest backup is current
+			right.Filename = backups[0].Filename
+		}
+
+		change, err := s.snapshotChange(left, right, includeDiffs, options, live)
+		if err != nil {
+			return nil, err
+		}
+		if change != nil {
+			change.FriendlyName = friendlyNames[identifier]
+			diff.Changes = append(diff.Changes, *change)
+		}
+	}
+	return diff, nil
+}
+
+// versionAt returns the filename of the last backup saved at or before a time, or "" when there's none or the item
+// was deleted after it by then. Backups are sorted newest first.
+func versionAt(backups []io.BackupInfo, deletions []time.Time, at time.Time) string {
+	for _, backup := range backups {
+		if backup.Date.After(at) {
+			continue
+		}
+		for _, deletion := range deletions {
+			if deletion.After(backup.Date) && !deletion.After(at) {
+				return ""
+			}
+		}
+		return backup.Filename
+	}
+	return ""
+}
+
+// snapshotChange compares the versions of an item at two times, returning nil when it's unchanged. An empty
+// filename means the item had no version at that time.
+func (s *Server) snapshotChange(
+	left, right ConfigVersion,
+	includeDiffs bool,
+	options DiffOptions,
+	live liveItems,
+) (*SnapshotChange, error) {
+	if left.Filename == right.Filename {
+		return nil, nil
+	}
+
+	leftInput, leftMissing, err := s.readSnapshotInput(left, "left", live)
+	if err != nil {
+		return nil, err
+	}
+	rightInput, rightMissing, err := s.readSnapshotInput(right, "right", live)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &SnapshotChange{ConfigIdentifier: left.ConfigIdentifier, Type: SnapshotChangeModified,
+		OldFilename: left.Filename, NewFilename: right.Filename}
+	switch {
+	case leftMissing && rightMissing:
+		return nil, nil
+	case leftMissing:
+		change.Type = SnapshotChangeAdded
+	case rightMissing:
+		change.Type = SnapshotChangeRemoved
+	case sameContent(leftInput, rightInput):
+		return nil, nil
+	}
+
+	if includeDiffs {
+		if change.Diff, err = diffInputs(leftInput, rightInput, options); err != nil {
+			return nil, err
+		}
+		change.Diff.LiveMissing = right.Filename == LiveFilename && rightMissing
+	}
+	return change, nil
+}
+
+// readSnapshotInput reads a version to be compared, reporting whether the item had no version, or no longer exists
+func (s *Server) readSnapshotInput(version ConfigVersion, side string, live liveItems) (diffInput, bool, error) {
+	if version.Filename == "" {
+		return diffInput{label: "/dev/null"}, true, nil
+	}
+	return s.readVersionInput(version, side, live)
+}
+
+// sameContent reports whether two inputs have the same content, comparing binary inputs by hash
+func sameContent(left, right diffInput) bool {
+	if left.binary || right.binary {
+		return left.binary && right.binary && left.hash == right.hash
+	}
+	return bytes.Equal(left.content, right.content)
+}
@@ -0,0 +1,240 @@
+package core_test
+
+import (
+	"encoding/json"
+	"ha-config-history/internal/core"
+	"ha-config-history/internal/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	writeFile := func(t *testing.T, path, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	// seed writes backups of a config item and its metadata, backups maps filenames to content and deletions are the
+	// times the item was found missing
+	seed := func(t *testing.T, backupDir, group, id string, backups map[string]string, deletions ...time.Time) {
+		configDir := filepath.Join(backupDir, group, id)
+		for filename, content := range backups {
+			writeFile(t, filepath.Join(configDir, filename), content)
+		}
+		metadata := types.ConfigMetadata{
+			ConfigIdentifier: types.ConfigIdentifier{Group: group, ID: id},
+			FriendlyName:     strings.ToUpper(id[:1]) + id[1:],
+			BackupCount:      len(backups),
+			BackupType:       "multiple",
+			Deleted:          len(deletions) > 0,
+			Deletions:        deletions,
+		}
+		data, _ := json.Marshal(metadata)
+		writeFile(t, filepath.Join(configDir, "metadata.json"), string(data))
+	}
+
+	// setup tracks automations modified, added, unchanged and deleted around Friday evening and Saturday morning
+	setup := func(t *testing.T) *core.Server {
+		settings := &types.AppSettings{
+			HomeAssistantConfigDir: t.TempDir(),
+			BackupDir:              t.TempDir(),
+			Configs: []*types.ConfigBackupOptions{
+				types.NewMultipleConfigBackupOptions("Automations", "automations.yaml", "id", "alias"),
+			},
+		}
+		seed(t, settings.BackupDir, "automations.yaml", "one", map[string]string{
+			"20240105T180000.backup": "id: one\nalias: One\n",
+			"20240106T090000.backup": "id: one\nalias: One again\n",
+		})
+		seed(t, settings.BackupDir, "automations.yaml", "two", map[string]string{
+			"20240106T080000.backup": "id: two\nalias: Two\n",
+		})
+		seed(t, settings.BackupDir, "automations.yaml", "three", map[string]string{
+			"20240101T000000.backup": "id: three\nalias: Three\n",
+		})
+		seed(t, settings.BackupDir, "automations.yaml", "four", map[string]string{
+			"20240105T120000.backup": "id: four\nalias: Four\n",
+		}, time.Date(2024, 1, 6, 7, 0, 0, 0, time.UTC))
+		writeFile(t, filepath.Join(settings.HomeAssistantConfigDir, "automations.yaml"),
+			"- id: one\n  alias: One again\n- id: two\n  alias: Two\n- id: three\n  alias: Three\n")
+
+		server := core.NewServer(settings)
+		t.Cleanup(server.Shutdown)
+		return server
+	}
+
+	friday := time.Date(2024, 1, 5, 20, 0, 0, 0, time.UTC)
+	saturday := time.Date(2024, 1, 6, 10, 0, 0, 0, time.UTC)
+	identifier := func(id string) types.ConfigIdentifier {
+		return types.ConfigIdentifier{Group: "automations.yaml", ID: id}
+	}
+
+	t.Run("Changes between two times", func(t *testing.T) {
+		server := setup(t)
+
+		result, err := server.DiffSnapshots(friday, &saturday, false, core.DiffOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		expected := []core.SnapshotChange{
+			{
+				ConfigIdentifier: identifier("four"),
+				FriendlyName:     "Four",
+				Type:             core.SnapshotChangeRemoved,
+				OldFilename:      "20240105T120000.backup",
+			},
+			{
+				ConfigIdentifier: identifier("one"),
+				FriendlyName:     "One",
+				Type:             core.SnapshotChangeModified,
+				OldFilename:      "20240105T180000.backup",
+				NewFilename:      "20240106T090000.backup",
+			},
+			{
+				ConfigIdentifier: identifier("two"),
+				FriendlyName:     "Two",
+				Type:             core.SnapshotChangeAdded,
+				NewFilename:      "20240106T080000.backup",
+			},
+		}
+		if diff := cmp.Diff(expected, result.Changes); diff != "" {
+			t.Errorf("Changes do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Changes since a time, compared with the live config", func(t *testing.T) {
+		server := setup(t)
+
+		result, err := server.DiffSnapshots(friday, nil, false, core.DiffOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		expected := []core.SnapshotChange{
+			{
+				ConfigIdentifier: identifier("four"),
+				FriendlyName:     "Four",
+				Type:             core.SnapshotChangeRemoved,
+				OldFilename:      "20240105T120000.backup",
+				NewFilename:      core.LiveFilename,
+			},
+			{
+				ConfigIdentifier: identifier("one"),
+				FriendlyName:     "One",
+				Type:             core.SnapshotChangeModified,
+				OldFilename:      "20240105T180000.backup",
+				NewFilename:      core.LiveFilename,
+			},
+			{
+				ConfigIdentifier: identifier("two"),
+				FriendlyName:     "Two",
+				Type:             core.SnapshotChangeAdded,
+				NewFilename:      core.LiveFilename,
+			},
+		}
+		if diff := cmp.Diff(expected, result.Changes); diff != "" {
+			t.Errorf("Changes do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Diffs are included on request", func(t *testing.T) {
+		server := setup(t)
+
+		result, err := server.DiffSnapshots(friday, nil, true, core.DiffOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(result.Changes) != 3 {
+			t.Fatalf("Expected 3 changes, got: %+v", result.Changes)
+		}
+
+		removed, modified, added := result.Changes[0].Diff, result.Changes[1].Diff, result.Changes[2].Diff
+		if !removed.LiveMissing || !strings.Contains(removed.UnifiedDiff, "-alias: Four") {
+			t.Errorf("Expected the removed automation to be diffed against empty content, got: %+v", removed)
+		}
+		if !strings.Contains(modified.UnifiedDiff, "-alias: One\n") ||
+			!strings.Contains(modified.UnifiedDiff, "+alias: One again\n") {
+			t.Errorf("Expected the modified alias in the diff, got:\n%s", modified.UnifiedDiff)
+		}
+		if !strings.Contains(added.UnifiedDiff, "--- /dev/null") || !strings.Contains(added.UnifiedDiff, "+alias: Two") {
+			t.Errorf("Expected the added automation to be diffed against empty content, got:\n%s", added.UnifiedDiff)
+		}
+	})
+
+	t.Run("Items deleted from a config are removed between two times", func(t *testing.T) {
+		configDir := t.TempDir()
+		writeFile(t, filepath.Join(configDir, "automations.yaml"), "- id: one\n  alias: One\n- id: two\n  alias: Two\n")
+		backupDir := t.TempDir()
+		server := core.NewServer(&types.AppSettings{
+			HomeAssistantConfigDir: configDir,
+			BackupDir:              backupDir,
+			Configs: []*types.ConfigBackupOptions{
+				types.NewMultipleConfigBackupOptions("Automations", "automations.yaml", "id", "alias"),
+			},
+		})
+		server.Start()
+		t.Cleanup(server.Shutdown)
+
+		server.ProcessAllConfigOptions(true)
+		before := time.Now().UTC()
+
+		writeFile(t, filepath.Join(configDir, "automations.yaml"), "- id: one\n  alias: One\n")
+		server.ProcessAllConfigOptions(false)
+		after := time.Now().UTC()
+
+		result, err := server.DiffSnapshots(before, &after, false, core.DiffOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(result.Changes) != 1 || result.Changes[0].ID != "two" ||
+			result.Changes[0].Type != core.SnapshotChangeRemoved {
+			t.Fatalf("Expected the deleted automation to be removed, got: %+v", result.Changes)
+		}
+
+		writeFile(t, filepath.Join(configDir, "automations.yaml"), "- id: one\n  alias: One\n- id: two\n  alias: Two\n")
+		server.ProcessAllConfigOptions(false)
+		data, err := os.ReadFile(filepath.Join(backupDir, "automations.yaml", "two", "metadata.json"))
+		if err != nil {
+			t.Fatalf("Failed to read metadata: %v", err)
+		}
+		var metadata types.ConfigMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			t.Fatalf("Failed to parse metadata: %v", err)
+		}
+		if metadata.Deleted || len(metadata.Deletions) != 1 {
+			t.Errorf("Expected the restored automation to keep its deletion and no longer be deleted, got: %+v", metadata)
+		}
+	})
+
+	t.Run("No changes before the first backup", func(t *testing.T) {
+		server := setup(t)
+
+		before := time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+		after := time.Date(2023, 12, 2, 0, 0, 0, 0, time.UTC)
+		result, err := server.DiffSnapshots(before, &after, false, core.DiffOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(result.Changes) != 0 {
+			t.Errorf("Expected no changes, got: %+v", result.Changes)
+		}
+	})
+
+	t.Run("Invalid range", func(t *testing.T) {
+		server := setup(t)
+
+		if _, err := server.DiffSnapshots(saturday, &friday, false, core.DiffOptions{}); err == nil {
+			t.Error("Expected an error when from is after to")
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"log/slog"
+)
+
+// fileUnchanged reports whether a file is the same as when its backups were last brought up to date, so it doesn't
+// need to be read. A file whose stat changed but whose content hashes the same (eg. after a touch) is also skipped.
+// The file's current stat is staged on the job, and saved once the job completes.
+func (s *Server) fileUnchanged(path string, options *types.ConfigBackupOptions, job *Job) bool {
+	if job == nil {
+		return false
+	}
+
+	stat, err := io.StatFile(path)
+	if err != nil {
+		return false
+	}
+
+	optionsHash := io.OptionsHash(options)
+	cached, exists := job.statCache.Get(path)
+	cacheValid := exists && !job.fullRehash && cached.OptionsHash == optionsHash
+
+	if !cacheValid || cached.FileStat != stat {
+		hash, err := io.HashFile(path)
+		if err != nil {
+			return false
+		}
+		job.stageStat(path, io.StatCacheEntry{
+			FileStat:    stat,
+			Group:       options.Path,
+			Hash:        hash,
+			OptionsHash: optionsHash,
+		})
+		if !cacheValid || cached.Hash != hash {
+			return false
+		}
+	}
+
+	slog.Debug("File unchanged since last scan, skipping", "path", path)
+	job.fileSkipped()
+	return true
+}
+
+func updateStatCache(statCache *io.StatCache, stats map[string]io.StatCacheEntry) {
+	if err := statCache.Update(stats); err != nil {
+		slog.Error("Error saving stat cache", "error", err)
+	}
+}
+
+// InvalidateStatCache forces the files of a config to be read on the next scan, eg. after its backups are deleted
+func (s *Server) InvalidateStatCache(group string) {
+	if err := s.statCache.Load().InvalidateGroup(group); err != nil {
+		slog.Error("Error saving stat cache", "error", err)
+	}
+}
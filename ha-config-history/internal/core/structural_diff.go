@@ -0,0 +1,524 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ha-config-history/internal/io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrStructuralDiffUnsupported is returned when a backup isn't YAML or JSON, so can't be compared structurally
+var ErrStructuralDiffUnsupported = errors.New("backups can't be compared structurally")
+
+const (
+	StructuralChangeAdded   = "added"
+	StructuralChangeRemoved = "removed"
+	StructuralChangeChanged = "changed"
+)
+
+// sequenceMatchThreshold is how similar two sequence elements must be to be compared with each other, rather than
+// one being removed and the other added
+const sequenceMatchThreshold = 0.5
+
+// maxAlignmentCells limits the pairs of elements compared when aligning two sequences by similarity. Longer sequences
+// are matched by position, like sequences aligned after the time budget runs out.
+const maxAlignmentCells = 1 << 20
+
+// StructuralChange is a change to a value in a YAML or JSON document. Paths use the index of removed sequence
+// elements in the old document, and the index of added and changed elements in the new one.
+type StructuralChange struct {
+	Type     string `json:"type"`
+	Path     string `json:"path"`
+	OldValue any    `json:"oldValue,omitempty"`
+	NewValue any    `json:"newValue,omitempty"`
+}
+
+// String describes the change, eg. "trigger[1].entity_id changed from light.a to light.b"
+func (c StructuralChange) String() string {
+	path := c.Path
+	if path == "" {
+		path = "document"
+	}
+	switch c.Type {
+	case StructuralChangeAdded:
+		return fmt.Sprintf("%s added: %s", path, formatStructuralValue(c.NewValue))
+	case StructuralChangeRemoved:
+		return fmt.Sprintf("%s removed: %s", path, formatStructuralValue(c.OldValue))
+	default:
+		return fmt.Sprintf("%s changed from %s to %s", path,
+			formatStructuralValue(c.OldValue), formatStructuralValue(c.NewValue))
+	}
+}
+
+func formatStructuralValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case nil:
+		return "null"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+type StructuralDiff struct {
+	OldFilename string             `json:"oldFilename"`
+	NewFilename string             `json:"newFilename"`
+	Changes     []StructuralChange `json:"changes"`
+	// Approximate is set when sequences were too long to align, or the time budget ran out, so some sequence elements
+	// were matched by position rather than similarity
+	Approximate bool `json:"approximate,omitempty"`
+}
+
+// DiffBackupsStructurally compares two backups of a config value by value. Backups that are binary, larger than
+// options.MaxSize or aren't valid YAML or JSON return ErrStructuralDiffUnsupported.
+func DiffBackupsStructurally(
+	backupFolder, group, id, leftFilename, rightFilename string, options DiffOptions,
+) (*StructuralDiff, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	leftContent, err := readStructuredBackup(backupFolder, group, id, leftFilename, "left", options.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+	rightContent, err := readStructuredBackup(backupFolder, group, id, rightFilename, "right", options.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, approximate, err := DiffStructure(leftContent, rightContent, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StructuralDiff{
+		OldFilename: leftFilename,
+		NewFilename: rightFilename,
+		Changes:     changes,
+		Approximate: approximate,
+	}, nil
+}
+
+func readStructuredBackup(backupFolder, group, id, filename, side string, maxSize int64) ([]byte, error) {
+	path, err := io.GetConfigBackupPath(backupFolder, group, id, filename)
+	if err != nil {
+		return nil, &BackupNotFoundError{Side: side, Err: err}
+	}
+	binary, size, err := io.SniffFile(path)
+	if err != nil {
+		return nil, &BackupNotFoundError{Side: side, Err: err}
+	}
+	if binary {
+		return nil, fmt.Errorf("%w: %s backup is binary", ErrStructuralDiffUnsupported, side)
+	}
+	if size > maxSize {
+		return nil, fmt.Errorf("%w: %s backup is too large (%s)", ErrStructuralDiffUnsupported, side, FormatSize(size))
+	}
+
+	content, err := io.GetConfigBackup(backupFolder, group, id, filename)
+	if err != nil {
+		return nil, &BackupNotFoundError{Side: side, Err: err}
+	}
+	return content, nil
+}
+
+// DiffStructure compares two YAML or JSON documents, returning the values that were added, removed or changed.
+// Key order and formatting are ignored. Sequence elements are matched to the most similar element on the other side,
+// keeping their order, so an edited list item is reported as changes within it rather than as a replacement.
+//
+// Documents larger than options.MaxSize return ErrStructuralDiffUnsupported. Sequences too long to align, and those
+// reached after options.Timeout, are matched by position instead, and the diff is reported as approximate.
+func DiffStructure(left, right []byte, options DiffOptions) ([]StructuralChange, bool, error) {
+	if err := options.Validate(); err != nil {
+		return nil, false, err
+	}
+	for _, document := range []struct {
+		side    string
+		content []byte
+	}{{"left", left}, {"right", right}} {
+		if size := int64(len(document.content)); size > options.MaxSize {
+			return nil, false, fmt.Errorf("%w: %s backup is too large (%s)", ErrStructuralDiffUnsupported,
+				document.side, FormatSize(size))
+		}
+	}
+
+	leftNode, err := parseStructure(left)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: left backup: %v", ErrStructuralDiffUnsupported, err)
+	}
+	rightNode, err := parseStructure(right)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: right backup: %v", ErrStructuralDiffUnsupported, err)
+	}
+
+	differ := &structuralDiffer{deadline: time.Now().Add(options.Timeout)}
+	changes := []StructuralChange{}
+	differ.diffNodes("", leftNode, rightNode, &changes)
+	return changes, differ.approximate, nil
+}
+
+// structuralDiffer compares documents within a time budget, recording when sequences had to be matched by position
+type structuralDiffer struct {
+	deadline    time.Time
+	approximate bool
+}
+
+// parseStructure parses a YAML or JSON document, returning nil for an empty document
+func parseStructure(content []byte) (*yaml.Node, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+	return resolveAlias(document.Content[0]), nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+func (d *structuralDiffer) diffNodes(path string, left, right *yaml.Node, changes *[]StructuralChange) {
+	switch {
+	case nodesEqual(left, right):
+		return
+	case left == nil:
+		*changes = append(*changes, StructuralChange{Type: StructuralChangeAdded, Path: path, NewValue: nodeValue(right)})
+	case right == nil:
+		*changes = append(*changes, StructuralChange{Type: StructuralChangeRemoved, Path: path, OldValue: nodeValue(left)})
+	case left.Kind == yaml.MappingNode && right.Kind == yaml.MappingNode:
+		d.diffMappings(path, left, right, changes)
+	case left.Kind == yaml.SequenceNode && right.Kind == yaml.SequenceNode:
+		d.diffSequences(path, left, right, changes)
+	default:
+		*changes = append(*changes, StructuralChange{
+			Type:     StructuralChangeChanged,
+			Path:     path,
+			OldValue: nodeValue(left),
+			NewValue: nodeValue(right),
+		})
+	}
+}
+
+func (d *structuralDiffer) diffMappings(path string, left, right *yaml.Node, changes *[]StructuralChange) {
+	rightValues := mappingValues(right)
+	leftValues := mappingValues(left)
+
+	for i := 0; i+1 < len(left.Content); i += 2 {
+		key := left.Content[i].Value
+		if leftValues[key] != resolveAlias(left.Content[i+1]) {
+			// Duplicate key, only the first is compared
+			continue
+		}
+		keyPath := joinKeyPath(path, key)
+		rightValue, exists := rightValues[key]
+		if !exists {
+			*changes = append(*changes, StructuralChange{
+				Type:     StructuralChangeRemoved,
+				Path:     keyPath,
+				OldValue: nodeValue(leftValues[key]),
+			})
+			continue
+		}
+		d.diffNodes(keyPath, leftValues[key], rightValue, changes)
+	}
+
+	for i := 0; i+1 < len(right.Content); i += 2 {
+		key := right.Content[i].Value
+		if _, exists := leftValues[key]; exists || rightValues[key] != resolveAlias(right.Content[i+1]) {
+			continue
+		}
+		*changes = append(*changes, StructuralChange{
+			Type:     StructuralChangeAdded,
+			Path:     joinKeyPath(path, key),
+			NewValue: nodeValue(rightValues[key]),
+		})
+	}
+}
+
+// mappingValues indexes a mapping's values by key, keeping the first value of duplicate keys
+func mappingValues(node *yaml.Node) map[string]*yaml.Node {
+	values := make(map[string]*yaml.Node, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if _, exists := values[node.Content[i].Value]; !exists {
+			values[node.Content[i].Value] = resolveAlias(node.Content[i+1])
+		}
+	}
+	return values
+}
+
+func (d *structuralDiffer) diffSequences(path string, left, right *yaml.Node, changes *[]StructuralChange) {
+	leftItems := resolveAll(left.Content)
+	rightItems := resolveAll(right.Content)
+	pairs := d.alignSequences(leftItems, rightItems)
+
+	// Unmatched elements between two matched pairs are a gap. Gaps of scalars with the same number of elements on
+	// each side are reported as changed values, eg. a replaced entity ID.
+	leftNext, rightNext := 0, 0
+	flushGap := func(leftEnd, rightEnd int) {
+		leftGap, rightGap := leftItems[leftNext:leftEnd], rightItems[rightNext:rightEnd]
+		if len(leftGap) == len(rightGap) && allScalars(leftGap) && allScalars(rightGap) {
+			for i := range leftGap {
+				d.diffNodes(indexPath(path, rightNext+i), leftGap[i], rightGap[i], changes)
+			}
+			return
+		}
+		for i, item := range leftGap {
+			*changes = append(*changes, StructuralChange{
+				Type:     StructuralChangeRemoved,
+				Path:     indexPath(path, leftNext+i),
+				OldValue: nodeValue(item),
+			})
+		}
+		for i, item := range rightGap {
+			*changes = append(*changes, StructuralChange{
+				Type:     StructuralChangeAdded,
+				Path:     indexPath(path, rightNext+i),
+				NewValue: nodeValue(item),
+			})
+		}
+	}
+
+	for _, pair := range pairs {
+		flushGap(pair[0], pair[1])
+		d.diffNodes(indexPath(path, pair[1]), leftItems[pair[0]], rightItems[pair[1]], changes)
+		leftNext, rightNext = pair[0]+1, pair[1]+1
+	}
+	flushGap(len(leftItems), len(rightItems))
+}
+
+// alignSequences matches elements of two sequences in order, maximising the total similarity of the matched pairs.
+// It returns the matched left and right indexes. Sequences too long to align, or reached after the deadline, are
+// matched by position.
+func (d *structuralDiffer) alignSequences(left, right []*yaml.Node) [][2]int {
+	if len(left)*len(right) > maxAlignmentCells || time.Now().After(d.deadline) {
+		return d.alignPositionally(left, right)
+	}
+
+	leftLeaves := make([]map[string]string, len(left))
+	for i, node := range left {
+		leftLeaves[i] = nodeLeaves(node)
+	}
+	rightLeaves := make([]map[string]string, len(right))
+	for i, node := range right {
+		rightLeaves[i] = nodeLeaves(node)
+	}
+
+	similarity := make([][]float64, len(left))
+	for i := range left {
+		if time.Now().After(d.deadline) {
+			return d.alignPositionally(left, right)
+		}
+		similarity[i] = make([]float64, len(right))
+		for j := range right {
+			similarity[i][j] = nodeSimilarity(left[i], right[j], leftLeaves[i], rightLeaves[j])
+		}
+	}
+
+	// scores[i][j] is the best total similarity aligning left[i:] with right[j:]
+	scores := make([][]float64, len(left)+1)
+	for i := range scores {
+		scores[i] = make([]float64, len(right)+1)
+	}
+	for i := len(left) - 1; i >= 0; i-- {
+		for j := len(right) - 1; j >= 0; j-- {
+			best := max(scores[i+1][j], scores[i][j+1])
+			if similarity[i][j] >= sequenceMatchThreshold {
+				best = max(best, scores[i+1][j+1]+similarity[i][j])
+			}
+			scores[i][j] = best
+		}
+	}
+
+	pairs := [][2]int{}
+	for i, j := 0, 0; i < len(left) && j < len(right); {
+		switch {
+		case similarity[i][j] >= sequenceMatchThreshold && scores[i][j] == scores[i+1][j+1]+similarity[i][j]:
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		case scores[i][j] == scores[i+1][j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return pairs
+}
+
+// alignPositionally matches elements with the same index, marking the diff as approximate
+func (d *structuralDiffer) alignPositionally(left, right []*yaml.Node) [][2]int {
+	d.approximate = true
+	pairs := make([][2]int, min(len(left), len(right)))
+	for i := range pairs {
+		pairs[i] = [2]int{i, i}
+	}
+	return pairs
+}
+
+// nodeSimilarity scores two nodes from 0 to 1 by the share of their leaf values that are the same
+func nodeSimilarity(left, right *yaml.Node, leftLeaves, rightLeaves map[string]string) float64 {
+	if nodesEqual(left, right) {
+		return 1
+	}
+	if left.Kind != right.Kind || left.Kind == yaml.ScalarNode {
+		return 0
+	}
+
+	common := 0
+	for path, value := range leftLeaves {
+		if rightValue, exists := rightLeaves[path]; exists && rightValue == value {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(leftLeaves)+len(rightLeaves))
+}
+
+// nodeLeaves flattens a node to its scalar values by path
+func nodeLeaves(node *yaml.Node) map[string]string {
+	leaves := map[string]string{}
+	var walk func(path string, node *yaml.Node)
+	walk = func(path string, node *yaml.Node) {
+		node = resolveAlias(node)
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				walk(joinKeyPath(path, node.Content[i].Value), node.Content[i+1])
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				walk(indexPath(path, i), item)
+			}
+		default:
+			leaves[path] = scalarKey(node)
+		}
+	}
+	walk("", node)
+	return leaves
+}
+
+// nodesEqual compares nodes by value, ignoring mapping key order, comments and formatting
+func nodesEqual(left, right *yaml.Node) bool {
+	left, right = resolveAlias(left), resolveAlias(right)
+	if left == nil || right == nil {
+		return left == right
+	}
+	if left.Kind != right.Kind {
+		return false
+	}
+
+	switch left.Kind {
+	case yaml.MappingNode:
+		leftValues, rightValues := mappingValues(left), mappingValues(right)
+		if len(leftValues) != len(rightValues) {
+			return false
+		}
+		for key, value := range leftValues {
+			rightValue, exists := rightValues[key]
+			if !exists || !nodesEqual(value, rightValue) {
+				return false
+			}
+		}
+		return true
+	case yaml.SequenceNode:
+		if len(left.Content) != len(right.Content) {
+			return false
+		}
+		for i := range left.Content {
+			if !nodesEqual(left.Content[i], right.Content[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return scalarKey(left) == scalarKey(right)
+	}
+}
+
+// scalarKey identifies a scalar by its resolved tag and value, so 1 and "1" differ but 'a' and "a" don't
+func scalarKey(node *yaml.Node) string {
+	return node.ShortTag() + " " + node.Value
+}
+
+// nodeValue converts a node to a value for JSON. Scalars with custom tags keep their tag, eg. "!secret api_key".
+func nodeValue(node *yaml.Node) any {
+	node = resolveAlias(node)
+	if node == nil {
+		return nil
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		values := make(map[string]any, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if _, exists := values[node.Content[i].Value]; !exists {
+				values[node.Content[i].Value] = nodeValue(node.Content[i+1])
+			}
+		}
+		return values
+	case yaml.SequenceNode:
+		values := make([]any, 0, len(node.Content))
+		for _, item := range node.Content {
+			values = append(values, nodeValue(item))
+		}
+		return values
+	}
+
+	if strings.HasPrefix(node.Tag, "!") && !strings.HasPrefix(node.Tag, "!!") {
+		return node.Tag + " " + node.Value
+	}
+	var value any
+	if err := node.Decode(&value); err != nil {
+		return node.Value
+	}
+	return value
+}
+
+func resolveAll(nodes []*yaml.Node) []*yaml.Node {
+	resolved := make([]*yaml.Node, len(nodes))
+	for i, node := range nodes {
+		resolved[i] = resolveAlias(node)
+	}
+	return resolved
+}
+
+func allScalars(nodes []*yaml.Node) bool {
+	for _, node := range nodes {
+		if node.Kind != yaml.ScalarNode {
+			return false
+		}
+	}
+	return true
+}
+
+var plainKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// joinKeyPath appends a mapping key to a path, quoting keys that aren't plain identifiers, eg. `sensor["a.b"]`
+func joinKeyPath(path, key string) string {
+	if !plainKeyPattern.MatchString(key) {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func indexPath(path string, index int) string {
+	return path + "[" + strconv.Itoa(index) + "]"
+}
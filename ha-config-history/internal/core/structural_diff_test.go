@@ -0,0 +1,238 @@
+package core_test
+
+import (
+	"errors"
+	"fmt"
+	"ha-config-history/internal/core"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffStructure(t *testing.T) {
+	tests := []struct {
+		name     string
+		left     string
+		right    string
+		expected []core.StructuralChange
+	}{
+		{
+			name: "Changed value in a sequence element",
+			left: `id: morning
+alias: Morning
+trigger:
+  - platform: time
+    at: "07:00:00"
+  - platform: state
+    entity_id: binary_sensor.motion
+    to: "on"
+action:
+  - service: light.turn_on
+    target:
+      entity_id: light.kitchen
+`,
+			right: `id: morning
+alias: Morning
+trigger:
+  - platform: time
+    at: "07:00:00"
+  - platform: state
+    entity_id: binary_sensor.hallway_motion
+    to: "on"
+action:
+  - service: light.turn_on
+    target:
+      entity_id: light.kitchen
+`,
+			expected: []core.StructuralChange{
+				{
+					Type:     core.StructuralChangeChanged,
+					Path:     "trigger[1].entity_id",
+					OldValue: "binary_sensor.motion",
+					NewValue: "binary_sensor.hallway_motion",
+				},
+			},
+		},
+		{
+			name: "Elements inserted and removed are matched by similarity",
+			left: `action:
+  - service: light.turn_on
+    target:
+      entity_id: light.kitchen
+  - delay: "00:05:00"
+  - service: light.turn_off
+    target:
+      entity_id: light.kitchen
+`,
+			right: `action:
+  - service: notify.phone
+    data:
+      message: Lights on
+  - service: light.turn_on
+    target:
+      entity_id: light.kitchen
+    data:
+      brightness: 50
+  - service: light.turn_off
+    target:
+      entity_id: light.kitchen
+`,
+			expected: []core.StructuralChange{
+				{
+					Type:     core.StructuralChangeAdded,
+					Path:     "action[0]",
+					NewValue: map[string]any{"service": "notify.phone", "data": map[string]any{"message": "Lights on"}},
+				},
+				{
+					Type:     core.StructuralChangeAdded,
+					Path:     "action[1].data",
+					NewValue: map[string]any{"brightness": 50},
+				},
+				{
+					Type:     core.StructuralChangeRemoved,
+					Path:     "action[1]",
+					OldValue: map[string]any{"delay": "00:05:00"},
+				},
+			},
+		},
+		{
+			name:  "Key order and formatting are ignored",
+			left:  "script:\n  alias: Test\n  mode: single\n",
+			right: "script: {mode: 'single', alias: \"Test\"}\n",
+		},
+		{
+			name:  "Added and removed keys",
+			left:  "alias: Test\ncondition: []\nmode: single\n",
+			right: "alias: Test\nmode: single\ndescription: Turns on the lights\n",
+			expected: []core.StructuralChange{
+				{Type: core.StructuralChangeRemoved, Path: "condition", OldValue: []any{}},
+				{Type: core.StructuralChangeAdded, Path: "description", NewValue: "Turns on the lights"},
+			},
+		},
+		{
+			name:  "Replaced scalars in a list are changed values",
+			left:  "entity_id:\n  - light.a\n  - light.b\n",
+			right: "entity_id:\n  - light.a\n  - light.c\n",
+			expected: []core.StructuralChange{
+				{Type: core.StructuralChangeChanged, Path: "entity_id[1]", OldValue: "light.b", NewValue: "light.c"},
+			},
+		},
+		{
+			name:  "JSON documents with quoted keys",
+			left:  `{"data": {"entries": {"sensor.a": {"name": "A"}}}, "version": 1}`,
+			right: `{"data": {"entries": {"sensor.a": {"name": "B"}}}, "version": "1"}`,
+			expected: []core.StructuralChange{
+				{Type: core.StructuralChangeChanged, Path: `data.entries["sensor.a"].name`, OldValue: "A", NewValue: "B"},
+				{Type: core.StructuralChangeChanged, Path: "version", OldValue: 1, NewValue: "1"},
+			},
+		},
+		{
+			name:  "Custom tags are kept",
+			left:  "api_key: !secret old_key\n",
+			right: "api_key: !secret new_key\n",
+			expected: []core.StructuralChange{
+				{Type: core.StructuralChangeChanged, Path: "api_key", OldValue: "!secret old_key", NewValue: "!secret new_key"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, approximate, err := core.DiffStructure([]byte(tt.left), []byte(tt.right), core.DiffOptions{})
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if approximate {
+				t.Error("Expected an exact diff")
+			}
+
+			expected := tt.expected
+			if expected == nil {
+				expected = []core.StructuralChange{}
+			}
+			if diff := cmp.Diff(expected, changes); diff != "" {
+				t.Errorf("Changes do not match expected:\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("Describes changes", func(t *testing.T) {
+		change := core.StructuralChange{
+			Type:     core.StructuralChangeChanged,
+			Path:     "trigger[1].entity_id",
+			OldValue: "binary_sensor.motion",
+			NewValue: "binary_sensor.hallway_motion",
+		}
+		expected := "trigger[1].entity_id changed from binary_sensor.motion to binary_sensor.hallway_motion"
+		if change.String() != expected {
+			t.Errorf("Expected %q, got: %q", expected, change.String())
+		}
+	})
+
+	t.Run("Invalid documents are unsupported", func(t *testing.T) {
+		_, _, err := core.DiffStructure([]byte("key: value\n"), []byte("key: [unclosed\n"), core.DiffOptions{})
+		if !errors.Is(err, core.ErrStructuralDiffUnsupported) {
+			t.Errorf("Expected ErrStructuralDiffUnsupported, got: %v", err)
+		}
+	})
+
+	t.Run("Documents larger than the max size are unsupported", func(t *testing.T) {
+		_, _, err := core.DiffStructure([]byte("key: value\n"), []byte("key: other value\n"), core.DiffOptions{MaxSize: 12})
+		if !errors.Is(err, core.ErrStructuralDiffUnsupported) {
+			t.Errorf("Expected ErrStructuralDiffUnsupported, got: %v", err)
+		}
+	})
+
+	// list builds a sequence of automations, with the entity of one of them renamed
+	list := func(count, renamed int) []byte {
+		var builder strings.Builder
+		for i := 0; i < count; i++ {
+			entity := fmt.Sprintf("light.room_%d", i)
+			if i == renamed {
+				entity = "light.renamed"
+			}
+			fmt.Fprintf(&builder, "- id: \"%d\"\n  entity_id: %s\n", i, entity)
+		}
+		return []byte(builder.String())
+	}
+
+	t.Run("Large lists are matched by position", func(t *testing.T) {
+		changes, approximate, err := core.DiffStructure(list(2000, -1), list(2001, 5), core.DiffOptions{})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !approximate {
+			t.Error("Expected the diff to be approximate")
+		}
+
+		expected := []core.StructuralChange{
+			{Type: core.StructuralChangeChanged, Path: "[5].entity_id", OldValue: "light.room_5",
+				NewValue: "light.renamed"},
+			{Type: core.StructuralChangeAdded, Path: "[2000]",
+				NewValue: map[string]any{"id": "2000", "entity_id": "light.room_2000"}},
+		}
+		if diff := cmp.Diff(expected, changes); diff != "" {
+			t.Errorf("Changes do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Lists are matched by position when the timeout expires", func(t *testing.T) {
+		changes, approximate, err := core.DiffStructure(list(10, -1), list(10, 5), core.DiffOptions{Timeout: time.Nanosecond})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if !approximate {
+			t.Error("Expected the diff to be approximate")
+		}
+
+		expected := []core.StructuralChange{
+			{Type: core.StructuralChangeChanged, Path: "[5].entity_id", OldValue: "light.room_5",
+				NewValue: "light.renamed"},
+		}
+		if diff := cmp.Diff(expected, changes); diff != "" {
+			t.Errorf("Changes do not match expected:\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"net"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var backupTypes = []string{
+	types.BackupTypeMultipleName,
+	types.BackupTypeSingleName,
+	types.BackupTypeDirectoryName,
+	types.BackupTypeMappingName,
+	types.BackupTypeIncludesName,
+	types.BackupTypeJsonName,
+}
+
+var watcherBackends = []string{"", WatcherBackendAuto, WatcherBackendFsnotify, WatcherBackendPoll}
+
+// SettingsIssue is a problem with one setting, addressed by its JSON path, eg. "configs[2].idNode"
+type SettingsIssue struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (i SettingsIssue) String() string {
+	return i.Field + ": " + i.Message
+}
+
+// SettingsValidation holds the problems found in settings. Settings with errors are rejected, while warnings are
+// only reported.
+type SettingsValidation struct {
+	Errors   []SettingsIssue `json:"errors"`
+	Warnings []SettingsIssue `json:"warnings"`
+}
+
+func (v *SettingsValidation) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// Err returns the errors as a single error, or nil if there are none
+func (v *SettingsValidation) Err() error {
+	errs := make([]error, 0, len(v.Errors))
+	for _, issue := range v.Errors {
+		errs = append(errs, errors.New(issue.String()))
+	}
+	return errors.Join(errs...)
+}
+
+func (v *SettingsValidation) addError(field, format string, args ...any) {
+	v.Errors = append(v.Errors, SettingsIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *SettingsValidation) addWarning(field, format string, args ...any) {
+	v.Warnings = append(v.Warnings, SettingsIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateSettings checks every setting, returning all the errors and warnings found
+func ValidateSettings(settings *types.AppSettings) SettingsValidation {
+	v := SettingsValidation{Errors: []SettingsIssue{}, Warnings: []SettingsIssue{}}
+
+	if settings.SchemaVersion > types.CurrentSchemaVersion() {
+		v.addError("schemaVersion", "must be at most %d, the newest version supported", types.CurrentSchemaVersion())
+	}
+
+	if settings.HomeAssistantConfigDir == "" {
+		v.addError("homeAssistantConfigDir", "is required")
+	} else if !io.DirectoryExists(settings.HomeAssistantConfigDir) {
+		v.addWarning("homeAssistantConfigDir", "directory does not exist: %s", settings.HomeAssistantConfigDir)
+	}
+	if settings.BackupDir == "" {
+		v.addError("backupDir", "is required")
+	} else if !io.DirectoryExists(settings.BackupDir) {
+		v.addWarning("backupDir", "directory does not exist: %s", settings.BackupDir)
+	}
+
+	if _, _, err := net.SplitHostPort(settings.Port); err != nil {
+		v.addError("port", "must be a listen address like \":40613\": %v", err)
+	}
+	if settings.CronSchedule != nil && *settings.CronSchedule != "" {
+		if err := ValidateCronSchedule(*settings.CronSchedule); err != nil {
+			v.addError("cronSchedule", "invalid cron schedule: %v", err)
+		}
+	}
+
+	validateMinimum(&v, "defaultMaxBackups", settings.DefaultMaxBackups, 1)
+	validateMinimum(&v, "defaultMaxBackupAgeDays", settings.DefaultMaxBackupAgeDays, 1)
+	validateMinimum(&v, "pollIntervalSeconds", settings.PollIntervalSeconds, 1)
+	validateMinimum(&v, "backupWorkers", settings.BackupWorkers, 1)
+	validateMinimum(&v, "queueDepth", settings.QueueDepth, 1)
+
+	paths := make(map[string]int, len(settings.Configs))
+	for i, options := range settings.Configs {
+		field := fmt.Sprintf("configs[%d]", i)
+		if options == nil {
+			v.addError(field, "is empty")
+			continue
+		}
+
+		if first, exists := paths[options.Path]; exists && options.Path != "" {
+			v.addError(field+".path", "duplicates the path of configs[%d]: %s", first, options.Path)
+		} else {
+			paths[options.Path] = i
+		}
+		validateConfigOptions(&v, field, options)
+	}
+
+	return v
+}
+
+func validateConfigOptions(v *SettingsValidation, field string, options *types.ConfigBackupOptions) {
+	if options.Name == "" {
+		v.addWarning(field+".name", "is empty, the path will be shown instead")
+	}
+	if options.Path == "" {
+		v.addError(field+".path", "is required")
+	} else if filepath.IsAbs(options.Path) || io.SanitizePath(options.Path) != nil {
+		v.addError(field+".path", "must be relative to the Home Assistant config directory")
+	}
+
+	if !slices.Contains(backupTypes, options.BackupType) {
+		v.addError(field+".backupType", "must be one of %s", strings.Join(backupTypes, ", "))
+	}
+
+	switch options.BackupType {
+	case types.BackupTypeMultipleName:
+		validateRequired(v, field+".idNode", options.IdNode, options.BackupType)
+		validateRequired(v, field+".friendlyNameNode", options.FriendlyNameNode, options.BackupType)
+	case types.BackupTypeJsonName:
+		validateRequired(v, field+".itemsPath", options.ItemsPath, options.BackupType)
+		validateRequired(v, field+".idNode", options.IdNode, options.BackupType)
+		validateRequired(v, field+".friendlyNameNode", options.FriendlyNameNode, options.BackupType)
+	}
+	validateNodeExpression(v, field+".idNode", options.IdNode)
+	validateNodeExpression(v, field+".friendlyNameNode", options.FriendlyNameNode)
+
+	patternFields := []struct {
+		name     string
+		patterns []string
+	}{
+		{"includeFilePatterns", options.IncludeFilePatterns},
+		{"excludeFilePatterns", options.ExcludeFilePatterns},
+	}
+	for _, patternField := range patternFields {
+		if len(patternField.patterns) > 0 && options.BackupType != types.BackupTypeDirectoryName {
+			v.addWarning(field+"."+patternField.name, "only used by directory configs")
+		}
+		for j, pattern := range patternField.patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				v.addError(fmt.Sprintf("%s.%s[%d]", field, patternField.name, j), "invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+
+	for j, key := range options.IgnoreKeys {
+		if slices.Contains(strings.Split(key, "."), "") {
+			v.addError(fmt.Sprintf("%s.ignoreKeys[%d]", field, j), "empty path segment in %q", key)
+		}
+	}
+	for j, pattern := range options.IgnoreLinePatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			v.addError(fmt.Sprintf("%s.ignoreLinePatterns[%d]", field, j), "invalid pattern: %v", err)
+		}
+	}
+
+	validateMinimum(v, field+".maxBackups", options.MaxBackups, 1)
+	validateMinimum(v, field+".maxBackupAgeDays", options.MaxBackupAgeDays, 1)
+	if options.MaxFileSizeBytes != nil && *options.MaxFileSizeBytes < 1 {
+		v.addError(field+".maxFileSizeBytes", "must be greater than 0")
+	}
+
+	if !slices.Contains(watcherBackends, options.Watcher) {
+		v.addError(field+".watcher", "must be one of %s", strings.Join(watcherBackends[1:], ", "))
+	}
+}
+
+func validateMinimum(v *SettingsValidation, field string, value *int, minimum int) {
+	if value != nil && *value < minimum {
+		v.addError(field, "must be at least %d", minimum)
+	}
+}
+
+func validateRequired(v *SettingsValidation, field string, value *string, backupType string) {
+	if value == nil || *value == "" {
+		v.addError(field, "is required for %s configs", backupType)
+	}
+}
+
+func validateNodeExpression(v *SettingsValidation, field string, expression *string) {
+	if expression == nil || *expression == "" {
+		return
+	}
+	if err := types.ValidateNodeExpression(*expression); err != nil {
+		v.addError(field, "invalid node expression: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"ha-config-history/internal/io"
+	"ha-config-history/internal/types"
+	"sort"
+)
+
+// Verify issue severities
+const (
+	VerifySeverityError   = "error"
+	VerifySeverityWarning = "warning"
+)
+
+// VerifyIssue is a problem found by Verify. The identifier and filename are empty for problems with the settings or
+// the backup directory as a whole.
+type VerifyIssue struct {
+	types.ConfigIdentifier
+	Filename string `json:"filename,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Verify checks the settings and every backup for problems: invalid settings, unreadable metadata or backups,
+// configs without backups, latest backups that don't match their metadata, and backups of configs that are no longer
+// in the settings. Included configs are only recognised once their include graphs are loaded.
+func (s *Server) Verify() []VerifyIssue {
+	issues := []VerifyIssue{}
+	addIssue := func(identifier types.ConfigIdentifier, filename, severity, message string) {
+		issues = append(issues, VerifyIssue{
+			ConfigIdentifier: identifier,
+			Filename:         filename,
+			Severity:         severity,
+			Message:          message,
+		})
+	}
+
+	settings := s.Settings()
+	validation := ValidateSettings(settings)
+	for _, issue := range validation.Errors {
+		addIssue(types.ConfigIdentifier{}, "", VerifySeverityError, "invalid setting "+issue.String())
+	}
+	for _, issue := range validation.Warnings {
+		addIssue(types.ConfigIdentifier{}, "", VerifySeverityWarning, "setting "+issue.String())
+	}
+
+	metadataMap, err := io.LoadAllMetadata(settings.BackupDir)
+	if err != nil {
+		addIssue(types.ConfigIdentifier{}, "", VerifySeverityError, err.Error())
+		return issues
+	}
+
+	identifiers := make([]types.ConfigIdentifier, 0, len(metadataMap))
+	for identifier := range metadataMap {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Slice(identifiers, func(i, j int) bool {
+		if identifiers[i].Group != identifiers[j].Group {
+			return identifiers[i].Group < identifiers[j].Group
+		}
+		return identifiers[i].ID < identifiers[j].ID
+	})
+
+	for _, identifier := range identifiers {
+		metadata := metadataMap[identifier]
+
+		if s.FindConfigOptions(identifier.Group) == nil {
+			addIssue(identifier, "", VerifySeverityWarning, "config is no longer in the settings")
+		}
+
+		backups, err := io.ListConfigBackups(settings.BackupDir, identifier.Group, identifier.ID)
+		if err != nil {
+			addIssue(identifier, "", VerifySeverityError, err.Error())
+			continue
+		}
+		if len(backups) == 0 {
+			addIssue(identifier, "", VerifySeverityError, "metadata exists but there are no backups")
+			continue
+		}
+
+		for i, backup := range backups {
+			path, err := io.GetConfigBackupPath(settings.BackupDir, identifier.Group, identifier.ID, backup.Filename)
+			if err != nil {
+				addIssue(identifier, backup.Filename, VerifySeverityError, err.Error())
+				continue
+			}
+			hash, err := io.HashFile(path)
+			if err != nil {
+				addIssue(identifier, backup.Filename, VerifySeverityError, err.Error())
+				continue
+			}
+
+			// Format-only changes update the raw hash without saving a backup
+			formatChangedSince := metadata.LastFormatOnlyChange != nil && metadata.LastFormatOnlyChange.After(backup.Date)
+			if i == 0 && metadata.LastRawHash != "" && !formatChangedSince && hash != metadata.LastRawHash {
+				addIssue(identifier, backup.Filename, VerifySeverityError, "latest backup does not match its metadata hash")
+			}
+		}
+	}
+
+	return issues
+}
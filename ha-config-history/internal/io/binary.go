@@ -0,0 +1,113 @@
+package io
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	goio "io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLength is how much of a file is inspected to decide whether it is binary, matching git's heuristic
+const sniffLength = 8000
+
+// streamingThreshold is the size above which files are hashed and stored without being read into memory
+const streamingThreshold = 4 * 1024 * 1024
+
+// ErrFileTooLarge is returned when a file is larger than its config's MaxFileSizeBytes, and is skipped
+var ErrFileTooLarge = errors.New("file exceeds max file size")
+
+// IsBinary reports whether content looks binary, ie. it contains a NUL byte or isn't valid UTF-8.
+// The content may be a prefix of a file, so a multi-byte character cut off at the end is allowed.
+func IsBinary(content []byte) bool {
+	if bytes.IndexByte(content, 0) >= 0 {
+		return true
+	}
+	if utf8.Valid(content) {
+		return false
+	}
+	for i := len(content) - 1; i >= 0 && i >= len(content)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(content[i]) {
+			return utf8.FullRune(content[i:]) || !utf8.Valid(content[:i])
+		}
+	}
+	return true
+}
+
+// SniffFile reads the start of a file to decide whether it is binary, also returning its size
+func SniffFile(path string) (bool, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, 0, err
+	}
+
+	head, err := ReadFileHead(path)
+	if err != nil {
+		return false, 0, err
+	}
+	return IsBinary(head), info.Size(), nil
+}
+
+// ReadFileHead reads up to the first 8000 bytes of a file, enough to tell whether it is binary
+func ReadFileHead(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLength)
+	n, err := goio.ReadFull(file, head)
+	if err != nil && !errors.Is(err, goio.EOF) && !errors.Is(err, goio.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// BackupContentType returns the content type to serve a backup with, based on the original file's extension
+// (the id for directory configs) and its content
+func BackupContentType(id, backupType string, head []byte) string {
+	extension := strings.ToLower(filepath.Ext(id))
+
+	if IsBinary(head) {
+		if contentType := mime.TypeByExtension(extension); contentType != "" {
+			return contentType
+		}
+		return http.DetectContentType(head)
+	}
+
+	if backupType == "json" || extension == ".json" {
+		return "application/json"
+	}
+	if extension == "" || extension == ".yaml" || extension == ".yml" {
+		return "application/x-yaml"
+	}
+	if contentType := mime.TypeByExtension(extension); strings.HasPrefix(contentType, "text/") {
+		return contentType
+	}
+	return "text/plain; charset=utf-8"
+}
+
+func copyFile(sourcePath, destinationPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", sourcePath, err)
+	}
+	defer source.Close()
+
+	destination, err := os.Create(destinationPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", destinationPath, err)
+	}
+
+	if _, err := goio.Copy(destination, source); err != nil {
+		destination.Close()
+		return fmt.Errorf("failed to copy %s: %w", sourcePath, err)
+	}
+	return destination.Close()
+}
@@ -0,0 +1,194 @@
+package io
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	goio "io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrProtectedHABackup is returned for password protected Home Assistant backups, which are encrypted
+var ErrProtectedHABackup = errors.New("password protected backups can't be imported")
+
+// HABackup describes a Home Assistant full or partial backup archive, from its backup.json
+type HABackup struct {
+	Path      string    `json:"path"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Date      time.Time `json:"date"`
+	Type      string    `json:"type"`
+	Protected bool      `json:"protected"`
+}
+
+// haBackupConfigPrefix is the directory Home Assistant's config is stored under in homeassistant.tar.gz
+const haBackupConfigPrefix = "data/"
+
+// ReadHABackup reads the backup.json of a Home Assistant backup archive
+func ReadHABackup(archivePath string) (*HABackup, error) {
+	var backup *HABackup
+	err := walkTar(archivePath, func(name string, reader goio.Reader) (bool, error) {
+		if name != "backup.json" {
+			return true, nil
+		}
+
+		backup = &HABackup{}
+		if err := json.NewDecoder(reader).Decode(backup); err != nil {
+			return false, fmt.Errorf("failed to parse backup.json in %s: %w", archivePath, err)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if backup == nil {
+		return nil, fmt.Errorf("not a Home Assistant backup, backup.json not found in %s", archivePath)
+	}
+
+	backup.Path = archivePath
+	// Versions are stored with second resolution
+	backup.Date = backup.Date.UTC().Truncate(time.Second)
+	return backup, nil
+}
+
+// ListHABackups returns the Home Assistant backup archives in a directory, oldest first. Files that aren't Home
+// Assistant backups are skipped.
+func ListHABackups(directory string) ([]*HABackup, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory %s: %w", directory, err)
+	}
+
+	backups := []*HABackup{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".tar" {
+			continue
+		}
+
+		backup, err := ReadHABackup(filepath.Join(directory, entry.Name()))
+		if err != nil {
+			slog.Warn("Skipping file that isn't a readable Home Assistant backup", "file", entry.Name(), "error", err)
+			continue
+		}
+		backups = append(backups, backup)
+	}
+
+	SortHABackups(backups)
+	return backups, nil
+}
+
+// SortHABackups sorts backups oldest first
+func SortHABackups(backups []*HABackup) {
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].Date.Before(backups[j].Date)
+	})
+}
+
+// ExtractHABackupConfig extracts the Home Assistant config files of a backup archive that wanted accepts into
+// destination, which then has the same layout as the config directory. Paths passed to wanted are relative to the
+// config directory.
+func ExtractHABackupConfig(backup *HABackup, destination string, wanted func(relPath string) bool) error {
+	if backup.Protected {
+		return fmt.Errorf("%w: %s", ErrProtectedHABackup, backup.Path)
+	}
+
+	found := false
+	err := walkTar(backup.Path, func(name string, reader goio.Reader) (bool, error) {
+		switch name {
+		case "homeassistant.tar.gz":
+			gzipReader, err := gzip.NewReader(reader)
+			if err != nil {
+				return false, fmt.Errorf("failed to decompress homeassistant.tar.gz in %s: %w", backup.Path, err)
+			}
+			defer gzipReader.Close()
+			reader = gzipReader
+		case "homeassistant.tar":
+		default:
+			return true, nil
+		}
+
+		found = true
+		return false, extractConfigTar(tar.NewReader(reader), destination, wanted)
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("backup %s doesn't include Home Assistant's config", backup.Path)
+	}
+	return nil
+}
+
+func extractConfigTar(reader *tar.Reader, destination string, wanted func(relPath string) bool) error {
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, goio.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read homeassistant.tar.gz: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		relPath, inConfig := strings.CutPrefix(name, haBackupConfigPrefix)
+		if !inConfig || SanitizePath(relPath) != nil || !wanted(relPath) {
+			continue
+		}
+
+		target := filepath.Join(destination, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", relPath, err)
+		}
+		file, err := os.Create(target)
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", relPath, err)
+		}
+		_, err = goio.Copy(file, reader)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", relPath, err)
+		}
+	}
+}
+
+// walkTar calls visit with each regular file in a tar archive until it returns false or an error. Names are
+// relative, without any leading "./".
+func walkTar(archivePath string, visit func(name string, reader goio.Reader) (bool, error)) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup %s: %w", archivePath, err)
+	}
+	defer file.Close()
+
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, goio.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read backup %s: %w", archivePath, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		next, err := visit(strings.TrimPrefix(header.Name, "./"), reader)
+		if err != nil || !next {
+			return err
+		}
+	}
+}
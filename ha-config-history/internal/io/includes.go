@@ -0,0 +1,267 @@
+package io
+
+import (
+	"fmt"
+	"ha-config-history/internal/types"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includeKeyOptions maps well known Home Assistant keys to the backup type their included file should use
+var includeKeyOptions = map[string]func(name, path string) *types.ConfigBackupOptions{
+	"automation": func(name, path string) *types.ConfigBackupOptions {
+		return types.NewMultipleConfigBackupOptions(name, path, "id", "alias")
+	},
+	"scene": func(name, path string) *types.ConfigBackupOptions {
+		return types.NewMultipleConfigBackupOptions(name, path, "id", "name")
+	},
+	"script": func(name, path string) *types.ConfigBackupOptions {
+		return types.NewMappingConfigBackupOptions(name, path, "alias")
+	},
+}
+
+// includeKeyOptionsFor returns the constructor for a key, ignoring the label Home Assistant allows after it
+// (eg. `automation manual`)
+func includeKeyOptionsFor(key string) (func(name, path string) *types.ConfigBackupOptions, bool) {
+	fields := strings.Fields(key)
+	if len(fields) == 0 {
+		return nil, false
+	}
+	newOptions, exists := includeKeyOptions[fields[0]]
+	return newOptions, exists
+}
+
+// includeDirItemTypes maps include directory tags to the backup type their files need to be tracked per item:
+// merged directories hold the lists or mappings a plain include would, and each `!include_dir_list` file is a
+// single list item, which the multiple backup type reads as a one item file
+var includeDirItemTypes = map[string]string{
+	"!include_dir_list":        types.BackupTypeMultipleName,
+	"!include_dir_merge_list":  types.BackupTypeMultipleName,
+	"!include_dir_merge_named": types.BackupTypeMappingName,
+}
+
+var includeDirTags = map[string]struct{}{
+	"!include_dir_list":        {},
+	"!include_dir_named":       {},
+	"!include_dir_merge_list":  {},
+	"!include_dir_merge_named": {},
+}
+
+type includeResolver struct {
+	rootPath string
+	config   *types.ConfigBackupOptions
+	graph    *types.IncludeGraph
+	visited  map[string]struct{}
+	tracked  map[string]struct{}
+}
+
+// ResolveIncludes parses the file at config.Path and follows every `!include` and `!include_dir_*` tag,
+// returning the graph of referenced files and the backup options needed to track each of them. Paths already
+// tracked by one of the other configs are left out of the graph's configs so they aren't backed up twice.
+func ResolveIncludes(
+	rootPath string,
+	config *types.ConfigBackupOptions,
+	configs []*types.ConfigBackupOptions,
+) (*types.IncludeGraph, error) {
+	if err := SanitizePath(config.Path); err != nil {
+		return nil, err
+	}
+
+	r := &includeResolver{
+		rootPath: rootPath,
+		config:   config,
+		graph: &types.IncludeGraph{
+			Root:    config.Path,
+			Edges:   []types.IncludeEdge{},
+			Files:   []string{},
+			Configs: []*types.ConfigBackupOptions{},
+		},
+		visited: map[string]struct{}{},
+		tracked: map[string]struct{}{},
+	}
+	for _, other := range configs {
+		if other.BackupType != types.BackupTypeIncludesName {
+			r.tracked[other.Path] = struct{}{}
+		}
+	}
+
+	root := types.NewSingleConfigBackupOptions(config.Name, config.Path)
+	r.track(root)
+
+	if err := r.visitFile(config.Path); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(r.graph.Files)
+	return r.graph, nil
+}
+
+func (r *includeResolver) track(options *types.ConfigBackupOptions) {
+	if _, exists := r.tracked[options.Path]; exists {
+		return
+	}
+	r.tracked[options.Path] = struct{}{}
+	options.MaxBackups = r.config.MaxBackups
+	options.MaxBackupAgeDays = r.config.MaxBackupAgeDays
+	r.graph.Configs = append(r.graph.Configs, options)
+}
+
+func (r *includeResolver) visitFile(relPath string) error {
+	if _, exists := r.visited[relPath]; exists {
+		return nil
+	}
+	r.visited[relPath] = struct{}{}
+	r.graph.Files = append(r.graph.Files, relPath)
+
+	filePath := r.rootPath + "/" + relPath
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+
+	var rootNode yaml.Node
+	if err := yaml.Unmarshal(data, &rootNode); err != nil {
+		return fmt.Errorf("failed to parse YAML in %s: %w", filePath, err)
+	}
+
+	return r.walk(relPath, &rootNode, "")
+}
+
+func (r *includeResolver) walk(relPath string, node *yaml.Node, key string) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := r.walk(relPath, child, key); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content)-1; i += 2 {
+			if err := r.walk(relPath, node.Content[i+1], node.Content[i].Value); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.Tag == "!include" {
+			return r.includeFile(relPath, node.Value, node.Tag, key)
+		}
+		if _, exists := includeDirTags[node.Tag]; exists {
+			return r.includeDirectory(relPath, node.Value, node.Tag, key)
+		}
+	}
+	return nil
+}
+
+func (r *includeResolver) resolveTarget(fromPath, target string) (string, error) {
+	resolved := filepath.ToSlash(filepath.Clean(filepath.Join(filepath.Dir(fromPath), target)))
+	if err := SanitizePath(resolved); err != nil || filepath.IsAbs(resolved) {
+		return "", fmt.Errorf("include %s in %s is outside the config directory", target, fromPath)
+	}
+	return resolved, nil
+}
+
+func (r *includeResolver) includeFile(fromPath, target, tag, key string) error {
+	resolved, err := r.resolveTarget(fromPath, target)
+	if err != nil {
+		slog.Warn("Skipping include", "error", err)
+		return nil
+	}
+
+	edge := types.IncludeEdge{From: fromPath, To: resolved, Tag: tag, Key: key}
+	info, err := os.Stat(r.rootPath + "/" + resolved)
+	if err != nil || info.IsDir() {
+		edge.Missing = true
+		r.graph.Edges = append(r.graph.Edges, edge)
+		return nil
+	}
+	r.graph.Edges = append(r.graph.Edges, edge)
+
+	if newOptions, exists := includeKeyOptionsFor(key); exists {
+		r.track(newOptions(resolved, resolved))
+	} else {
+		r.track(types.NewSingleConfigBackupOptions(resolved, resolved))
+	}
+
+	if !isYamlFile(resolved) {
+		return nil
+	}
+	if err := r.visitFile(resolved); err != nil {
+		slog.Warn("Unable to follow includes in file", "file", resolved, "error", err)
+	}
+	return nil
+}
+
+func (r *includeResolver) includeDirectory(fromPath, target, tag, key string) error {
+	resolved, err := r.resolveTarget(fromPath, target)
+	if err != nil {
+		slog.Warn("Skipping include", "error", err)
+		return nil
+	}
+
+	edge := types.IncludeEdge{From: fromPath, To: resolved, Tag: tag, Key: key}
+	if !DirectoryExists(r.rootPath + "/" + resolved) {
+		edge.Missing = true
+		r.graph.Edges = append(r.graph.Edges, edge)
+		return nil
+	}
+	r.graph.Edges = append(r.graph.Edges, edge)
+
+	newOptions, perItem := includeKeyOptionsFor(key)
+	if perItem {
+		perItem = includeDirItemTypes[tag] == newOptions(resolved, resolved).BackupType
+	}
+
+	// Home Assistant loads include directories recursively, but directory configs only read a single level,
+	// so each nested directory containing YAML files is tracked as its own directory config. Files holding
+	// automations, scenes or scripts are tracked per item instead, keeping their ids and friendly names.
+	yamlFiles := []string{}
+	err = filepath.WalkDir(r.rootPath+"/"+resolved, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), ".") && path != r.rootPath+"/"+resolved {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || !isYamlFile(entry.Name()) {
+			return nil
+		}
+
+		relFile, err := filepath.Rel(r.rootPath, path)
+		if err != nil {
+			return err
+		}
+		relFile = filepath.ToSlash(relFile)
+		yamlFiles = append(yamlFiles, relFile)
+		if perItem {
+			r.track(newOptions(relFile, relFile))
+			return nil
+		}
+		relDir := filepath.ToSlash(filepath.Dir(relFile))
+		r.track(types.NewDirectoryConfigBackupOptions(relDir, relDir, []string{"*.yaml", "*.yml"}, []string{}))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read include directory %s: %w", resolved, err)
+	}
+
+	for _, relFile := range yamlFiles {
+		if err := r.visitFile(relFile); err != nil {
+			slog.Warn("Unable to follow includes in file", "file", relFile, "error", err)
+		}
+	}
+	return nil
+}
+
+func isYamlFile(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".yaml" || ext == ".yml"
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package io
+
+import "os"
+
+// FileInode is not available on this platform, so change detection relies on mtime and size only
+func FileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -2,6 +2,7 @@ package io
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"ha-config-history/internal/types"
 	"log/slog"
@@ -38,14 +39,64 @@ func ReadMultipleConfigsFromSingleFile(rootPath string, config *types.ConfigBack
 		return nil, fmt.Errorf("failed to parse YAML in %s: %w", filePath, err)
 	}
 
-	if len(rootNode.Content) == 0 || rootNode.Content[0].Kind != yaml.SequenceNode {
+	items, err := multipleConfigItems(&rootNode)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, yamlNode := range items {
+		configBackup, err := types.NewYamlConfigBackup(config.Path, filePath, yamlNode, config, currentTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create config backup for %s: %w", filePath, err)
+		}
+		configBackups = append(configBackups, configBackup)
+	}
+
+	return configBackups, nil
+}
+
+// multipleConfigItems returns the items of a multiple config's document. A mapping at root is a single item, as in
+// the files of an `!include_dir_list` directory.
+func multipleConfigItems(rootNode *yaml.Node) ([]*yaml.Node, error) {
+	if len(rootNode.Content) == 0 {
+		return nil, fmt.Errorf("expected a YAML sequence at root")
+	}
+
+	switch contentNode := rootNode.Content[0]; contentNode.Kind {
+	case yaml.SequenceNode:
+		return contentNode.Content, nil
+	case yaml.MappingNode:
+		return []*yaml.Node{contentNode}, nil
+	default:
 		return nil, fmt.Errorf("expected a YAML sequence at root")
 	}
+}
+
+func ReadKeyedConfigsFromSingleFile(rootPath string, config *types.ConfigBackupOptions) ([]*types.ConfigBackup, error) {
+	currentTime := time.Now().UTC()
+	filePath := rootPath + "/" + config.Path
+
+	configBackups := []*types.ConfigBackup{}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+
+	var rootNode yaml.Node
+	if err := yaml.Unmarshal(data, &rootNode); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML in %s: %w", filePath, err)
+	}
+
+	if len(rootNode.Content) == 0 || rootNode.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a YAML mapping at root")
+	}
 
 	contentNode := rootNode.Content[0]
 
-	for _, yamlNode := range contentNode.Content {
-		configBackup, err := types.NewYamlConfigBackup(config.Path, filePath, yamlNode, config, currentTime)
+	for i := 0; i < len(contentNode.Content)-1; i += 2 {
+		keyNode := contentNode.Content[i]
+		valueNode := contentNode.Content[i+1]
+		configBackup, err := types.NewKeyedYamlConfigBackup(config.Path, filePath, keyNode, valueNode, config, currentTime)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create config backup for %s: %w", filePath, err)
 		}
@@ -59,10 +110,31 @@ func ReadSingleConfigFromSingleFile(rootPath string, config *types.ConfigBackupO
 	return ReadSingleConfigFromSingleFilename(rootPath, config.Path, config)
 }
 
+// ReadSingleConfigFromSingleFilename reads a whole file as one backup. Binary and large files are hashed and later
+// stored by streaming, and files over the config's MaxFileSizeBytes return ErrFileTooLarge.
 func ReadSingleConfigFromSingleFilename(rootPath, filename string, config *types.ConfigBackupOptions) (*types.ConfigBackup, error) {
 	currentTime := time.Now().UTC()
 	filePath := rootPath + "/" + filename
 
+	binary, size, err := SniffFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+	if config.MaxFileSizeBytes != nil && size > *config.MaxFileSizeBytes {
+		return nil, fmt.Errorf("%w: %s is %d bytes, max is %d", ErrFileTooLarge, filePath, size, *config.MaxFileSizeBytes)
+	}
+	if binary || size > streamingThreshold {
+		hash, err := HashFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		configBackup, err := types.NewStreamedConfigBackup(filename, filePath, hash, size, binary, config, currentTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", filePath, err)
+		}
+		return configBackup, nil
+	}
+
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file %s: %w", filePath, err)
@@ -78,11 +150,38 @@ func ReadSingleConfigFromSingleFilename(rootPath, filename string, config *types
 func ReadMultipleConfigsFromDirectory(rootPath string, config *types.ConfigBackupOptions) ([]*types.ConfigBackup, error) {
 	directoryPath := rootPath + "/" + config.Path
 
+	files, err := ListDirectoryConfigFiles(rootPath, config)
+	if err != nil {
+		return nil, err
+	}
+
 	configBackups := []*types.ConfigBackup{}
+	for _, file := range files {
+		configBackup, err := ReadSingleConfigFromSingleFilename(directoryPath, filepath.Base(file), config)
+		if errors.Is(err, ErrFileTooLarge) {
+			slog.Warn("Skipping file larger than max file size", "error", err)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config from file %s: %w", filepath.Base(file), err)
+		}
+		configBackups = append(configBackups, configBackup)
+	}
+
+	return configBackups, nil
+}
+
+// ListDirectoryConfigFiles returns the path of every file in a directory config that matches its include and
+// exclude patterns
+func ListDirectoryConfigFiles(rootPath string, config *types.ConfigBackupOptions) ([]string, error) {
+	directoryPath := rootPath + "/" + config.Path
+
 	files, err := os.ReadDir(directoryPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read directory %s: %w", directoryPath, err)
 	}
+
+	paths := []string{}
 	for _, file := range files {
 		if file.IsDir() {
 			continue
@@ -102,14 +201,10 @@ func ReadMultipleConfigsFromDirectory(rootPath string, config *types.ConfigBacku
 			continue
 		}
 
-		configBackup, err := ReadSingleConfigFromSingleFilename(directoryPath, file.Name(), config)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read config from file %s: %w", file.Name(), err)
-		}
-		configBackups = append(configBackups, configBackup)
+		paths = append(paths, directoryPath+"/"+file.Name())
 	}
 
-	return configBackups, nil
+	return paths, nil
 }
 
 func isFileIncluded(config *types.ConfigBackupOptions, file os.DirEntry) (bool, error) {
@@ -158,39 +253,48 @@ func LoadAllMetadata(backupFolder string) (map[types.ConfigIdentifier]*types.Con
 	//      - 20231010T120000.backup
 	//      - 20231011T120000.yaml
 	//      - metadata.json
+	//  - nested/group2
+	//    - config2
+	//      - metadata.json
 
 	metadataMap := map[types.ConfigIdentifier]*types.ConfigMetadata{}
 
-	groups, err := os.ReadDir(backupFolder)
-	if err != nil {
+	if _, err := os.ReadDir(backupFolder); err != nil {
 		return nil, fmt.Errorf("failed to read backup folder %s: %w", backupFolder, err)
 	}
 
-	for _, group := range groups {
-		if group.IsDir() {
-			groupPath := filepath.Join(backupFolder, group.Name())
-			configs, err := os.ReadDir(groupPath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read group folder %s: %w", groupPath, err)
-			}
+	err := filepath.WalkDir(backupFolder, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read backup folder %s: %w", path, err)
+		}
+		if entry.IsDir() || entry.Name() != "metadata.json" {
+			return nil
+		}
 
-			for _, config := range configs {
-				if config.IsDir() {
-					metadataPath := filepath.Join(groupPath, config.Name(), "metadata.json")
-					metadataBlob, err := os.ReadFile(metadataPath)
-					if err != nil {
-						return nil, fmt.Errorf("failed to read metadata file %s: %w", metadataPath, err)
-					}
-
-					var metadata types.ConfigMetadata
-					if err := json.Unmarshal(metadataBlob, &metadata); err != nil {
-						return nil, fmt.Errorf("failed to parse metadata JSON in %s: %w", metadataPath, err)
-					}
-
-					metadataMap[types.ConfigIdentifier{Group: group.Name(), ID: config.Name()}] = &metadata
-				}
-			}
+		configPath, err := filepath.Rel(backupFolder, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		group := filepath.ToSlash(filepath.Dir(configPath))
+		if group == "." {
+			return nil
+		}
+
+		metadataBlob, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read metadata file %s: %w", path, err)
+		}
+
+		var metadata types.ConfigMetadata
+		if err := json.Unmarshal(metadataBlob, &metadata); err != nil {
+			return fmt.Errorf("failed to parse metadata JSON in %s: %w", path, err)
 		}
+
+		metadataMap[types.ConfigIdentifier{Group: group, ID: filepath.Base(configPath)}] = &metadata
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return metadataMap, nil
@@ -211,6 +315,19 @@ func GetBackupDirectory(backupFolder string, configBackup *types.ConfigBackup) (
 
 func SaveConfigBackup(configBackup *types.ConfigBackup, backupDirectory string) error {
 	backupPath := filepath.Join(backupDirectory, fmt.Sprintf("%s.backup", configBackup.ModifiedDate.Format("20060102T150405")))
+
+	if configBackup.Blob == nil && configBackup.FilePath != "" {
+		if err := copyFile(configBackup.FilePath, backupPath); err != nil {
+			return fmt.Errorf("failed to save config backup: %w", err)
+		}
+
+		// Verify the backup was saved without reading a potentially large file back into memory
+		if _, err := os.Stat(backupPath); err != nil {
+			return fmt.Errorf("backup saved but cannot be read back from %s: %w", backupPath, err)
+		}
+		return nil
+	}
+
 	err := os.WriteFile(backupPath, configBackup.Blob, 0644)
 
 	if err != nil {
@@ -225,12 +342,31 @@ func SaveConfigBackup(configBackup *types.ConfigBackup, backupDirectory string)
 	return nil
 }
 
-func CleanupAndUpdateMetadata(configBackup *types.ConfigBackup, backupOptions *types.ConfigBackupOptions, backupDirectory string, defaultMaxBackups *int, defaultMaxBackupAgeDays *int) (*types.ConfigMetadata, error) {
+// CleanupAndUpdateMetadata removes expired backups and saves the metadata of a config's latest backup, keeping the
+// deletions recorded for the item
+func CleanupAndUpdateMetadata(configBackup *types.ConfigBackup, backupOptions *types.ConfigBackupOptions, backupDirectory string, defaultMaxBackups *int, defaultMaxBackupAgeDays *int, deletions []time.Time) (*types.ConfigMetadata, error) {
 	backupsCount, backupsSize, err := dirMetrics(backupDirectory)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get directory metrics for %s: %w", backupDirectory, err)
 	}
 
+	effectiveMaxBackups, oldestBackupTimeAllowed := RetentionLimits(backupOptions, defaultMaxBackups, defaultMaxBackupAgeDays)
+	expired, err := ExpiredBackups(backupDirectory, effectiveMaxBackups, oldestBackupTimeAllowed)
+	if err != nil {
+		return nil, err
+	}
+	for _, backup := range expired {
+		RemoveBackup(backupDirectory, backup.Filename, backup.Reason)
+	}
+
+	metadata := types.NewConfigMetadata(configBackup, backupsCount, backupsSize, backupOptions.BackupType)
+	metadata.Deletions = deletions
+	return metadata, SaveMetadata(backupDirectory, metadata)
+}
+
+// RetentionLimits returns the max backups and the oldest backup time allowed for a config, preferring the config's
+// own limits over the defaults
+func RetentionLimits(backupOptions *types.ConfigBackupOptions, defaultMaxBackups, defaultMaxBackupAgeDays *int) (*int, time.Time) {
 	// Determine effective MaxBackupAgeDays: prefer option value, fall back to default
 	effectiveMaxBackupAgeDays := backupOptions.MaxBackupAgeDays
 	if effectiveMaxBackupAgeDays == nil {
@@ -247,50 +383,69 @@ func CleanupAndUpdateMetadata(configBackup *types.ConfigBackup, backupOptions *t
 	if effectiveMaxBackups == nil {
 		effectiveMaxBackups = defaultMaxBackups
 	}
+	return effectiveMaxBackups, oldestBackupTimeAllowed
+}
 
-	if effectiveMaxBackups != nil {
-		entries, err := os.ReadDir(backupDirectory)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read backup directory %s: %w", backupDirectory, err)
-		}
+// ExpiredBackup is a backup that is no longer kept under a config's retention limits
+type ExpiredBackup struct {
+	Filename string `json:"filename"`
+	Reason   string `json:"reason"`
+}
 
-		filenames := []string{}
-		for _, entry := range entries {
-			// TODO: V2 - remove .yaml
-			if !entry.IsDir() && (filepath.Ext(entry.Name()) == ".backup" || filepath.Ext(entry.Name()) == ".yaml") {
-				filenames = append(filenames, entry.Name())
-			}
+// ExpiredBackups returns the backups in a config's backup directory beyond maxBackups, or older than oldestAllowed.
+// Retention only applies when maxBackups is set, and the latest backup is always kept so a config that hasn't changed
+// for longer than the max age still has its current version.
+func ExpiredBackups(backupDirectory string, maxBackups *int, oldestAllowed time.Time) ([]ExpiredBackup, error) {
+	expired := []ExpiredBackup{}
+	if maxBackups == nil {
+		return expired, nil
+	}
+
+	entries, err := os.ReadDir(backupDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory %s: %w", backupDirectory, err)
+	}
+
+	filenames := []string{}
+	for _, entry := range entries {
+		// TODO: V2 - remove .yaml
+		if !entry.IsDir() && (filepath.Ext(entry.Name()) == ".backup" || filepath.Ext(entry.Name()) == ".yaml") {
+			filenames = append(filenames, entry.Name())
 		}
+	}
 
-		sort.Sort(sort.Reverse(sort.StringSlice(filenames)))
+	sort.Sort(sort.Reverse(sort.StringSlice(filenames)))
 
-		currentBackupCount := 0
-		for _, filename := range filenames {
-			currentBackupCount++
-			if currentBackupCount > *effectiveMaxBackups {
-				RemoveBackup(backupDirectory, filename, "exceeded max backups limit")
-				continue
-			}
+	for i, filename := range filenames {
+		if i == 0 {
+			continue
+		}
+		if i >= *maxBackups {
+			expired = append(expired, ExpiredBackup{Filename: filename, Reason: "exceeded max backups limit"})
+			continue
+		}
 
-			dateStr := filename[:len(filename)-5]
-			backupDate, err := time.ParseInLocation("20060102T150405", dateStr, time.UTC)
-			if err != nil {
-				continue
-			}
-			if backupDate.Before(oldestBackupTimeAllowed) {
-				RemoveBackup(backupDirectory, filename, "older than max backup age")
-			}
+		dateStr := strings.TrimSuffix(filename, filepath.Ext(filename))
+		backupDate, err := time.ParseInLocation("20060102T150405", dateStr, time.UTC)
+		if err != nil {
+			continue
+		}
+		if backupDate.Before(oldestAllowed) {
+			expired = append(expired, ExpiredBackup{Filename: filename, Reason: "older than max backup age"})
 		}
 	}
+	return expired, nil
+}
 
+// SaveMetadata writes the metadata.json for a config's backup directory
+func SaveMetadata(backupDirectory string, metadata *types.ConfigMetadata) error {
 	metadataPath := filepath.Join(backupDirectory, "metadata.json")
-	metadata := types.NewConfigMetadata(configBackup, backupsCount, backupsSize, backupOptions.BackupType)
 	metadataBlob, err := json.Marshal(metadata)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	return metadata, os.WriteFile(metadataPath, metadataBlob, 0644)
+	return os.WriteFile(metadataPath, metadataBlob, 0644)
 }
 
 func RemoveBackup(backupDirectory, filename, reason string) {
@@ -319,22 +474,32 @@ func dirMetrics(path string) (int, int64, error) {
 	return count, size, err
 }
 
-func GetConfigBackup(backupFolder, group, id, filename string) ([]byte, error) {
+// GetConfigBackupPath validates the path components of a backup and returns the path of its file
+func GetConfigBackupPath(backupFolder, group, id, filename string) (string, error) {
 	// Validate path components for directory traversal
 	if err := SanitizePath(group); err != nil {
-		return nil, fmt.Errorf("invalid group parameter: %w", err)
+		return "", fmt.Errorf("invalid group parameter: %w", err)
 	}
 	if err := SanitizePath(id); err != nil {
-		return nil, fmt.Errorf("invalid id parameter: %w", err)
+		return "", fmt.Errorf("invalid id parameter: %w", err)
 	}
 	if err := SanitizePath(filename); err != nil {
-		return nil, fmt.Errorf("invalid filename parameter: %w", err)
+		return "", fmt.Errorf("invalid filename parameter: %w", err)
 	}
 
 	backupPath := filepath.Join(backupFolder, group, id, filename)
 
 	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("backup file not found: %s", filename)
+		return "", fmt.Errorf("backup file not found: %s", filename)
+	}
+
+	return backupPath, nil
+}
+
+func GetConfigBackup(backupFolder, group, id, filename string) ([]byte, error) {
+	backupPath, err := GetConfigBackupPath(backupFolder, group, id, filename)
+	if err != nil {
+		return nil, err
 	}
 
 	content, err := os.ReadFile(backupPath)
@@ -414,6 +579,28 @@ func DirectoryExists(path string) bool {
 	return info.IsDir()
 }
 
+// RestoreBackup writes a backup's content back to the file it was taken from, replacing only the backed up item for
+// configs that store several items per file. It returns the path that was written.
+func RestoreBackup(rootPath string, options *types.ConfigBackupOptions, id string, content []byte) (string, error) {
+	fullPath := filepath.Join(rootPath, options.Path)
+
+	var err error
+	switch options.BackupType {
+	case "single":
+		err = RestoreEntireFile(fullPath, content)
+	case "multiple":
+		err = RestorePartialFile(fullPath, content, *options)
+	case "mapping":
+		err = RestoreKeyedPartialFile(fullPath, content)
+	case "json":
+		err = RestoreJsonPartialFile(fullPath, content, *options)
+	case "directory":
+		fullPath = filepath.Join(rootPath, options.Path, id)
+		err = RestoreEntireFile(fullPath, content)
+	}
+	return fullPath, err
+}
+
 func RestoreEntireFile(filepath string, blob []byte) error {
 	err := os.WriteFile(filepath, blob, 0644)
 	if err != nil {
@@ -423,6 +610,10 @@ func RestoreEntireFile(filepath string, blob []byte) error {
 }
 
 func RestorePartialFile(filepath string, blobToRestore []byte, options types.ConfigBackupOptions) error {
+	if err := options.CheckRequiredOptions(); err != nil {
+		return err
+	}
+
 	var dataToRestore yaml.Node
 	if err := yaml.Unmarshal(blobToRestore, &dataToRestore); err != nil {
 		return fmt.Errorf("failed to parse backup YAML: %w", err)
@@ -439,12 +630,12 @@ func RestorePartialFile(filepath string, blobToRestore []byte, options types.Con
 		return fmt.Errorf("failed to parse existing YAML in %s: %w", filepath, err)
 	}
 
-	if len(rootNode.Content) == 0 || rootNode.Content[0].Kind != yaml.SequenceNode {
-		return fmt.Errorf("expected a YAML sequence at root")
+	items, err := multipleConfigItems(&rootNode)
+	if err != nil {
+		return err
 	}
 
-	contentNode := rootNode.Content[0]
-	for _, yamlNode := range contentNode.Content {
+	for _, yamlNode := range items {
 		existingNodeId := types.GetYamlNodeValue(yamlNode, *options.IdNode)
 		if existingNodeId == nodeIdToRestore {
 			*yamlNode = *dataToRestore.Content[0]
@@ -464,6 +655,60 @@ func RestorePartialFile(filepath string, blobToRestore []byte, options types.Con
 	return nil
 }
 
+// RestoreKeyedPartialFile replaces the value of a single key in a YAML mapping file,
+// re-adding the key at the end of the mapping if it no longer exists
+func RestoreKeyedPartialFile(filepath string, blobToRestore []byte) error {
+	var dataToRestore yaml.Node
+	if err := yaml.Unmarshal(blobToRestore, &dataToRestore); err != nil {
+		return fmt.Errorf("failed to parse backup YAML: %w", err)
+	}
+
+	if len(dataToRestore.Content) == 0 || dataToRestore.Content[0].Kind != yaml.MappingNode || len(dataToRestore.Content[0].Content) != 2 {
+		return fmt.Errorf("expected backup to contain a YAML mapping with a single key")
+	}
+	keyToRestore := dataToRestore.Content[0].Content[0]
+	valueToRestore := dataToRestore.Content[0].Content[1]
+
+	currentData, err := os.ReadFile(filepath)
+	if err != nil {
+		return fmt.Errorf("failed to read existing config file %s: %w", filepath, err)
+	}
+
+	var rootNode yaml.Node
+	if err := yaml.Unmarshal(currentData, &rootNode); err != nil {
+		return fmt.Errorf("failed to parse existing YAML in %s: %w", filepath, err)
+	}
+
+	if len(rootNode.Content) == 0 || rootNode.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("expected a YAML mapping at root")
+	}
+
+	contentNode := rootNode.Content[0]
+	found := false
+	for i := 0; i < len(contentNode.Content)-1; i += 2 {
+		if contentNode.Content[i].Value == keyToRestore.Value {
+			contentNode.Content[i+1] = valueToRestore
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		contentNode.Content = append(contentNode.Content, keyToRestore, valueToRestore)
+	}
+
+	updatedBlob, err := yaml.Marshal(contentNode)
+	if err != nil {
+		return fmt.Errorf("failed to serialize updated YAML: %w", err)
+	}
+
+	if err := os.WriteFile(filepath, updatedBlob, 0644); err != nil {
+		return fmt.Errorf("failed to write updated config file %s: %w", filepath, err)
+	}
+
+	return nil
+}
+
 // DeleteBackup deletes a single backup file and returns an error if it fails
 func DeleteBackup(backupFolder, group, id, filename string) error {
 	// Validate path components for directory traversal
@@ -493,6 +738,18 @@ func DeleteBackup(backupFolder, group, id, filename string) error {
 	return nil
 }
 
+// RecountMetadata updates the backup count and size of a config's metadata from its backup directory and saves it
+func RecountMetadata(backupDirectory string, metadata *types.ConfigMetadata) error {
+	backupsCount, backupsSize, err := dirMetrics(backupDirectory)
+	if err != nil {
+		return fmt.Errorf("failed to get directory metrics for %s: %w", backupDirectory, err)
+	}
+
+	metadata.BackupCount = backupsCount
+	metadata.BackupsSize = backupsSize
+	return SaveMetadata(backupDirectory, metadata)
+}
+
 // UpdateMetadataAfterDeletion updates the metadata.json after a backup is deleted
 // Returns nil metadata if no backups remain
 func UpdateMetadataAfterDeletion(backupFolder, group, id string) (*types.ConfigMetadata, error) {
@@ -1,9 +1,17 @@
 package io_test
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"ha-config-history/internal/io"
 	"ha-config-history/internal/types"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -23,6 +31,7 @@ func Test_ReadMultipleConfigsFromSingleFile(t *testing.T) {
 				BackupType:   "multiple",
 				FilePath:     "test-data/" + fileName,
 				Hash:         "qJQn1jJ7Lx7ga0jRDb6560qQnU4=",
+				RawHash:      "qJQn1jJ7Lx7ga0jRDb6560qQnU4=",
 				Blob: []uint8(`id: "example-1"
 alias: "Sample Multi Example 1"
 description: "An example demonstrating multiple configurations."
@@ -47,6 +56,7 @@ tags:
 				BackupType:   "multiple",
 				FilePath:     "test-data/" + fileName,
 				Hash:         "nrgwnIiZKhZdxqYOgmkmkRVwyn0=",
+				RawHash:      "nrgwnIiZKhZdxqYOgmkmkRVwyn0=",
 				Blob: []uint8(`id: "example-2"
 alias: "Sample Multi Example 2"
 description: "An example demonstrating multiple configurations."
@@ -84,6 +94,275 @@ tags:
 			t.Errorf("Config backups do not match expected:\n%s", diff)
 		}
 	})
+
+	t.Run("Reads a mapping at root as a single item", func(t *testing.T) {
+		configBackups, err := io.ReadMultipleConfigsFromSingleFile("test-data/includes-dirs",
+			types.NewMultipleConfigBackupOptions("manual/wake_up.yaml", "manual/wake_up.yaml", "id", "alias"))
+
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if len(configBackups) != 1 {
+			t.Fatalf("Expected 1 config backup, got: %d", len(configBackups))
+		}
+
+		if configBackups[0].ID != "1700000000003" || configBackups[0].FriendlyName != "Wake up" {
+			t.Errorf("Unexpected id or friendly name: %s, %s", configBackups[0].ID, configBackups[0].FriendlyName)
+		}
+	})
+}
+
+func Test_RestorePartialFile(t *testing.T) {
+	t.Run("Replaces the item of a single item file", func(t *testing.T) {
+		targetFile := filepath.Join(t.TempDir(), "wake_up.yaml")
+		if err := os.WriteFile(targetFile, []byte("id: \"1700000000003\"\nalias: Wake up late\n"), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+
+		options := types.NewMultipleConfigBackupOptions("wake_up.yaml", "wake_up.yaml", "id", "alias")
+		backup := []byte("id: \"1700000000003\"\nalias: Wake up\n")
+		if err := io.RestorePartialFile(targetFile, backup, *options); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		restored, err := os.ReadFile(targetFile)
+		if err != nil {
+			t.Fatalf("Failed to read restored file: %v", err)
+		}
+
+		if diff := cmp.Diff("id: \"1700000000003\"\nalias: Wake up\n", string(restored)); diff != "" {
+			t.Errorf("Restored file does not match expected:\n%s", diff)
+		}
+	})
+}
+
+func Test_NodeExpressions(t *testing.T) {
+	t.Run("Reads ids and friendly names using fallbacks, nested paths and templates", func(t *testing.T) {
+		configBackups, err := io.ReadMultipleConfigsFromSingleFile("test-data",
+			types.NewMultipleConfigBackupOptions(
+				"multiple",
+				"sample-multi.yaml",
+				"unique_id|id",
+				"{{alias}} ({{config.settingB}}, {{tags.1}})",
+			))
+
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if configBackups[0].ID != "example-1" {
+			t.Errorf("Expected fallback id 'example-1', got: %s", configBackups[0].ID)
+		}
+
+		if configBackups[0].FriendlyName != "Sample Multi Example 1 (valueA, multi-config)" {
+			t.Errorf("Unexpected friendly name: %s", configBackups[0].FriendlyName)
+		}
+	})
+
+	t.Run("Reads nested values from json elements", func(t *testing.T) {
+		configBackups, err := io.ReadJsonConfigsFromSingleFile("test-data",
+			types.NewJsonConfigBackupOptions("json", "storage/input_boolean", "data.items", "id", "icon|name"))
+
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if configBackups[0].FriendlyName != "mdi:party-popper" || configBackups[1].FriendlyName != "Guest Mode" {
+			t.Errorf("Unexpected friendly names: %s, %s", configBackups[0].FriendlyName, configBackups[1].FriendlyName)
+		}
+	})
+
+	t.Run("Rejects invalid expressions", func(t *testing.T) {
+		for _, expression := range []string{"", "id||alias", "config..settingA", "{{alias} ({{id}})", "{{}}"} {
+			if err := types.ValidateNodeExpression(expression); err == nil {
+				t.Errorf("Expected an error for expression %q", expression)
+			}
+		}
+
+		for _, expression := range []string{"id", "id|unique_id", "trigger.0.entity_id", "{{ alias }} ({{id}})"} {
+			if err := types.ValidateNodeExpression(expression); err != nil {
+				t.Errorf("Expected no error for expression %q, got: %v", expression, err)
+			}
+		}
+	})
+}
+
+func Test_ReadKeyedConfigsFromSingleFile(t *testing.T) {
+	t.Run("Creates a config per key from a mapping file", func(t *testing.T) {
+		fileName := "sample-keyed.yaml"
+
+		expected := []*types.ConfigBackup{
+			{
+				ConfigIdentifier: types.ConfigIdentifier{
+					ID:    "morning_lights",
+					Group: fileName,
+				},
+				FriendlyName: "Morning Lights",
+				BackupType:   "mapping",
+				FilePath:     "test-data/" + fileName,
+				Blob: []uint8(`morning_lights:
+    alias: "Morning Lights"
+    sequence:
+        - service: light.turn_on
+          target:
+            entity_id: light.kitchen
+`),
+			},
+			{
+				ConfigIdentifier: types.ConfigIdentifier{
+					ID:    "night_mode",
+					Group: fileName,
+				},
+				FriendlyName: "night_mode",
+				BackupType:   "mapping",
+				FilePath:     "test-data/" + fileName,
+				Blob: []uint8(`night_mode:
+    sequence:
+        - service: light.turn_off
+`),
+			},
+		}
+
+		configBackups, err := io.ReadKeyedConfigsFromSingleFile("test-data",
+			types.NewMappingConfigBackupOptions(
+				"mapping",
+				fileName,
+				"alias",
+			))
+
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if len(configBackups) != 2 {
+			t.Errorf("Expected 2 config backups, got: %d", len(configBackups))
+		}
+
+		if diff := cmp.Diff(expected, configBackups, cmpopts.IgnoreFields(types.ConfigBackup{}, "ModifiedDate", "Hash", "RawHash")); diff != "" {
+			t.Errorf("Config backups do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Returns an error when the root is not a mapping", func(t *testing.T) {
+		_, err := io.ReadKeyedConfigsFromSingleFile("test-data",
+			types.NewMappingConfigBackupOptions(
+				"mapping",
+				"sample-multi.yaml",
+				"alias",
+			))
+
+		if err == nil {
+			t.Fatal("Expected an error for a sequence root, got nil")
+		}
+	})
+}
+
+func Test_IgnoreRules(t *testing.T) {
+	readHashes := func(t *testing.T, options *types.ConfigBackupOptions, before, after string) (string, string) {
+		beforeBackup, err := io.ReadSingleConfigFromSingleFilename("test-data/volatile", before, options)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		afterBackup, err := io.ReadSingleConfigFromSingleFilename("test-data/volatile", after, options)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return beforeBackup.Hash, afterBackup.Hash
+	}
+
+	t.Run("Ignored keys do not change the hash", func(t *testing.T) {
+		options := types.NewDirectoryConfigBackupOptions("volatile", "volatile", []string{}, []string{})
+		options.IgnoreKeys = []string{"data.last_updated", "data.items.*.value"}
+
+		before, after := readHashes(t, options, "counter-before", "counter-after")
+		if before != after {
+			t.Errorf("Expected hashes to match when only ignored keys change, got %s and %s", before, after)
+		}
+	})
+
+	t.Run("Changes outside ignored keys still change the hash", func(t *testing.T) {
+		options := types.NewDirectoryConfigBackupOptions("volatile", "volatile", []string{}, []string{})
+		options.IgnoreKeys = []string{"data.last_updated"}
+
+		before, after := readHashes(t, options, "counter-before", "counter-after")
+		if before == after {
+			t.Error("Expected hashes to differ when a tracked value changes")
+		}
+	})
+
+	t.Run("Ignored lines do not change the hash and the original content is kept", func(t *testing.T) {
+		options := types.NewDirectoryConfigBackupOptions("volatile", "volatile", []string{}, []string{})
+		options.IgnoreLinePatterns = []string{`^# generated:`}
+
+		before, after := readHashes(t, options, "notes-before.yaml", "notes-after.yaml")
+		if before != after {
+			t.Errorf("Expected hashes to match when only ignored lines change, got %s and %s", before, after)
+		}
+
+		backup, err := io.ReadSingleConfigFromSingleFilename("test-data/volatile", "notes-after.yaml", options)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if string(backup.Blob) != "# generated: 2024-01-02 18:30\ntitle: Notes\n" {
+			t.Errorf("Expected the original content to be stored, got: %q", backup.Blob)
+		}
+	})
+}
+
+func Test_CanonicalHash(t *testing.T) {
+	readBackup := func(t *testing.T, options *types.ConfigBackupOptions, fileName string) *types.ConfigBackup {
+		options.Path = fileName
+		configBackups, err := io.ReadMultipleConfigsFromSingleFile("test-data/canonical", options)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		return configBackups[0]
+	}
+
+	t.Run("Formatting only changes keep the same hash but a different raw hash", func(t *testing.T) {
+		options := types.NewMultipleConfigBackupOptions("canonical", "", "id", "alias")
+		options.CanonicalHash = true
+
+		before := readBackup(t, options, "automations-before.yaml")
+		after := readBackup(t, options, "automations-after.yaml")
+
+		if before.Hash != after.Hash {
+			t.Errorf("Expected canonical hashes to match, got %s and %s", before.Hash, after.Hash)
+		}
+		if before.RawHash == after.RawHash {
+			t.Error("Expected raw hashes to differ for a formatting change")
+		}
+		if !types.IsFormatOnlyChange(before.Blob, after.Blob) {
+			t.Error("Expected the change to be flagged as formatting only")
+		}
+	})
+
+	t.Run("Meaningful changes change the hash", func(t *testing.T) {
+		options := types.NewMultipleConfigBackupOptions("canonical", "", "id", "alias")
+		options.CanonicalHash = true
+
+		before := readBackup(t, options, "automations-before.yaml")
+		changed := readBackup(t, options, "automations-changed.yaml")
+
+		if before.Hash == changed.Hash {
+			t.Error("Expected canonical hashes to differ for a meaningful change")
+		}
+		if types.IsFormatOnlyChange(before.Blob, changed.Blob) {
+			t.Error("Expected the change not to be flagged as formatting only")
+		}
+	})
+
+	t.Run("Formatting changes change the hash when canonical hashing is disabled", func(t *testing.T) {
+		options := types.NewMultipleConfigBackupOptions("canonical", "", "id", "alias")
+
+		before := readBackup(t, options, "automations-before.yaml")
+		after := readBackup(t, options, "automations-after.yaml")
+
+		if before.Hash == after.Hash {
+			t.Error("Expected hashes to differ without canonical hashing")
+		}
+	})
 }
 
 func Test_ReadSingleConfigFromSingleFile(t *testing.T) {
@@ -100,6 +379,7 @@ func Test_ReadSingleConfigFromSingleFile(t *testing.T) {
 				BackupType:   "single",
 				FilePath:     "test-data/" + fileName,
 				Hash:         "0H41D6gI8C3iCdjTHiELk6-vA8g=",
+				RawHash:      "0H41D6gI8C3iCdjTHiELk6-vA8g=",
 				Blob: []uint8(`description: "An example of a single configuration file, with no name or id"
 config:
   settingA: true
@@ -139,6 +419,7 @@ func Test_ReadMultipleConfigsFromDirectory(t *testing.T) {
 				ConfigIdentifier: types.ConfigIdentifier{ID: "random-file", Group: "sample-dir"},
 				FriendlyName:     "random-file",
 				Hash:             "M_oQwxaUJMNm2qj-MCmIQ_DBZ7w=",
+				RawHash:          "M_oQwxaUJMNm2qj-MCmIQ_DBZ7w=",
 				BackupType:       "directory",
 				FilePath:         "test-data/sample-dir/random-file",
 				Blob:             []uint8("This isn't yaml"),
@@ -152,6 +433,7 @@ func Test_ReadMultipleConfigsFromDirectory(t *testing.T) {
 				BackupType:   "directory",
 				FilePath:     "test-data/" + directoryName + "/sample-single-id.yaml",
 				Hash:         "kFKAAmZ2efLN5BnpAo2CD5wNnd8=",
+				RawHash:      "kFKAAmZ2efLN5BnpAo2CD5wNnd8=",
 				Blob: []uint8(
 					`description: "An example of a single configuration file in a folder, with an id"
 config:
@@ -175,6 +457,7 @@ tags:
 				BackupType:   "directory",
 				FilePath:     "test-data/" + directoryName + "/sample-single.yaml",
 				Hash:         "4MkaqYb9oq4_zGrbJgePLeHc35A=",
+				RawHash:      "4MkaqYb9oq4_zGrbJgePLeHc35A=",
 				Blob: []uint8(`description: "An example of a single configuration file in a folder, with no id"
 config:
   settingA: true
@@ -308,3 +591,588 @@ tags:
 		}
 	})
 }
+
+func Test_ResolveIncludes(t *testing.T) {
+	t.Run("Follows includes, include directories and nested includes", func(t *testing.T) {
+		graph, err := io.ResolveIncludes("test-data/includes",
+			types.NewIncludesConfigBackupOptions("Configuration", "configuration.yaml"), nil)
+
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		expectedFiles := []string{
+			"automations.yaml",
+			"configuration.yaml",
+			"groups.yaml",
+			"packages/climate/heating.yaml",
+			"packages/lights.yaml",
+			"scripts.yaml",
+			"templates.yaml",
+		}
+		if diff := cmp.Diff(expectedFiles, graph.Files); diff != "" {
+			t.Errorf("Included files do not match expected:\n%s", diff)
+		}
+
+		expectedEdges := []types.IncludeEdge{
+			{From: "configuration.yaml", To: "packages", Tag: "!include_dir_named", Key: "packages"},
+			{From: "packages/climate/heating.yaml", To: "templates.yaml", Tag: "!include", Key: "template"},
+			{From: "configuration.yaml", To: "automations.yaml", Tag: "!include", Key: "automation"},
+			{From: "configuration.yaml", To: "scripts.yaml", Tag: "!include", Key: "script"},
+			{From: "configuration.yaml", To: "groups.yaml", Tag: "!include", Key: "group"},
+			{From: "configuration.yaml", To: "missing.yaml", Tag: "!include", Key: "sensor", Missing: true},
+		}
+		if diff := cmp.Diff(expectedEdges, graph.Edges); diff != "" {
+			t.Errorf("Include edges do not match expected:\n%s", diff)
+		}
+
+		backupTypes := map[string]string{}
+		for _, options := range graph.Configs {
+			backupTypes[options.Path] = options.BackupType
+		}
+		expectedBackupTypes := map[string]string{
+			"configuration.yaml": "single",
+			"packages":           "directory",
+			"packages/climate":   "directory",
+			"templates.yaml":     "single",
+			"automations.yaml":   "multiple",
+			"scripts.yaml":       "mapping",
+			"groups.yaml":        "single",
+		}
+		if diff := cmp.Diff(expectedBackupTypes, backupTypes); diff != "" {
+			t.Errorf("Derived backup types do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Tracks the files of included automation, scene and script directories per item", func(t *testing.T) {
+		graph, err := io.ResolveIncludes("test-data/includes-dirs",
+			types.NewIncludesConfigBackupOptions("Configuration", "configuration.yaml"), nil)
+
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		backupTypes := map[string]string{}
+		for _, options := range graph.Configs {
+			backupTypes[options.Path] = options.BackupType
+		}
+		expectedBackupTypes := map[string]string{
+			"configuration.yaml":      "single",
+			"automations/lights.yaml": "multiple",
+			"manual/wake_up.yaml":     "multiple",
+			"scenes.yaml":             "multiple",
+			"scripts/morning.yaml":    "mapping",
+		}
+		if diff := cmp.Diff(expectedBackupTypes, backupTypes); diff != "" {
+			t.Errorf("Derived backup types do not match expected:\n%s", diff)
+		}
+
+		for _, options := range graph.Configs {
+			if options.BackupType != "multiple" {
+				continue
+			}
+			configBackups, err := io.ReadMultipleConfigsFromSingleFile("test-data/includes-dirs", options)
+			if err != nil {
+				t.Fatalf("Expected no error reading %s, got: %v", options.Path, err)
+			}
+			for _, configBackup := range configBackups {
+				if configBackup.ID == configBackup.Group || configBackup.FriendlyName == "" {
+					t.Errorf("Expected %s to keep its id and friendly name, got: %s, %s",
+						options.Path, configBackup.ID, configBackup.FriendlyName)
+				}
+			}
+		}
+	})
+
+	t.Run("Leaves out paths tracked by other configs", func(t *testing.T) {
+		configs := []*types.ConfigBackupOptions{
+			types.NewIncludesConfigBackupOptions("Configuration", "configuration.yaml"),
+			types.NewMultipleConfigBackupOptions("Scenes", "scenes.yaml", "id", "name"),
+			types.NewMultipleConfigBackupOptions("Lights", "automations/lights.yaml", "id", "alias"),
+		}
+		graph, err := io.ResolveIncludes("test-data/includes-dirs", configs[0], configs)
+
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		paths := []string{}
+		for _, options := range graph.Configs {
+			paths = append(paths, options.Path)
+		}
+		sort.Strings(paths)
+		expectedPaths := []string{"configuration.yaml", "manual/wake_up.yaml", "scripts/morning.yaml"}
+		if diff := cmp.Diff(expectedPaths, paths); diff != "" {
+			t.Errorf("Derived config paths do not match expected:\n%s", diff)
+		}
+
+		if !slices.Contains(graph.Files, "scenes.yaml") {
+			t.Errorf("Expected scenes.yaml to stay in the include graph, got: %v", graph.Files)
+		}
+	})
+}
+
+func Test_ReadJsonConfigsFromSingleFile(t *testing.T) {
+	t.Run("Creates a config per list element", func(t *testing.T) {
+		fileName := "storage/input_boolean"
+
+		expected := []*types.ConfigBackup{
+			{
+				ConfigIdentifier: types.ConfigIdentifier{ID: "party_mode", Group: fileName},
+				FriendlyName:     "Party Mode",
+				BackupType:       "json",
+				FilePath:         "test-data/" + fileName,
+				Blob: []uint8(`{
+  "id": "party_mode",
+  "name": "Party Mode",
+  "icon": "mdi:party-popper"
+}
+`),
+			},
+			{
+				ConfigIdentifier: types.ConfigIdentifier{ID: "guest_mode", Group: fileName},
+				FriendlyName:     "Guest Mode",
+				BackupType:       "json",
+				FilePath:         "test-data/" + fileName,
+				Blob: []uint8(`{
+  "id": "guest_mode",
+  "name": "Guest Mode"
+}
+`),
+			},
+		}
+
+		configBackups, err := io.ReadJsonConfigsFromSingleFile("test-data",
+			types.NewJsonConfigBackupOptions("json", fileName, "data.items", "id", "name"))
+
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		if diff := cmp.Diff(expected, configBackups, cmpopts.IgnoreFields(types.ConfigBackup{}, "ModifiedDate", "Hash", "RawHash")); diff != "" {
+			t.Errorf("Config backups do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Returns an error when the items path does not exist", func(t *testing.T) {
+		_, err := io.ReadJsonConfigsFromSingleFile("test-data",
+			types.NewJsonConfigBackupOptions("json", "storage/input_boolean", "data.config.views", "id", "name"))
+
+		if err == nil {
+			t.Fatal("Expected an error for a missing items path, got nil")
+		}
+	})
+
+	t.Run("Returns an error when the config has no items path", func(t *testing.T) {
+		options := types.NewJsonConfigBackupOptions("json", "storage/input_boolean", "", "id", "name")
+		options.ItemsPath = nil
+
+		_, err := io.ReadJsonConfigsFromSingleFile("test-data", options)
+		if !errors.Is(err, types.ErrMissingConfigOption) {
+			t.Fatalf("Expected ErrMissingConfigOption, got: %v", err)
+		}
+
+		options.ItemsPath, options.IdNode = options.FriendlyNameNode, nil
+		_, err = types.NewJsonConfigBackup(options.Path, "test-data/"+options.Path, []byte(`{"id": "guest_mode"}`),
+			options, time.Now())
+		if !errors.Is(err, types.ErrMissingConfigOption) {
+			t.Fatalf("Expected ErrMissingConfigOption from the constructor, got: %v", err)
+		}
+	})
+}
+
+func Test_RestoreJsonPartialFile(t *testing.T) {
+	copyToTemp := func(t *testing.T, fileName string) string {
+		content, err := os.ReadFile("test-data/storage/" + fileName)
+		if err != nil {
+			t.Fatalf("Failed to read test data file: %v", err)
+		}
+
+		targetFile := filepath.Join(t.TempDir(), fileName)
+		if err := os.WriteFile(targetFile, content, 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		return targetFile
+	}
+
+	t.Run("Returns an error when the config has no id node", func(t *testing.T) {
+		targetFile := copyToTemp(t, "input_boolean")
+		options := types.NewJsonConfigBackupOptions("json", "input_boolean", "data.items", "", "name")
+		options.IdNode = nil
+
+		err := io.RestoreJsonPartialFile(targetFile, []byte(`{"id": "guest_mode"}`), *options)
+		if !errors.Is(err, types.ErrMissingConfigOption) {
+			t.Fatalf("Expected ErrMissingConfigOption, got: %v", err)
+		}
+	})
+
+	t.Run("Replaces only the matching element in an indented file", func(t *testing.T) {
+		targetFile := copyToTemp(t, "input_boolean")
+		options := types.NewJsonConfigBackupOptions("json", "input_boolean", "data.items", "id", "name")
+
+		backup := []byte(`{
+  "id": "guest_mode",
+  "name": "Visitors",
+  "icon": "mdi:account"
+}
+`)
+		if err := io.RestoreJsonPartialFile(targetFile, backup, *options); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		restored, err := os.ReadFile(targetFile)
+		if err != nil {
+			t.Fatalf("Failed to read restored file: %v", err)
+		}
+
+		expected := `{
+  "version": 1,
+  "minor_version": 1,
+  "key": "input_boolean",
+  "data": {
+    "items": [
+      {
+        "id": "party_mode",
+        "name": "Party Mode",
+        "icon": "mdi:party-popper"
+      },
+      {
+        "id": "guest_mode",
+        "name": "Visitors",
+        "icon": "mdi:account"
+      }
+    ]
+  }
+}
+`
+		if diff := cmp.Diff(expected, string(restored)); diff != "" {
+			t.Errorf("Restored file does not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Appends a removed element to a compact file", func(t *testing.T) {
+		targetFile := copyToTemp(t, "person")
+		options := types.NewJsonConfigBackupOptions("json", "person", "data.items", "id", "name")
+
+		backup := []byte(`{
+  "id": "carol",
+  "name": "Carol",
+  "user_id": null
+}
+`)
+		if err := io.RestoreJsonPartialFile(targetFile, backup, *options); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		restored, err := os.ReadFile(targetFile)
+		if err != nil {
+			t.Fatalf("Failed to read restored file: %v", err)
+		}
+
+		expected := `{"version":1,"key":"person","data":{"items":[{"id":"alice","name":"Alice","user_id":null},` +
+			`{"id":"bob","name":"Bob","user_id":null},{"id":"carol","name":"Carol","user_id":null}],"storage_version":1}}`
+		if diff := cmp.Diff(expected, string(restored)); diff != "" {
+			t.Errorf("Restored file does not match expected:\n%s", diff)
+		}
+
+		if !json.Valid(restored) {
+			t.Error("Restored file is not valid JSON")
+		}
+	})
+}
+
+func Test_StatCache(t *testing.T) {
+	t.Run("Persists entries and invalidates by group", func(t *testing.T) {
+		backupDir := t.TempDir()
+		filePath := filepath.Join(t.TempDir(), "automations.yaml")
+		if err := os.WriteFile(filePath, []byte("- id: one\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		stat, err := io.StatFile(filePath)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		hash, err := io.HashFile(filePath)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		cache, err := io.LoadStatCache(backupDir)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		entry := io.StatCacheEntry{FileStat: stat, Group: "automations.yaml", Hash: hash, OptionsHash: "options"}
+		if err := cache.Update(map[string]io.StatCacheEntry{filePath: entry}); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		reloaded, err := io.LoadStatCache(backupDir)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		actual, exists := reloaded.Get(filePath)
+		if !exists {
+			t.Fatal("Expected entry to be persisted")
+		}
+		if diff := cmp.Diff(entry, actual); diff != "" {
+			t.Errorf("Stat cache entry does not match expected:\n%s", diff)
+		}
+
+		if err := reloaded.InvalidateGroup("automations.yaml"); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if _, exists := reloaded.Get(filePath); exists {
+			t.Fatal("Expected entry to be removed")
+		}
+	})
+
+	t.Run("Options hash changes with ignore rules", func(t *testing.T) {
+		options := types.NewSingleConfigBackupOptions("Config", "configuration.yaml")
+		before := io.OptionsHash(options)
+		options.IgnoreKeys = []string{"last_updated"}
+		if before == io.OptionsHash(options) {
+			t.Fatal("Expected options hash to change")
+		}
+	})
+}
+
+func Test_BinaryFiles(t *testing.T) {
+	t.Run("Binary files are streamed instead of read into memory", func(t *testing.T) {
+		rootDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(rootDir, "www"), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		image := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+		if err := os.WriteFile(filepath.Join(rootDir, "www", "logo.png"), image, 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(rootDir, "www", "style.css"), []byte("body {}\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		options := types.NewDirectoryConfigBackupOptions("www", "www", nil, nil)
+		configBackups, err := io.ReadMultipleConfigsFromDirectory(rootDir, options)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(configBackups) != 2 {
+			t.Fatalf("Expected 2 backups, got: %d", len(configBackups))
+		}
+
+		logo, style := configBackups[0], configBackups[1]
+		if !logo.Binary || logo.Blob != nil || logo.Size != int64(len(image)) {
+			t.Errorf("Expected streamed binary backup, got binary: %v, size: %d", logo.Binary, logo.Size)
+		}
+		if style.Binary || string(style.Blob) != "body {}\n" {
+			t.Errorf("Expected text backup to be read, got binary: %v", style.Binary)
+		}
+
+		backupDir := t.TempDir()
+		if err := io.SaveConfigBackup(logo, backupDir); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		saved, err := os.ReadFile(filepath.Join(backupDir, logo.ModifiedDate.Format("20060102T150405")+".backup"))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if string(saved) != string(image) {
+			t.Error("Expected saved backup to match the original file")
+		}
+	})
+
+	t.Run("Files over the max file size are skipped", func(t *testing.T) {
+		rootDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(rootDir, "themes"), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(rootDir, "themes", "large.yaml"), []byte("a: 1234567890\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(rootDir, "themes", "small.yaml"), []byte("a: 1\n"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		maxFileSize := int64(8)
+		options := types.NewDirectoryConfigBackupOptions("themes", "themes", nil, nil)
+		options.MaxFileSizeBytes = &maxFileSize
+
+		configBackups, err := io.ReadMultipleConfigsFromDirectory(rootDir, options)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(configBackups) != 1 || configBackups[0].ID != "small.yaml" {
+			t.Fatalf("Expected only small.yaml to be backed up, got: %d backups", len(configBackups))
+		}
+	})
+
+	t.Run("Detects binary content", func(t *testing.T) {
+		testCases := map[string]bool{
+			"key: value\n":       false,
+			"emoji: 🏠\n":         false,
+			"nul\x00byte":        true,
+			"invalid \xff\xfe":   true,
+			"truncated \xf0\x9f": false,
+		}
+		for content, expected := range testCases {
+			if actual := io.IsBinary([]byte(content)); actual != expected {
+				t.Errorf("Expected IsBinary(%q) to be %v", content, expected)
+			}
+		}
+	})
+}
+
+func Test_ExpiredBackups(t *testing.T) {
+	writeBackups := func(t *testing.T, filenames ...string) string {
+		backupDir := t.TempDir()
+		for _, filename := range filenames {
+			if err := os.WriteFile(filepath.Join(backupDir, filename), []byte("id: one\n"), 0644); err != nil {
+				t.Fatalf("Failed to write backup: %v", err)
+			}
+		}
+		return backupDir
+	}
+	maxBackups := func(n int) *int { return &n }
+
+	t.Run("Backups beyond max backups and max age expire", func(t *testing.T) {
+		backupDir := writeBackups(t, "20240101T000000.backup", "20240102T000000.backup",
+			"20240103T000000.backup", "20240104T000000.backup")
+
+		oldestAllowed := time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)
+		expired, err := io.ExpiredBackups(backupDir, maxBackups(3), oldestAllowed)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		expected := []io.ExpiredBackup{
+			{Filename: "20240102T000000.backup", Reason: "older than max backup age"},
+			{Filename: "20240101T000000.backup", Reason: "exceeded max backups limit"},
+		}
+		if diff := cmp.Diff(expected, expired); diff != "" {
+			t.Errorf("Expired backups do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Max age applies to legacy yaml backups too", func(t *testing.T) {
+		backupDir := writeBackups(t, "20240101T000000.yaml", "20240102T000000.backup", "20240103T000000.backup")
+
+		expired, err := io.ExpiredBackups(backupDir, maxBackups(10), time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		expected := []io.ExpiredBackup{
+			{Filename: "20240102T000000.backup", Reason: "older than max backup age"},
+			{Filename: "20240101T000000.yaml", Reason: "older than max backup age"},
+		}
+		if diff := cmp.Diff(expected, expired); diff != "" {
+			t.Errorf("Expired backups do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("The latest backup is always kept", func(t *testing.T) {
+		backupDir := writeBackups(t, "20240101T000000.backup", "20240102T000000.backup")
+
+		expired, err := io.ExpiredBackups(backupDir, maxBackups(1), time.Now())
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		expected := []io.ExpiredBackup{
+			{Filename: "20240101T000000.backup", Reason: "exceeded max backups limit"},
+		}
+		if diff := cmp.Diff(expected, expired); diff != "" {
+			t.Errorf("Expected only the older backup to expire, got:\n%s", diff)
+		}
+	})
+
+	t.Run("Backups beyond max backups expire", func(t *testing.T) {
+		backupDir := writeBackups(t, "20240101T000000.backup", "20240102T000000.backup",
+			"20240103T000000.backup", "20240104T000000.backup")
+
+		expired, err := io.ExpiredBackups(backupDir, maxBackups(3), time.Unix(0, 0))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		expected := []io.ExpiredBackup{
+			{Filename: "20240101T000000.backup", Reason: "exceeded max backups limit"},
+		}
+		if diff := cmp.Diff(expected, expired); diff != "" {
+			t.Errorf("Expired backups do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Nothing expires without max backups", func(t *testing.T) {
+		backupDir := writeBackups(t, "20240101T000000.backup", "20240102T000000.backup")
+
+		expired, err := io.ExpiredBackups(backupDir, nil, time.Now())
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(expired) != 0 {
+			t.Fatalf("Expected no expired backups, got: %v", expired)
+		}
+	})
+}
+
+func Test_PruneSettingsVersions(t *testing.T) {
+	saveVersions := func(t *testing.T, count int) string {
+		backupDir := t.TempDir()
+		date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < count; i++ {
+			data := []byte(fmt.Sprintf(`{"port": ":%d"}`, 40000+i))
+			if _, err := io.SaveSettingsVersion(backupDir, data, date.AddDate(0, 0, i)); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+		}
+		return backupDir
+	}
+	maxVersions := func(n int) *int { return &n }
+
+	t.Run("Versions beyond the retention limits are removed", func(t *testing.T) {
+		backupDir := saveVersions(t, 4)
+
+		expired, err := io.PruneSettingsVersions(backupDir, maxVersions(2), time.Unix(0, 0))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(expired) != 2 {
+			t.Fatalf("Expected 2 versions to be removed, got: %v", expired)
+		}
+
+		versions, err := io.ListSettingsVersions(backupDir)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		filenames := []string{}
+		for _, version := range versions {
+			filenames = append(filenames, version.Filename)
+		}
+		expected := []string{"20240104T000000.backup", "20240103T000000.backup"}
+		if diff := cmp.Diff(expected, filenames); diff != "" {
+			t.Errorf("Remaining versions do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Versions are capped without max backups", func(t *testing.T) {
+		backupDir := saveVersions(t, io.MaxSettingsVersions+3)
+
+		if _, err := io.PruneSettingsVersions(backupDir, nil, time.Unix(0, 0)); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		versions, err := io.ListSettingsVersions(backupDir)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(versions) != io.MaxSettingsVersions {
+			t.Errorf("Expected %d versions, got: %d", io.MaxSettingsVersions, len(versions))
+		}
+	})
+
+	t.Run("No history", func(t *testing.T) {
+		expired, err := io.PruneSettingsVersions(t.TempDir(), nil, time.Now())
+		if err != nil || len(expired) != 0 {
+			t.Errorf("Expected nothing to be removed, got: %v, %v", expired, err)
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"ha-config-history/internal/types"
+	"os"
+	"strings"
+	"time"
+)
+
+// jsonElement is a single element of a JSON list along with its byte range in the source file
+type jsonElement struct {
+	Start int
+	End   int
+	Raw   json.RawMessage
+}
+
+// jsonList is a JSON list found by following an items path, with the offset of its closing bracket
+type jsonList struct {
+	Start    int
+	End      int
+	Elements []jsonElement
+}
+
+func ReadJsonConfigsFromSingleFile(rootPath string, config *types.ConfigBackupOptions) ([]*types.ConfigBackup, error) {
+	if err := config.CheckRequiredOptions(); err != nil {
+		return nil, err
+	}
+
+	currentTime := time.Now().UTC()
+	filePath := rootPath + "/" + config.Path
+
+	configBackups := []*types.ConfigBackup{}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+
+	list, err := findJsonList(data, *config.ItemsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find items in %s: %w", filePath, err)
+	}
+
+	for _, element := range list.Elements {
+		configBackup, err := types.NewJsonConfigBackup(config.Path, filePath, element.Raw, config, currentTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create config backup for %s: %w", filePath, err)
+		}
+		configBackups = append(configBackups, configBackup)
+	}
+
+	return configBackups, nil
+}
+
+// RestoreJsonPartialFile replaces a single element of a JSON list, matched by its id, leaving the rest of the
+// file byte for byte as it was. The restored element is written using the indentation style of the file, and is
+// appended to the list if it no longer exists.
+func RestoreJsonPartialFile(filepath string, blobToRestore []byte, options types.ConfigBackupOptions) error {
+	if err := options.CheckRequiredOptions(); err != nil {
+		return err
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, blobToRestore); err != nil {
+		return fmt.Errorf("failed to parse backup JSON: %w", err)
+	}
+	idToRestore := types.GetJsonValue(compact.Bytes(), *options.IdNode)
+
+	currentData, err := os.ReadFile(filepath)
+	if err != nil {
+		return fmt.Errorf("failed to read existing config file %s: %w", filepath, err)
+	}
+
+	list, err := findJsonList(currentData, *options.ItemsPath)
+	if err != nil {
+		return fmt.Errorf("failed to find items in %s: %w", filepath, err)
+	}
+
+	indent := detectJsonIndent(currentData)
+
+	var updated bytes.Buffer
+	replaced := false
+	for _, element := range list.Elements {
+		if types.GetJsonValue(element.Raw, *options.IdNode) != idToRestore {
+			continue
+		}
+
+		updated.Write(currentData[:element.Start])
+		updated.Write(formatJsonElement(compact.Bytes(), linePrefix(currentData, element.Start), indent))
+		updated.Write(currentData[element.End:])
+		replaced = true
+		break
+	}
+
+	if !replaced {
+		if len(list.Elements) > 0 {
+			last := list.Elements[len(list.Elements)-1]
+			prefix := linePrefix(currentData, last.Start)
+			updated.Write(currentData[:last.End])
+			updated.WriteString(",")
+			if indent != "" {
+				updated.WriteString("\n" + prefix)
+			}
+			updated.Write(formatJsonElement(compact.Bytes(), prefix, indent))
+			updated.Write(currentData[last.End:])
+		} else {
+			prefix := linePrefix(currentData, list.Start)
+			updated.Write(currentData[:list.Start+1])
+			if indent != "" {
+				updated.WriteString("\n" + prefix + indent)
+			}
+			updated.Write(formatJsonElement(compact.Bytes(), prefix+indent, indent))
+			if indent != "" {
+				updated.WriteString("\n" + prefix)
+			}
+			updated.Write(currentData[list.End:])
+		}
+	}
+
+	if !json.Valid(updated.Bytes()) {
+		return fmt.Errorf("restoring backup would produce invalid JSON in %s", filepath)
+	}
+
+	if err := os.WriteFile(filepath, updated.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write updated config file %s: %w", filepath, err)
+	}
+
+	return nil
+}
+
+// findJsonList follows a dotted path of object keys (eg. data.config.views) to a JSON list,
+// recording where each element starts and ends without re-encoding anything
+func findJsonList(data []byte, itemsPath string) (*jsonList, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	path := []string{}
+	if itemsPath != "" {
+		path = strings.Split(itemsPath, ".")
+	}
+
+	for _, segment := range path {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '{' {
+			return nil, fmt.Errorf("expected an object at %s", segment)
+		}
+
+		found := false
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse JSON: %w", err)
+			}
+			if key == segment {
+				found = true
+				break
+			}
+
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return nil, fmt.Errorf("failed to parse JSON: %w", err)
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("key %s not found in items path %s", segment, itemsPath)
+		}
+	}
+
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("expected a list at items path %s", itemsPath)
+	}
+
+	list := &jsonList{
+		Start:    int(decoder.InputOffset()) - 1,
+		Elements: []jsonElement{},
+	}
+
+	for decoder.More() {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		end := int(decoder.InputOffset())
+		list.Elements = append(list.Elements, jsonElement{Start: end - len(raw), End: end, Raw: raw})
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	list.End = int(decoder.InputOffset()) - 1
+
+	return list, nil
+}
+
+// detectJsonIndent returns the indentation used by the first indented line, or an empty string for compact JSON
+func detectJsonIndent(data []byte) string {
+	lines := bytes.Split(data, []byte("\n"))
+	for _, line := range lines[1:] {
+		trimmed := bytes.TrimLeft(line, " \t")
+		if len(trimmed) > 0 && len(trimmed) < len(line) {
+			return string(line[:len(line)-len(trimmed)])
+		}
+	}
+	return ""
+}
+
+func linePrefix(data []byte, offset int) string {
+	lineStart := bytes.LastIndexByte(data[:offset], '\n') + 1
+	line := data[lineStart:offset]
+	return string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+}
+
+func formatJsonElement(compact []byte, prefix, indent string) []byte {
+	if indent == "" {
+		return compact
+	}
+
+	var formatted bytes.Buffer
+	if err := json.Indent(&formatted, compact, prefix, indent); err != nil {
+		return compact
+	}
+	return formatted.Bytes()
+}
@@ -0,0 +1,48 @@
+package io
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ha-config-history/internal/types"
+	"os"
+	"path/filepath"
+)
+
+const pauseStateFilename = "pause-state.json"
+
+// LoadPauseState reads the pause state from the backup directory, returning an empty state if nothing is paused
+func LoadPauseState(backupFolder string) (*types.PauseState, error) {
+	state := &types.PauseState{Configs: map[string]*types.Pause{}}
+
+	path := filepath.Join(backupFolder, pauseStateFilename)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read pause state %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		empty := &types.PauseState{Configs: map[string]*types.Pause{}}
+		return empty, fmt.Errorf("failed to parse pause state %s: %w", path, err)
+	}
+	if state.Configs == nil {
+		state.Configs = map[string]*types.Pause{}
+	}
+	return state, nil
+}
+
+func SavePauseState(backupFolder string, state *types.PauseState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize pause state: %w", err)
+	}
+
+	path := filepath.Join(backupFolder, pauseStateFilename)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write pause state %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package io
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The app's own settings are versioned in the backup directory like a config, but without metadata so they aren't
+// listed with the configs
+const (
+	SettingsHistoryGroup = ".settings"
+	SettingsHistoryID    = "config.json"
+)
+
+// MaxSettingsVersions is how many settings versions are kept when no max backups limit is set
+const MaxSettingsVersions = 100
+
+// SaveSettingsVersion stores settings as a new version unless they match the latest version, returning the filename
+// of the new version or "" if nothing was saved. Versions saved in the same second get the next free second.
+func SaveSettingsVersion(backupFolder string, data []byte, date time.Time) (string, error) {
+	historyFolder := filepath.Join(backupFolder, SettingsHistoryGroup, SettingsHistoryID)
+	if err := os.MkdirAll(historyFolder, 0755); err != nil {
+		return "", fmt.Errorf("failed to create settings history directory %s: %w", historyFolder, err)
+	}
+
+	versions, err := ListConfigBackups(backupFolder, SettingsHistoryGroup, SettingsHistoryID)
+	if err != nil {
+		return "", err
+	}
+	if len(versions) > 0 {
+		latest, err := os.ReadFile(filepath.Join(historyFolder, versions[0].Filename))
+		if err != nil {
+			return "", fmt.Errorf("failed to read latest settings version: %w", err)
+		}
+		if bytes.Equal(latest, data) {
+			return "", nil
+		}
+		// Keep versions in order even if the clock went backwards
+		if !date.After(versions[0].Date) {
+			date = versions[0].Date.Add(time.Second)
+		}
+	}
+
+	for {
+		filename := date.UTC().Format("20060102T150405") + ".backup"
+		path := filepath.Join(historyFolder, filename)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			date = date.Add(time.Second)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to save settings version: %w", err)
+		}
+
+		_, err = file.Write(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to save settings version %s: %w", path, err)
+		}
+		return filename, nil
+	}
+}
+
+// PruneSettingsVersions removes settings versions beyond the retention limits, returning the removed versions. The
+// limits work like those of config backups, except at most MaxSettingsVersions are kept when maxVersions is nil.
+func PruneSettingsVersions(backupFolder string, maxVersions *int, oldestAllowed time.Time) ([]ExpiredBackup, error) {
+	historyFolder := filepath.Join(backupFolder, SettingsHistoryGroup, SettingsHistoryID)
+	if !DirectoryExists(historyFolder) {
+		return []ExpiredBackup{}, nil
+	}
+	if maxVersions == nil {
+		limit := MaxSettingsVersions
+		maxVersions = &limit
+	}
+
+	expired, err := ExpiredBackups(historyFolder, maxVersions, oldestAllowed)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range expired {
+		RemoveBackup(historyFolder, version.Filename, version.Reason)
+	}
+	return expired, nil
+}
+
+// ListSettingsVersions returns the saved settings versions, newest first
+func ListSettingsVersions(backupFolder string) ([]BackupInfo, error) {
+	if !DirectoryExists(filepath.Join(backupFolder, SettingsHistoryGroup, SettingsHistoryID)) {
+		return []BackupInfo{}, nil
+	}
+	return ListConfigBackups(backupFolder, SettingsHistoryGroup, SettingsHistoryID)
+}
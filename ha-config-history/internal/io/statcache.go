@@ -0,0 +1,146 @@
+package io
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ha-config-history/internal/types"
+	goio "io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const statCacheFilename = "stat-cache.json"
+
+// FileStat is the part of a file's metadata that changes whenever its content is written
+type FileStat struct {
+	ModTime time.Time `json:"modTime"`
+	Size    int64     `json:"size"`
+	Inode   uint64    `json:"inode,omitempty"`
+}
+
+// StatCacheEntry records a tracked file as it was when its backups were last brought up to date.
+// OptionsHash fingerprints the config options, so changing them (eg. ignore rules) forces the file to be read again.
+type StatCacheEntry struct {
+	FileStat
+	Group       string `json:"group"`
+	Hash        string `json:"hash"`
+	OptionsHash string `json:"optionsHash"`
+}
+
+// StatCache is a persisted index of tracked files, used to skip reading files that haven't changed since the
+// last scan
+type StatCache struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]StatCacheEntry
+}
+
+// LoadStatCache reads the stat cache from the backup directory, starting empty if it doesn't exist or can't be read
+func LoadStatCache(backupFolder string) (*StatCache, error) {
+	cache := &StatCache{
+		path:    filepath.Join(backupFolder, statCacheFilename),
+		entries: map[string]StatCacheEntry{},
+	}
+
+	data, err := os.ReadFile(cache.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, fmt.Errorf("failed to read stat cache %s: %w", cache.path, err)
+	}
+
+	if err := json.Unmarshal(data, &cache.entries); err != nil {
+		cache.entries = map[string]StatCacheEntry{}
+		return cache, fmt.Errorf("failed to parse stat cache %s: %w", cache.path, err)
+	}
+	return cache, nil
+}
+
+func (c *StatCache) Get(path string) (StatCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, exists := c.entries[path]
+	return entry, exists
+}
+
+// Update records entries and writes the cache to disk
+func (c *StatCache) Update(entries map[string]StatCacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	maps.Copy(c.entries, entries)
+	return c.save()
+}
+
+// InvalidateGroup removes every file belonging to a config, so the next scan reads them again
+func (c *StatCache) InvalidateGroup(group string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := false
+	for path, entry := range c.entries {
+		if entry.Group == group {
+			delete(c.entries, path)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return c.save()
+}
+
+// save must be called with the lock held
+func (c *StatCache) save() error {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("failed to serialize stat cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create stat cache directory: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write stat cache %s: %w", c.path, err)
+	}
+	return nil
+}
+
+func StatFile(path string) (FileStat, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileStat{}, err
+	}
+	return FileStat{
+		ModTime: info.ModTime().UTC(),
+		Size:    info.Size(),
+		Inode:   FileInode(info),
+	}, nil
+}
+
+// HashFile returns the SHA-1 of a file's raw content, reading it in chunks so large files aren't held in memory
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	hasher := sha1.New()
+	if _, err := goio.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+	return base64.URLEncoding.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// OptionsHash fingerprints a config's options, so cached stats are ignored once the options change
+func OptionsHash(config *types.ConfigBackupOptions) string {
+	data, _ := json.Marshal(config)
+	sum := sha1.Sum(data)
+	return base64.URLEncoding.EncodeToString(sum[:])
+}
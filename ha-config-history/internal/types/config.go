@@ -2,30 +2,72 @@ package types
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 )
 
 type AppSettings struct {
+	SchemaVersion           int                    `json:"schemaVersion"`
 	HomeAssistantConfigDir  string                 `json:"homeAssistantConfigDir"`
 	BackupDir               string                 `json:"backupDir"`
+	HomeAssistantBackupDir  string                 `json:"homeAssistantBackupDir,omitempty"`
 	Port                    string                 `json:"port"`
 	CronSchedule            *string                `json:"cronSchedule,omitempty"`
 	DefaultMaxBackups       *int                   `json:"defaultMaxBackups,omitempty"`
 	DefaultMaxBackupAgeDays *int                   `json:"defaultMaxBackupAgeDays,omitempty"`
+	PollIntervalSeconds     *int                   `json:"pollIntervalSeconds,omitempty"`
+	BackupWorkers           *int                   `json:"backupWorkers,omitempty"`
+	QueueDepth              *int                   `json:"queueDepth,omitempty"`
 	Configs                 []*ConfigBackupOptions `json:"configs"`
+	// KnownDefaultConfigs are the keys of default configs that were tracked or offered, so they aren't offered again.
+	// A key is the default's path, followed by @revision once a release changes it.
+	KnownDefaultConfigs []string `json:"knownDefaultConfigs,omitempty"`
 }
 
 type ConfigBackupOptions struct {
 	Name                string   `json:"name"`
 	Path                string   `json:"path"`
-	BackupType          string   `json:"backupType"` // "multiple", "single", "directory"
+	BackupType          string   `json:"backupType"` // "multiple", "single", "directory", "mapping", "includes", "json"
 	MaxBackups          *int     `json:"maxBackups,omitempty"`
 	MaxBackupAgeDays    *int     `json:"maxBackupAgeDays,omitempty"`
 	IdNode              *string  `json:"idNode,omitempty"`
 	FriendlyNameNode    *string  `json:"friendlyNameNode,omitempty"`
+	ItemsPath           *string  `json:"itemsPath,omitempty"`
 	IncludeFilePatterns []string `json:"includeFilePatterns,omitempty"`
 	ExcludeFilePatterns []string `json:"excludeFilePatterns,omitempty"`
+	IgnoreKeys          []string `json:"ignoreKeys,omitempty"`
+	IgnoreLinePatterns  []string `json:"ignoreLinePatterns,omitempty"`
+	CanonicalHash       bool     `json:"canonicalHash,omitempty"`
+	Watcher             string   `json:"watcher,omitempty"` // "auto" (default), "fsnotify", "poll"
+	MaxFileSizeBytes    *int64   `json:"maxFileSizeBytes,omitempty"`
+}
+
+// ErrMissingConfigOption is returned when a config lacks an option its backup type needs to read items
+var ErrMissingConfigOption = errors.New("missing config option")
+
+// CheckRequiredOptions returns ErrMissingConfigOption when the itemsPath, idNode or friendlyNameNode that the config's
+// backup type reads items with isn't set. Settings validation reports these too, this guards configs read without it.
+func (c *ConfigBackupOptions) CheckRequiredOptions() error {
+	type option struct {
+		name  string
+		value *string
+	}
+	var required []option
+	switch c.BackupType {
+	case BackupTypeMultipleName:
+		required = []option{{"idNode", c.IdNode}, {"friendlyNameNode", c.FriendlyNameNode}}
+	case BackupTypeJsonName:
+		required = []option{{"itemsPath", c.ItemsPath}, {"idNode", c.IdNode}, {"friendlyNameNode", c.FriendlyNameNode}}
+	}
+
+	for _, option := range required {
+		if option.value == nil {
+			return fmt.Errorf("%w: %s config %s has no %s", ErrMissingConfigOption, c.BackupType, c.Path, option.name)
+		}
+	}
+	return nil
 }
 
 func NewSingleConfigBackupOptions(name string, path string) *ConfigBackupOptions {
@@ -46,6 +88,15 @@ func NewMultipleConfigBackupOptions(name string, path string, idNodeName string,
 	}
 }
 
+func NewMappingConfigBackupOptions(name string, path string, friendNameNodeName string) *ConfigBackupOptions {
+	return &ConfigBackupOptions{
+		Name:             name,
+		Path:             path,
+		BackupType:       "mapping",
+		FriendlyNameNode: &friendNameNodeName,
+	}
+}
+
 func NewDirectoryConfigBackupOptions(name string, path string, includeFilePatterns, excludeFilePatterns []string) *ConfigBackupOptions {
 	return &ConfigBackupOptions{
 		Name:                name,
@@ -56,61 +107,160 @@ func NewDirectoryConfigBackupOptions(name string, path string, includeFilePatter
 	}
 }
 
-func LoadConfig(configPath string) *AppSettings {
-	appSettings := &AppSettings{
+func NewJsonConfigBackupOptions(name string, path string, itemsPath string, idNodeName string, friendNameNodeName string) *ConfigBackupOptions {
+	return &ConfigBackupOptions{
+		Name:             name,
+		Path:             path,
+		BackupType:       "json",
+		ItemsPath:        &itemsPath,
+		IdNode:           &idNodeName,
+		FriendlyNameNode: &friendNameNodeName,
+	}
+}
+
+func NewIncludesConfigBackupOptions(name string, path string) *ConfigBackupOptions {
+	return &ConfigBackupOptions{
+		Name:       name,
+		Path:       path,
+		BackupType: "includes",
+	}
+}
+
+// defaultConfigs returns the configs tracked by default
+func defaultConfigs() []*ConfigBackupOptions {
+	return []*ConfigBackupOptions{
+		NewSingleConfigBackupOptions("Configuration", "configuration.yaml"),
+		NewMultipleConfigBackupOptions("Automations", "automations.yaml", "id", "alias"),
+		NewMultipleConfigBackupOptions("Scenes", "scenes.yaml", "id", "name"),
+		NewDirectoryConfigBackupOptions("ESP Home", "esphome", []string{"*.yaml"}, []string{}),
+		NewDirectoryConfigBackupOptions("Storage", ".storage", []string{
+			"lovelace.*",
+			"core.*",
+			"counter.*",
+			"input_boolean.*",
+			"input_number.*",
+			"input_select.*",
+			"input_text.*",
+			"input_*",
+			"person",
+			"energy",
+			"schedule",
+			"timer",
+		}, []string{
+			"core.analytics",
+			"core.config_entries",
+			"core.restore_state",
+			"core.device_registry",
+			"core.entity_registry",
+			"core.uuid",
+		}),
+	}
+}
+
+func defaultAppSettings() *AppSettings {
+	settings := &AppSettings{
+		SchemaVersion:           CurrentSchemaVersion(),
 		HomeAssistantConfigDir:  "/homeassistant",
 		BackupDir:               "/data/ha-config-history/backups",
+		HomeAssistantBackupDir:  "/backup",
 		Port:                    ":40613",
 		CronSchedule:            new(string),
 		DefaultMaxBackups:       nil,
 		DefaultMaxBackupAgeDays: nil,
-		Configs: []*ConfigBackupOptions{
-			NewSingleConfigBackupOptions("Configuration", "configuration.yaml"),
-			NewMultipleConfigBackupOptions("Automations", "automations.yaml", "id", "alias"),
-			NewMultipleConfigBackupOptions("Scenes", "scenes.yaml", "id", "name"),
-			NewDirectoryConfigBackupOptions("ESP Home", "esphome", []string{"*.yaml"}, []string{}),
-			NewDirectoryConfigBackupOptions("Storage", ".storage", []string{
-				"lovelace.*",
-				"core.*",
-				"counter.*",
-				"input_boolean.*",
-				"input_number.*",
-				"input_select.*",
-				"input_text.*",
-				"input_*",
-				"person",
-				"energy",
-				"schedule",
-				"timer",
-			}, []string{
-				"core.analytics",
-				"core.config_entries",
-				"core.restore_state",
-				"core.device_registry",
-				"core.entity_registry",
-				"core.uuid",
-			}),
-		},
+		Configs:                 defaultConfigs(),
 	}
+	for _, options := range settings.Configs {
+		settings.KnownDefaultConfigs = append(settings.KnownDefaultConfigs, defaultConfigKey(options.Path))
+	}
+	return settings
+}
 
+// LoadConfig reads the settings file, using defaults if it doesn't exist. Files written with an older schema version
+// are migrated and saved, keeping a copy of the original.
+func LoadConfig(configPath string) (*AppSettings, error) {
 	data, err := os.ReadFile(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Config file not found, using defaults", "file", configPath)
+		return defaultAppSettings(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", configPath, err)
+	}
+
+	appSettings, fromVersion, err := parseConfig(data)
 	if err != nil {
-		slog.Warn("Config file not found or unreadable, using defaults", "error", err)
-		return appSettings
+		return nil, fmt.Errorf("failed to parse config file %s: %w", configPath, err)
 	}
 
-	if err := json.Unmarshal(data, appSettings); err != nil {
-		slog.Warn("Failed to parse config file, using defaults", "error", err)
-		return appSettings
+	if fromVersion < CurrentSchemaVersion() {
+		if err := saveMigratedConfig(configPath, data, appSettings, fromVersion); err != nil {
+			slog.Warn("Unable to save migrated config file, it will be migrated again on the next start", "error", err)
+		}
 	}
 
 	slog.Info("Loaded configuration",
 		"homeassistantconfigdir", appSettings.HomeAssistantConfigDir,
 		"backupDir", appSettings.BackupDir,
 		"port", appSettings.Port,
+		"schemaVersion", appSettings.SchemaVersion,
 	)
 
-	return appSettings
+	return appSettings, nil
+}
+
+// saveMigratedConfig writes migrated settings over the settings file, after copying the original to
+// <file>.v<version>.bak
+func saveMigratedConfig(configPath string, original []byte, appSettings *AppSettings, fromVersion int) error {
+	backupPath := fmt.Sprintf("%s.v%d.bak", configPath, fromVersion)
+	if err := os.WriteFile(backupPath, original, 0644); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(appSettings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		return err
+	}
+
+	slog.Info("Migrated config file", "file", configPath, "from", fromVersion, "to", appSettings.SchemaVersion,
+		"original", backupPath)
+	return nil
+}
+
+// ParseConfig reads settings from the contents of a config file, migrating them to the current schema version and
+// using defaults for anything not set. Configs in the file replace the default configs rather than being merged into
+// them.
+func ParseConfig(data []byte) (*AppSettings, error) {
+	appSettings, _, err := parseConfig(data)
+	return appSettings, err
+}
+
+func parseConfig(data []byte) (*AppSettings, int, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, 0, err
+	}
+
+	fromVersion, err := migrateSettings(fields)
+	if err != nil {
+		return nil, fromVersion, err
+	}
+	migrated, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fromVersion, err
+	}
+
+	appSettings := defaultAppSettings()
+	if _, exists := fields["configs"]; exists {
+		appSettings.Configs = nil
+		appSettings.KnownDefaultConfigs = nil
+	}
+	if err := json.Unmarshal(migrated, appSettings); err != nil {
+		return nil, fromVersion, err
+	}
+	return appSettings, fromVersion, nil
 }
 
 type BackupType int
@@ -119,6 +269,9 @@ const (
 	BackupTypeMultiple BackupType = iota
 	BackupTypeSingle
 	BackupTypeDirectory
+	BackupTypeMapping
+	BackupTypeIncludes
+	BackupTypeJson
 )
 
 // Backup type string constants
@@ -126,10 +279,16 @@ const (
 	BackupTypeMultipleName  = "multiple"
 	BackupTypeSingleName    = "single"
 	BackupTypeDirectoryName = "directory"
+	BackupTypeMappingName   = "mapping"
+	BackupTypeIncludesName  = "includes"
+	BackupTypeJsonName      = "json"
 )
 
 var stateName = map[BackupType]string{
 	BackupTypeMultiple:  BackupTypeMultipleName,
 	BackupTypeSingle:    BackupTypeSingleName,
 	BackupTypeDirectory: BackupTypeDirectoryName,
+	BackupTypeMapping:   BackupTypeMappingName,
+	BackupTypeIncludes:  BackupTypeIncludesName,
+	BackupTypeJson:      BackupTypeJsonName,
 }
@@ -0,0 +1,16 @@
+package types
+
+import "testing"
+
+// SetDefaultConfigRevision changes the revision of a default config for the duration of a test
+func SetDefaultConfigRevision(t *testing.T, path string, revision int) {
+	previous, exists := defaultConfigRevisions[path]
+	defaultConfigRevisions[path] = revision
+	t.Cleanup(func() {
+		if exists {
+			defaultConfigRevisions[path] = previous
+		} else {
+			delete(defaultConfigRevisions, path)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Node expressions are used by IdNode and FriendlyNameNode to pick a value out of each item:
+//   - `alias` reads a top level key
+//   - `trigger.0.entity_id` follows nested keys, with numbers indexing into lists
+//   - `alias|description|id` uses the first path that has a value
+//   - `{{alias}} ({{id}})` combines several expressions into one value
+
+var templatePattern = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+
+// nodeLookup returns the scalar value found by following a path, and whether it was found
+type nodeLookup func(path []string) (string, bool)
+
+func ValidateNodeExpression(expression string) error {
+	if strings.TrimSpace(expression) == "" {
+		return fmt.Errorf("expression is empty")
+	}
+
+	if !strings.Contains(expression, "{{") && !strings.Contains(expression, "}}") {
+		return validateNodeAlternatives(expression)
+	}
+
+	remainder := templatePattern.ReplaceAllString(expression, "")
+	if strings.Contains(remainder, "{{") || strings.Contains(remainder, "}}") {
+		return fmt.Errorf("unbalanced template braces in %q", expression)
+	}
+
+	for _, match := range templatePattern.FindAllStringSubmatch(expression, -1) {
+		if err := validateNodeAlternatives(match[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateNodeAlternatives(expression string) error {
+	for _, alternative := range strings.Split(expression, "|") {
+		path := strings.TrimSpace(alternative)
+		if path == "" {
+			return fmt.Errorf("empty alternative in %q", expression)
+		}
+		for _, segment := range strings.Split(path, ".") {
+			if segment == "" {
+				return fmt.Errorf("empty path segment in %q", path)
+			}
+		}
+	}
+	return nil
+}
+
+func evaluateNodeExpression(expression string, lookup nodeLookup) string {
+	if !strings.Contains(expression, "{{") {
+		if value, found := evaluateNodeAlternatives(expression, lookup); found {
+			return value
+		}
+		return "unknown"
+	}
+
+	anyFound := false
+	result := templatePattern.ReplaceAllStringFunc(expression, func(match string) string {
+		value, found := evaluateNodeAlternatives(templatePattern.FindStringSubmatch(match)[1], lookup)
+		anyFound = anyFound || found
+		return value
+	})
+
+	if !anyFound {
+		return "unknown"
+	}
+	return result
+}
+
+func evaluateNodeAlternatives(expression string, lookup nodeLookup) (string, bool) {
+	for _, alternative := range strings.Split(expression, "|") {
+		path := strings.TrimSpace(alternative)
+		if path == "" {
+			continue
+		}
+		if value, found := lookup(strings.Split(path, ".")); found {
+			return value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,18 @@
+package types
+
+// IncludeEdge is a single `!include` style reference from one file to a file or directory
+type IncludeEdge struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Tag     string `json:"tag"`
+	Key     string `json:"key,omitempty"`
+	Missing bool   `json:"missing,omitempty"`
+}
+
+// IncludeGraph is the resolved set of files referenced from an includes config
+type IncludeGraph struct {
+	Root    string                 `json:"root"`
+	Edges   []IncludeEdge          `json:"edges"`
+	Files   []string               `json:"files"`
+	Configs []*ConfigBackupOptions `json:"configs"`
+}
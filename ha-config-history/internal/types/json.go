@@ -0,0 +1,43 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// GetJsonValue evaluates a node expression (see expression.go) against a JSON element,
+// returning "unknown" if nothing matches
+func GetJsonValue(element json.RawMessage, key string) string {
+	var item any
+	if err := json.Unmarshal(element, &item); err != nil {
+		return "unknown"
+	}
+
+	return evaluateNodeExpression(key, func(path []string) (string, bool) {
+		value := item
+		for _, segment := range path {
+			switch typed := value.(type) {
+			case map[string]any:
+				value = typed[segment]
+			case []any:
+				index, err := strconv.Atoi(segment)
+				if err != nil || index < 0 || index >= len(typed) {
+					return "", false
+				}
+				value = typed[index]
+			default:
+				return "", false
+			}
+		}
+
+		switch typed := value.(type) {
+		case nil, map[string]any, []any:
+			return "", false
+		case string:
+			return typed, typed != ""
+		default:
+			return fmt.Sprint(typed), true
+		}
+	})
+}
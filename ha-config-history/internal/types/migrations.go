@@ -0,0 +1,172 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// settingsMigration upgrades the fields of a settings file from the previous schema version
+type settingsMigration struct {
+	description string
+	migrate     func(fields map[string]json.RawMessage) error
+}
+
+// settingsMigrations are applied in order, settingsMigrations[i] upgrading a settings file from schema version i to
+// i+1. Settings files without a schemaVersion are version 0. Released migrations must not be changed, add a new one
+// to the end instead.
+var settingsMigrations = []settingsMigration{
+	{
+		description: "record the default configs that are already tracked",
+		migrate: func(fields map[string]json.RawMessage) error {
+			raw, exists := fields["configs"]
+			if !exists {
+				return nil
+			}
+
+			var configs []*ConfigBackupOptions
+			if err := json.Unmarshal(raw, &configs); err != nil {
+				return err
+			}
+
+			// Only defaults tracked with the same options as the current release's are known. Defaults the file
+			// doesn't track, or tracks differently, like older .storage patterns, are offered as new defaults instead.
+			known := []string{}
+			for _, options := range defaultConfigs() {
+				if slices.ContainsFunc(configs, func(config *ConfigBackupOptions) bool {
+					return config != nil && config.Path == options.Path && sameTracking(config, options)
+				}) {
+					known = append(known, defaultConfigKey(options.Path))
+				}
+			}
+			data, err := json.Marshal(known)
+			if err != nil {
+				return err
+			}
+			fields["knownDefaultConfigs"] = data
+			return nil
+		},
+	},
+}
+
+// CurrentSchemaVersion is the settings schema version written by this release
+func CurrentSchemaVersion() int {
+	return len(settingsMigrations)
+}
+
+// migrateSettings upgrades the fields of a settings file to the current schema version, returning the version the
+// file was written with
+func migrateSettings(fields map[string]json.RawMessage) (int, error) {
+	version := 0
+	if raw, exists := fields["schemaVersion"]; exists {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return 0, fmt.Errorf("invalid schemaVersion: %w", err)
+		}
+	}
+	if version < 0 || version > CurrentSchemaVersion() {
+		return version, fmt.Errorf("settings schema version %d is not supported by this release, which supports up to %d",
+			version, CurrentSchemaVersion())
+	}
+
+	for i := version; i < CurrentSchemaVersion(); i++ {
+		migration := settingsMigrations[i]
+		if err := migration.migrate(fields); err != nil {
+			return version, fmt.Errorf("failed to migrate settings to schema version %d (%s): %w",
+				i+1, migration.description, err)
+		}
+		slog.Info("Migrated settings", "schemaVersion", i+1, "migration", migration.description)
+	}
+
+	fields["schemaVersion"] = json.RawMessage(strconv.Itoa(CurrentSchemaVersion()))
+	return version, nil
+}
+
+// defaultConfigRevisions count the releases that changed each default config's options. Bump a default's revision
+// when changing it, eg. adding .storage patterns, so installs that already know it are offered the new options.
+// Defaults that aren't listed are at revision 1.
+var defaultConfigRevisions = map[string]int{}
+
+func defaultConfigRevision(path string) int {
+	return max(defaultConfigRevisions[path], 1)
+}
+
+// defaultConfigKey is recorded in knownDefaultConfigs for the current revision of a default config: its path at
+// revision 1, or path@revision after
+func defaultConfigKey(path string) string {
+	revision := defaultConfigRevision(path)
+	if revision == 1 {
+		return path
+	}
+	return path + "@" + strconv.Itoa(revision)
+}
+
+// knownDefaultRevision returns the latest revision of a default config recorded in knownDefaultConfigs, or 0 when
+// it's unknown
+func knownDefaultRevision(known []string, path string) int {
+	latest := 0
+	for _, key := range known {
+		if key == path {
+			latest = max(latest, 1)
+			continue
+		}
+		revision, found := strings.CutPrefix(key, path+"@")
+		if !found {
+			continue
+		}
+		if number, err := strconv.Atoi(revision); err == nil {
+			latest = max(latest, number)
+		}
+	}
+	return latest
+}
+
+// sameTracking reports whether two configs track the same items the same way, ignoring their names and retention
+func sameTracking(a, b *ConfigBackupOptions) bool {
+	return a.BackupType == b.BackupType &&
+		ptrEqual(a.IdNode, b.IdNode) &&
+		ptrEqual(a.FriendlyNameNode, b.FriendlyNameNode) &&
+		ptrEqual(a.ItemsPath, b.ItemsPath) &&
+		slices.Equal(a.IncludeFilePatterns, b.IncludeFilePatterns) &&
+		slices.Equal(a.ExcludeFilePatterns, b.ExcludeFilePatterns)
+}
+
+func ptrEqual[T comparable](a, b *T) bool {
+	return a == b || (a != nil && b != nil && *a == *b)
+}
+
+// NewDefaultConfig is a default config offered to existing settings
+type NewDefaultConfig struct {
+	// Key is added to knownDefaultConfigs once the default is added or dismissed, so it isn't offered again
+	Key string `json:"key"`
+	// Update is set when the settings track the default's path with other options, so adding it replaces that config
+	Update bool                 `json:"update,omitempty"`
+	Config *ConfigBackupOptions `json:"config"`
+}
+
+// NewDefaultConfigs returns the default configs that the settings don't track with the same options and whose
+// current revision hasn't been offered before, so defaults added or changed by later releases can be offered to
+// existing installs without changing their configs
+func NewDefaultConfigs(settings *AppSettings) []NewDefaultConfig {
+	newConfigs := []NewDefaultConfig{}
+	for _, options := range defaultConfigs() {
+		if knownDefaultRevision(settings.KnownDefaultConfigs, options.Path) >= defaultConfigRevision(options.Path) {
+			continue
+		}
+
+		index := slices.IndexFunc(settings.Configs, func(config *ConfigBackupOptions) bool {
+			return config != nil && config.Path == options.Path
+		})
+		if index >= 0 && sameTracking(settings.Configs[index], options) {
+			continue
+		}
+		newConfigs = append(newConfigs, NewDefaultConfig{
+			Key:    defaultConfigKey(options.Path),
+			Update: index >= 0,
+			Config: options,
+		})
+	}
+	return newConfigs
+}
@@ -0,0 +1,168 @@
+package types_test
+
+import (
+	"encoding/json"
+	"ha-config-history/internal/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSettingsMigrations(t *testing.T) {
+	paths := func(configs []*types.ConfigBackupOptions) []string {
+		result := []string{}
+		for _, options := range configs {
+			result = append(result, options.Path)
+		}
+		return result
+	}
+	offered := func(defaults []types.NewDefaultConfig) []*types.ConfigBackupOptions {
+		result := []*types.ConfigBackupOptions{}
+		for _, offer := range defaults {
+			result = append(result, offer.Config)
+		}
+		return result
+	}
+
+	t.Run("Unversioned settings are migrated and offered new defaults", func(t *testing.T) {
+		data := `{"port": ":1234", "configs": [
+			{"name": "Automations", "path": "automations.yaml", "backupType": "multiple", "idNode": "id",
+				"friendlyNameNode": "alias"},
+			{"name": "Scripts", "path": "scripts.yaml", "backupType": "mapping", "friendlyNameNode": "alias"}
+		]}`
+
+		settings, err := types.ParseConfig([]byte(data))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if settings.SchemaVersion != types.CurrentSchemaVersion() {
+			t.Errorf("Expected schema version %d, got: %d", types.CurrentSchemaVersion(), settings.SchemaVersion)
+		}
+		if diff := cmp.Diff([]string{"automations.yaml", "scripts.yaml"}, paths(settings.Configs)); diff != "" {
+			t.Errorf("Configs do not match expected:\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{"automations.yaml"}, settings.KnownDefaultConfigs); diff != "" {
+			t.Errorf("Known default configs do not match expected:\n%s", diff)
+		}
+
+		expected := []string{"configuration.yaml", "scenes.yaml", "esphome", ".storage"}
+		if diff := cmp.Diff(expected, paths(offered(types.NewDefaultConfigs(settings)))); diff != "" {
+			t.Errorf("New default configs do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Dismissed defaults are not offered again", func(t *testing.T) {
+		data := `{"schemaVersion": 1, "configs": [], "knownDefaultConfigs": ["configuration.yaml", "scenes.yaml"]}`
+
+		settings, err := types.ParseConfig([]byte(data))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		expected := []string{"automations.yaml", "esphome", ".storage"}
+		if diff := cmp.Diff(expected, paths(offered(types.NewDefaultConfigs(settings)))); diff != "" {
+			t.Errorf("New default configs do not match expected:\n%s", diff)
+		}
+	})
+
+	t.Run("Defaults tracked with other options are offered as updates", func(t *testing.T) {
+		data := `{"configs": [
+			{"name": "Storage", "path": ".storage", "backupType": "directory", "includeFilePatterns": ["lovelace.*"]}
+		]}`
+
+		settings, err := types.ParseConfig([]byte(data))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(settings.KnownDefaultConfigs) != 0 {
+			t.Errorf("Expected no known default configs, got: %v", settings.KnownDefaultConfigs)
+		}
+
+		defaults := types.NewDefaultConfigs(settings)
+		storage := defaults[len(defaults)-1]
+		if storage.Config.Path != ".storage" || !storage.Update || storage.Key != ".storage" {
+			t.Errorf("Expected .storage to be offered as an update, got: %+v", storage)
+		}
+	})
+
+	t.Run("Changed defaults are offered again", func(t *testing.T) {
+		data := `{"schemaVersion": 1, "configs": [
+			{"name": "Storage", "path": ".storage", "backupType": "directory", "includeFilePatterns": ["lovelace.*"]}
+		], "knownDefaultConfigs": ["configuration.yaml", "automations.yaml", "scenes.yaml", "esphome", ".storage"]}`
+
+		settings, err := types.ParseConfig([]byte(data))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if defaults := types.NewDefaultConfigs(settings); len(defaults) != 0 {
+			t.Fatalf("Expected known defaults not to be offered, got: %+v", defaults)
+		}
+
+		types.SetDefaultConfigRevision(t, ".storage", 2)
+		defaults := types.NewDefaultConfigs(settings)
+		if len(defaults) != 1 || defaults[0].Key != ".storage@2" || !defaults[0].Update {
+			t.Fatalf("Expected the changed .storage default to be offered as an update, got: %+v", defaults)
+		}
+
+		settings.KnownDefaultConfigs = append(settings.KnownDefaultConfigs, ".storage@2")
+		if defaults := types.NewDefaultConfigs(settings); len(defaults) != 0 {
+			t.Errorf("Expected the dismissed revision not to be offered again, got: %+v", defaults)
+		}
+	})
+
+	t.Run("Settings without configs use every default", func(t *testing.T) {
+		settings, err := types.ParseConfig([]byte(`{"port": ":1234"}`))
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if len(settings.Configs) == 0 || len(types.NewDefaultConfigs(settings)) != 0 {
+			t.Errorf("Expected default configs with none offered, got: %v", paths(settings.Configs))
+		}
+	})
+
+	t.Run("Newer schema versions are rejected", func(t *testing.T) {
+		data := []byte(`{"schemaVersion": 1000, "configs": []}`)
+		if _, err := types.ParseConfig(data); err == nil {
+			t.Fatal("Expected error for unsupported schema version")
+		}
+	})
+
+	t.Run("Load saves migrated settings and keeps the original", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "config.json")
+		original := `{"port": ":1234", "configs": []}`
+		if err := os.WriteFile(configPath, []byte(original), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		if _, err := types.LoadConfig(configPath); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+
+		backup, err := os.ReadFile(configPath + ".v0.bak")
+		if err != nil || string(backup) != original {
+			t.Fatalf("Expected original settings to be kept, got: %v\n%s", err, backup)
+		}
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		var saved types.AppSettings
+		if err := json.Unmarshal(data, &saved); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if saved.SchemaVersion != types.CurrentSchemaVersion() || saved.Port != ":1234" {
+			t.Errorf("Expected migrated settings to be saved, got:\n%s", data)
+		}
+	})
+
+	t.Run("Load fails on invalid settings instead of using defaults", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(configPath, []byte("{ invalid"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if _, err := types.LoadConfig(configPath); err == nil {
+			t.Fatal("Expected error for invalid settings file")
+		}
+	})
+}
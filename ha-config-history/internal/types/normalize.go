@@ -0,0 +1,232 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// hashConfigBlob hashes a blob after applying the config's ignore rules, so changes to volatile
+// content (eg. last_updated timestamps) don't produce a new backup. The blob itself is never modified.
+func hashConfigBlob(blob []byte, config *ConfigBackupOptions) string {
+	normalized, err := NormalizeBlob(blob, config)
+	if err != nil {
+		slog.Warn("Unable to apply ignore rules, hashing original content", "path", config.Path, "error", err)
+		return hashByteSlice(blob)
+	}
+	return hashByteSlice(normalized)
+}
+
+// NormalizeBlob strips any lines matching the config's IgnoreLinePatterns, removes IgnoreKeys from JSON or
+// YAML content and, when CanonicalHash is enabled, canonicalizes YAML so formatting-only edits are ignored
+func NormalizeBlob(blob []byte, config *ConfigBackupOptions) ([]byte, error) {
+	if len(config.IgnoreKeys) == 0 && len(config.IgnoreLinePatterns) == 0 && !config.CanonicalHash {
+		return blob, nil
+	}
+
+	normalized := blob
+	if len(config.IgnoreLinePatterns) > 0 {
+		patterns := make([]*regexp.Regexp, 0, len(config.IgnoreLinePatterns))
+		for _, pattern := range config.IgnoreLinePatterns {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ignore line pattern %s: %w", pattern, err)
+			}
+			patterns = append(patterns, compiled)
+		}
+
+		lines := bytes.Split(normalized, []byte("\n"))
+		kept := make([][]byte, 0, len(lines))
+		for _, line := range lines {
+			ignored := false
+			for _, pattern := range patterns {
+				if pattern.Match(line) {
+					ignored = true
+					break
+				}
+			}
+			if !ignored {
+				kept = append(kept, line)
+			}
+		}
+		normalized = bytes.Join(kept, []byte("\n"))
+	}
+
+	if len(config.IgnoreKeys) > 0 {
+		var err error
+		normalized, err = removeIgnoredKeys(normalized, config.IgnoreKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.CanonicalHash {
+		var err error
+		normalized, err = CanonicalizeYaml(normalized)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return normalized, nil
+}
+
+// CanonicalizeYaml re-encodes YAML with comments removed, default scalar and collection styles and a fixed indent.
+// Two documents with the same meaning produce the same output, so only meaningful changes affect the hash.
+func CanonicalizeYaml(blob []byte) ([]byte, error) {
+	var rootNode yaml.Node
+	if err := yaml.Unmarshal(blob, &rootNode); err != nil {
+		return nil, fmt.Errorf("canonical hashing requires YAML content: %w", err)
+	}
+	if rootNode.Kind == 0 {
+		return []byte{}, nil
+	}
+	canonicalizeYamlNode(&rootNode)
+
+	var canonical bytes.Buffer
+	encoder := yaml.NewEncoder(&canonical)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&rootNode); err != nil {
+		return nil, fmt.Errorf("failed to encode canonical YAML: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode canonical YAML: %w", err)
+	}
+	return canonical.Bytes(), nil
+}
+
+// IsFormatOnlyChange reports whether two blobs differ in their bytes but not in their YAML meaning
+func IsFormatOnlyChange(left, right []byte) bool {
+	if bytes.Equal(left, right) {
+		return false
+	}
+
+	canonicalLeft, err := CanonicalizeYaml(left)
+	if err != nil {
+		return false
+	}
+	canonicalRight, err := CanonicalizeYaml(right)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(canonicalLeft, canonicalRight)
+}
+
+// FormatJSON indents a JSON object or array by two spaces, so a file written on one line can be read and compared line
+// by line. Key order and values are kept as they are. Anything else, including invalid JSON, is returned unchanged
+// with false.
+func FormatJSON(blob []byte) ([]byte, bool) {
+	trimmed := bytes.TrimSpace(blob)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') || !json.Valid(trimmed) {
+		return blob, false
+	}
+
+	var formatted bytes.Buffer
+	if err := json.Indent(&formatted, trimmed, "", "  "); err != nil {
+		return blob, false
+	}
+	formatted.WriteByte('\n')
+	return formatted.Bytes(), true
+}
+
+func canonicalizeYamlNode(node *yaml.Node) {
+	node.HeadComment = ""
+	node.LineComment = ""
+	node.FootComment = ""
+	node.Style = 0
+	for _, child := range node.Content {
+		canonicalizeYamlNode(child)
+	}
+}
+
+func removeIgnoredKeys(blob []byte, keys []string) ([]byte, error) {
+	if json.Valid(blob) {
+		var value any
+		if err := json.Unmarshal(blob, &value); err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			value = removeJsonPath(value, strings.Split(key, "."))
+		}
+		return json.Marshal(value)
+	}
+
+	var rootNode yaml.Node
+	if err := yaml.Unmarshal(blob, &rootNode); err != nil {
+		return nil, fmt.Errorf("ignore keys require JSON or YAML content: %w", err)
+	}
+	for _, key := range keys {
+		removeYamlPath(&rootNode, strings.Split(key, "."))
+	}
+	return yaml.Marshal(&rootNode)
+}
+
+// removeJsonPath removes the value at path, where a `*` segment matches every key or list item
+func removeJsonPath(value any, path []string) any {
+	if len(path) == 0 {
+		return value
+	}
+
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, child := range typed {
+			if path[0] != "*" && path[0] != key {
+				continue
+			}
+			if len(path) == 1 {
+				delete(typed, key)
+			} else {
+				typed[key] = removeJsonPath(child, path[1:])
+			}
+		}
+	case []any:
+		for i, child := range typed {
+			if path[0] != "*" && path[0] != strconv.Itoa(i) {
+				continue
+			}
+			if len(path) > 1 {
+				typed[i] = removeJsonPath(child, path[1:])
+			}
+		}
+	}
+	return value
+}
+
+// removeYamlPath removes the mapping entry at path, where a `*` segment matches every key or list item
+func removeYamlPath(node *yaml.Node, path []string) {
+	if len(path) == 0 {
+		return
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			removeYamlPath(child, path)
+		}
+	case yaml.MappingNode:
+		kept := make([]*yaml.Node, 0, len(node.Content))
+		for i := 0; i < len(node.Content)-1; i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+			if path[0] == "*" || path[0] == keyNode.Value {
+				if len(path) == 1 {
+					continue
+				}
+				removeYamlPath(valueNode, path[1:])
+			}
+			kept = append(kept, keyNode, valueNode)
+		}
+		node.Content = kept
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			if (path[0] == "*" || path[0] == strconv.Itoa(i)) && len(path) > 1 {
+				removeYamlPath(child, path[1:])
+			}
+		}
+	}
+}
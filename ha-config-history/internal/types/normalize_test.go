@@ -0,0 +1,68 @@
+package types_test
+
+import (
+	"ha-config-history/internal/types"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormatJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		blob      string
+		expected  string
+		formatted bool
+	}{
+		{
+			name: "One line object",
+			blob: `{"version":1,"data":{"entries":[{"id":"a","title":"<Kitchen>"}]}}`,
+			expected: `{
+  "version": 1,
+  "data": {
+    "entries": [
+      {
+        "id": "a",
+        "title": "<Kitchen>"
+      }
+    ]
+  }
+}
+`,
+			formatted: true,
+		},
+		{
+			name:      "Indentation is made consistent",
+			blob:      "{\n    \"b\": [1,\n 2.50]\n}",
+			expected:  "{\n  \"b\": [\n    1,\n    2.50\n  ]\n}\n",
+			formatted: true,
+		},
+		{
+			name:     "Invalid JSON is unchanged",
+			blob:     "{\n  \"filetype\": \"json\",\n}",
+			expected: "{\n  \"filetype\": \"json\",\n}",
+		},
+		{
+			name:     "YAML is unchanged",
+			blob:     "alias: Test\nmode: single\n",
+			expected: "alias: Test\nmode: single\n",
+		},
+		{
+			name:     "JSON scalars are unchanged",
+			blob:     "123",
+			expected: "123",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, formatted := types.FormatJSON([]byte(tt.blob))
+			if formatted != tt.formatted {
+				t.Errorf("Expected formatted to be %v, got: %v", tt.formatted, formatted)
+			}
+			if diff := cmp.Diff(tt.expected, string(result)); diff != "" {
+				t.Errorf("Result does not match expected:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package types
+
+import "time"
+
+// Pause stops file watching and scans from recording backups, until it is resumed or expires
+type Pause struct {
+	Since  time.Time  `json:"since"`
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+// Expired reports whether a pause with an expiry time has run out
+func (p *Pause) Expired(now time.Time) bool {
+	return p.Until != nil && !now.Before(*p.Until)
+}
+
+// PauseState is the persisted set of pauses. Global is maintenance mode, pausing every config, and Configs is
+// keyed by config path.
+type PauseState struct {
+	Global  *Pause            `json:"global,omitempty"`
+	Configs map[string]*Pause `json:"configs"`
+}
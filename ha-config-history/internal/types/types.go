@@ -1,6 +1,8 @@
 package types
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -16,9 +18,19 @@ type ConfigMetadata struct {
 	ConfigIdentifier
 	FriendlyName string `json:"friendlyName"`
 	LastHash     string `json:"lastHash,omitempty"`
+	LastRawHash  string `json:"lastRawHash,omitempty"`
 	BackupCount  int    `json:"backupCount"`
 	BackupsSize  int64  `json:"backupsSize"`
 	BackupType   string `json:"backupType"`
+	Binary       bool   `json:"binary,omitempty"`
+	// FormatOnlyChanges counts edits that changed the file's formatting but not its meaning,
+	// which are not saved as backups when canonical hashing is enabled
+	FormatOnlyChanges    int        `json:"formatOnlyChanges,omitempty"`
+	LastFormatOnlyChange *time.Time `json:"lastFormatOnlyChange,omitempty"`
+	// Deleted is set while the item is missing from its config. Deletions are the times it was found missing,
+	// oldest first, so the items that existed at a past time can be told apart from deleted ones.
+	Deleted   bool        `json:"deleted,omitempty"`
+	Deletions []time.Time `json:"deletions,omitempty"`
 }
 
 func NewConfigMetadata(configBackup *ConfigBackup, backupCount int, backupsSize int64, backupType string) *ConfigMetadata {
@@ -29,9 +41,11 @@ func NewConfigMetadata(configBackup *ConfigBackup, backupCount int, backupsSize
 		},
 		FriendlyName: configBackup.FriendlyName,
 		LastHash:     configBackup.Hash,
+		LastRawHash:  configBackup.RawHash,
 		BackupCount:  backupCount,
 		BackupsSize:  backupsSize,
 		BackupType:   backupType,
+		Binary:       configBackup.Binary,
 	}
 }
 
@@ -39,10 +53,14 @@ type ConfigBackup struct {
 	ConfigIdentifier
 	FriendlyName string `json:"friendly_name,omitempty"`
 	Hash         string `json:"hash,omitempty"`
+	RawHash      string `json:"rawHash,omitempty"`
 	ModifiedDate time.Time
-	BackupType   string `json:"backupType"` // "multiple", "single", "directory"
+	BackupType   string `json:"backupType"` // "multiple", "single", "directory", "mapping", "json"
+	Binary       bool   `json:"binary,omitempty"`
+	Size         int64  `json:"size,omitempty"`
 	FilePath     string `json:"-"`
-	Blob         []byte `json:"-"`
+	// Blob is nil for streamed backups (binary or large files), which are copied straight from FilePath when saved
+	Blob []byte `json:"-"`
 }
 
 func NewBlobConfigBackup(filename, filepath string, blob []byte, config *ConfigBackupOptions, modifiedDate time.Time) (*ConfigBackup, error) {
@@ -53,7 +71,8 @@ func NewBlobConfigBackup(filename, filepath string, blob []byte, config *ConfigB
 				Group: config.Path,
 			},
 			FriendlyName: config.Path,
-			Hash:         hashByteSlice(blob),
+			Hash:         hashConfigBlob(blob, config),
+			RawHash:      hashByteSlice(blob),
 			ModifiedDate: modifiedDate,
 			BackupType:   config.BackupType,
 			FilePath:     filepath,
@@ -65,6 +84,14 @@ func NewBlobConfigBackup(filename, filepath string, blob []byte, config *ConfigB
 		return nil, fmt.Errorf("blob backups do not support multiple backup type")
 	}
 
+	if config.BackupType == stateName[BackupTypeMapping] {
+		return nil, fmt.Errorf("blob backups do not support mapping backup type")
+	}
+
+	if config.BackupType == stateName[BackupTypeJson] {
+		return nil, fmt.Errorf("blob backups do not support json backup type")
+	}
+
 	if config.BackupType == stateName[BackupTypeDirectory] {
 		return &ConfigBackup{
 			ConfigIdentifier: ConfigIdentifier{
@@ -72,7 +99,8 @@ func NewBlobConfigBackup(filename, filepath string, blob []byte, config *ConfigB
 				Group: config.Path,
 			},
 			FriendlyName: filename,
-			Hash:         hashByteSlice(blob),
+			Hash:         hashConfigBlob(blob, config),
+			RawHash:      hashByteSlice(blob),
 			BackupType:   config.BackupType,
 			ModifiedDate: modifiedDate,
 			FilePath:     filepath,
@@ -83,21 +111,54 @@ func NewBlobConfigBackup(filename, filepath string, blob []byte, config *ConfigB
 	return nil, fmt.Errorf("unknown backup type: %s", config.BackupType)
 }
 
+// NewStreamedConfigBackup creates a backup for a binary or large file without holding its content in memory.
+// The hash is of the raw file, as ignore rules and canonical hashing only apply to text that is read in full.
+func NewStreamedConfigBackup(filename, filepath, hash string, size int64, binary bool, config *ConfigBackupOptions, modifiedDate time.Time) (*ConfigBackup, error) {
+	id := filename
+	if config.BackupType == stateName[BackupTypeSingle] {
+		id = config.Path
+	} else if config.BackupType != stateName[BackupTypeDirectory] {
+		return nil, fmt.Errorf("streamed backups only support single and directory backup types, got: %s", config.BackupType)
+	}
+
+	return &ConfigBackup{
+		ConfigIdentifier: ConfigIdentifier{
+			ID:    id,
+			Group: config.Path,
+		},
+		FriendlyName: id,
+		Hash:         hash,
+		RawHash:      hash,
+		BackupType:   config.BackupType,
+		Binary:       binary,
+		Size:         size,
+		ModifiedDate: modifiedDate,
+		FilePath:     filepath,
+	}, nil
+}
+
 func NewYamlConfigBackup(filename, filepath string, yamlNode *yaml.Node, config *ConfigBackupOptions, modifiedDate time.Time) (*ConfigBackup, error) {
-	blob, _ := yaml.Marshal(yamlNode)
+	blob, err := yaml.Marshal(yamlNode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize item in %s: %w", config.Path, err)
+	}
 
 	if config.BackupType == stateName[BackupTypeSingle] {
 		return nil, fmt.Errorf("yaml backups do not support single backup type")
 	}
 
 	if config.BackupType == stateName[BackupTypeMultiple] {
+		if err := config.CheckRequiredOptions(); err != nil {
+			return nil, err
+		}
 		return &ConfigBackup{
 			ConfigIdentifier: ConfigIdentifier{
 				ID:    GetYamlNodeValue(yamlNode, *config.IdNode),
 				Group: config.Path,
 			},
 			FriendlyName: GetYamlNodeValue(yamlNode, *config.FriendlyNameNode),
-			Hash:         hashByteSlice(blob),
+			Hash:         hashConfigBlob(blob, config),
+			RawHash:      hashByteSlice(blob),
 			BackupType:   config.BackupType,
 			ModifiedDate: modifiedDate,
 			FilePath:     filepath,
@@ -109,5 +170,79 @@ func NewYamlConfigBackup(filename, filepath string, yamlNode *yaml.Node, config
 		return nil, fmt.Errorf("yaml backups do not support directory backup type")
 	}
 
+	if config.BackupType == stateName[BackupTypeMapping] {
+		return nil, fmt.Errorf("yaml backups do not support mapping backup type, use keyed yaml backups")
+	}
+
 	return nil, fmt.Errorf("unknown backup type: %s", config.BackupType)
 }
+
+// NewKeyedYamlConfigBackup creates a backup for a single entry of a YAML mapping (eg. scripts.yaml).
+// The key is used as the ID and the stored blob is a mapping containing only that key so it can be restored as-is.
+func NewKeyedYamlConfigBackup(filename, filepath string, keyNode, valueNode *yaml.Node, config *ConfigBackupOptions, modifiedDate time.Time) (*ConfigBackup, error) {
+	if config.BackupType != stateName[BackupTypeMapping] {
+		return nil, fmt.Errorf("keyed yaml backups only support mapping backup type, got: %s", config.BackupType)
+	}
+
+	entryNode := &yaml.Node{
+		Kind:    yaml.MappingNode,
+		Tag:     "!!map",
+		Content: []*yaml.Node{keyNode, valueNode},
+	}
+	blob, err := yaml.Marshal(entryNode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize %s in %s: %w", keyNode.Value, config.Path, err)
+	}
+
+	friendlyName := keyNode.Value
+	if config.FriendlyNameNode != nil && *config.FriendlyNameNode != "" && valueNode.Kind == yaml.MappingNode {
+		if value := GetYamlNodeValue(valueNode, *config.FriendlyNameNode); value != "unknown" {
+			friendlyName = value
+		}
+	}
+
+	return &ConfigBackup{
+		ConfigIdentifier: ConfigIdentifier{
+			ID:    keyNode.Value,
+			Group: config.Path,
+		},
+		FriendlyName: friendlyName,
+		Hash:         hashConfigBlob(blob, config),
+		RawHash:      hashByteSlice(blob),
+		BackupType:   config.BackupType,
+		ModifiedDate: modifiedDate,
+		FilePath:     filepath,
+		Blob:         blob,
+	}, nil
+}
+
+// NewJsonConfigBackup creates a backup for a single element of a JSON list (eg. a Lovelace view in .storage/lovelace).
+// The element is stored indented so it reads well in the UI, keeping the original key order.
+func NewJsonConfigBackup(filename, filepath string, element json.RawMessage, config *ConfigBackupOptions, modifiedDate time.Time) (*ConfigBackup, error) {
+	if config.BackupType != stateName[BackupTypeJson] {
+		return nil, fmt.Errorf("json backups only support json backup type, got: %s", config.BackupType)
+	}
+	if err := config.CheckRequiredOptions(); err != nil {
+		return nil, err
+	}
+
+	var blob bytes.Buffer
+	if err := json.Indent(&blob, element, "", "  "); err != nil {
+		return nil, fmt.Errorf("failed to format json element: %w", err)
+	}
+	blob.WriteString("\n")
+
+	return &ConfigBackup{
+		ConfigIdentifier: ConfigIdentifier{
+			ID:    GetJsonValue(element, *config.IdNode),
+			Group: config.Path,
+		},
+		FriendlyName: GetJsonValue(element, *config.FriendlyNameNode),
+		Hash:         hashConfigBlob(blob.Bytes(), config),
+		RawHash:      hashByteSlice(blob.Bytes()),
+		BackupType:   config.BackupType,
+		ModifiedDate: modifiedDate,
+		FilePath:     filepath,
+		Blob:         blob.Bytes(),
+	}, nil
+}
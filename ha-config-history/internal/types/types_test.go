@@ -0,0 +1,41 @@
+package types_test
+
+import (
+	"ha-config-history/internal/types"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestNewKeyedYamlConfigBackup(t *testing.T) {
+	options := types.NewMappingConfigBackupOptions("Scripts", "scripts.yaml", "alias")
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "hello"}
+
+	t.Run("Stores the key and its value", func(t *testing.T) {
+		valueNode := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "alias"},
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "Hello"},
+		}}
+
+		configBackup, err := types.NewKeyedYamlConfigBackup("scripts.yaml", "scripts.yaml", keyNode, valueNode, options,
+			time.Now())
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if configBackup.ID != "hello" || configBackup.FriendlyName != "Hello" ||
+			string(configBackup.Blob) != "hello:\n    alias: Hello\n" {
+			t.Errorf("Unexpected config backup: %+v", configBackup)
+		}
+	})
+
+	t.Run("Returns an error when the value can't be serialized", func(t *testing.T) {
+		valueNode := &yaml.Node{Kind: yaml.AliasNode}
+
+		configBackup, err := types.NewKeyedYamlConfigBackup("scripts.yaml", "scripts.yaml", keyNode, valueNode, options,
+			time.Now())
+		if err == nil {
+			t.Fatalf("Expected an error, got: %+v", configBackup)
+		}
+	})
+}
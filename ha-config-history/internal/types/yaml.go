@@ -3,6 +3,7 @@ package types
 import (
 	"crypto/sha1"
 	"encoding/base64"
+	"strconv"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,13 +15,47 @@ func hashByteSlice(bytes []byte) string {
 	return sha
 }
 
+// GetYamlNodeValue evaluates a node expression (see expression.go) against a YAML node,
+// returning "unknown" if nothing matches
 func GetYamlNodeValue(yamlNode *yaml.Node, key string) string {
-	for i := 0; i < len(yamlNode.Content)-1; i += 2 {
-		keyNode := yamlNode.Content[i]
-		valueNode := yamlNode.Content[i+1]
-		if keyNode.Value == key {
-			return valueNode.Value
+	return evaluateNodeExpression(key, func(path []string) (string, bool) {
+		node := yamlNode
+		for _, segment := range path {
+			node = yamlChild(node, segment)
+			if node == nil {
+				return "", false
+			}
+		}
+
+		if node.Kind != yaml.ScalarNode || node.Value == "" {
+			return "", false
+		}
+		return node.Value, true
+	})
+}
+
+func yamlChild(node *yaml.Node, segment string) *yaml.Node {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return yamlChild(node.Content[0], segment)
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content)-1; i += 2 {
+			if node.Content[i].Value == segment {
+				return node.Content[i+1]
+			}
+		}
+	case yaml.SequenceNode:
+		index, err := strconv.Atoi(segment)
+		if err == nil && index >= 0 && index < len(node.Content) {
+			return node.Content[index]
+		}
+	case yaml.AliasNode:
+		if node.Alias != nil {
+			return yamlChild(node.Alias, segment)
 		}
 	}
-	return "unknown"
+	return nil
 }
@@ -2,13 +2,16 @@ package main
 
 import (
 	"embed"
+	"flag"
 	"ha-config-history/internal/api"
+	"ha-config-history/internal/cli"
 	"ha-config-history/internal/core"
 	"ha-config-history/internal/types"
 
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -30,6 +33,22 @@ var distFS embed.FS
 var appFS embed.FS
 
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] != "serve" && !strings.HasPrefix(args[0], "-") {
+		os.Exit(cli.Run(args, os.Stdout, os.Stderr))
+	}
+	if len(args) > 0 && args[0] == "serve" {
+		args = args[1:]
+	}
+
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "settings file")
+	_ = flags.Parse(args)
+
+	serve(*configPath)
+}
+
+func serve(configPath string) {
 	startTime = time.Now()
 
 	// Set up structured logging
@@ -38,13 +57,27 @@ func main() {
 	}))
 	slog.SetDefault(logger)
 
-	config := types.LoadConfig("config.json")
+	config, err := types.LoadConfig(configPath)
+	if err != nil {
+		slog.Error("Unable to load settings, fix or remove the config file to start", "error", err)
+		os.Exit(1)
+	}
+	if validation := core.ValidateSettings(config); !validation.Valid() {
+		slog.Error("Invalid settings, fix the config file to start", "error", validation.Err())
+		os.Exit(1)
+	}
 
 	server := core.NewServer(config)
 	server.Start()
+	if err := server.WatchSettingsFile(configPath); err != nil {
+		slog.Warn("Unable to watch settings file, external edits need a restart", "error", err)
+	}
 
 	r := gin.New()
 
+	// Groups for included files can be nested paths, which are sent with encoded slashes
+	r.UseRawPath = true
+
 	// Request ID middleware
 	r.Use(func(c *gin.Context) {
 		requestID := uuid.New().String()
@@ -73,18 +106,40 @@ func main() {
 	r.GET("/configs/:group/:id/backups", api.ListConfigBackupsHandler(server))
 	r.GET("/configs/:group/:id/backups/:filename", api.GetConfigBackupHandler(server))
 	r.GET("/configs/:group/:id/compare/:left/diff/:right", api.GetBackupDiffHandler(server))
+	r.GET("/configs/:group/:id/compare/:left/structural/:right", api.GetStructuralDiffHandler(server))
+	r.GET("/configs/:group/:id/compare/:left/live", api.GetLiveDiffHandler(server))
+	r.GET("/compare", api.CompareVersionsHandler(server))
+	r.GET("/changes", api.GetSnapshotDiffHandler(server))
 	r.POST("/configs/:group/:id/backups/:filename/restore", api.RestoreBackupHandler(server))
 	r.DELETE("/configs/:group/:id/backups/:filename", api.DeleteConfigBackupHandler(server))
 	r.DELETE("/configs/:group/:id", api.DeleteAllConfigBackupsHandler(server))
 	r.POST("/backup", api.ProcessConfigsHandler(server))
+	r.GET("/import", api.ListHABackupsHandler(server))
+	r.POST("/import", api.ImportHABackupsHandler(server))
+	r.GET("/jobs", api.ListJobsHandler(server))
+	r.GET("/jobs/:id", api.GetJobHandler(server))
+	r.POST("/jobs/:id/cancel", api.CancelJobHandler(server))
+	r.GET("/includes", api.GetIncludesHandler(server))
+	r.GET("/pause", api.GetPauseHandler(server))
+	r.POST("/pause", api.PauseHandler(server))
+	r.POST("/resume", api.ResumeHandler(server))
 	r.GET("/settings", api.GetSettingsHandler(server))
 	r.PUT("/settings", api.UpdateSettingsHandler(server))
+	r.POST("/settings/validate", api.ValidateSettingsHandler(server))
+	r.GET("/settings/defaults", api.GetNewDefaultConfigsHandler(server))
+	r.GET("/settings/history", api.ListSettingsVersionsHandler(server))
+	r.GET("/settings/history/:filename", api.GetSettingsVersionHandler(server))
+	r.GET("/settings/history/:filename/diff/:right", api.GetSettingsVersionDiffHandler(server))
+	r.POST("/settings/history/:filename/rollback", api.RollbackSettingsHandler(server))
 
 	// Health check endpoint
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
-			"status": "ok",
-			"uptime": time.Since(startTime).String(),
+			"status":   "ok",
+			"uptime":   time.Since(startTime).String(),
+			"watchers": server.WatcherStatus(),
+			"queue":    server.QueueStats(),
+			"paused":   server.PauseStatus(),
 		})
 	})
 